@@ -11,9 +11,12 @@ import (
 )
 
 type IPView struct {
-	Ok        bool     `json:"ok"`
-	Whitelist []string `json:"whitelist"`
-	Blacklist []string `json:"blacklist"`
+	Ok            bool             `json:"ok"`
+	Whitelist     []string         `json:"whitelist"`
+	Blacklist     []string         `json:"blacklist"`
+	AutoBlacklist []string         `json:"auto_blacklist"`
+	WhitelistTTL  map[string]int64 `json:"whitelist_expires_in"`
+	BlacklistTTL  map[string]int64 `json:"blacklist_expires_in"`
 }
 
 func main() {
@@ -23,14 +26,46 @@ func main() {
 	pflag.String("whitelist-del", "", "remove IP(s) from whitelist (comma-separated)")
 	pflag.String("blacklist-add", "", "add IP(s) to blacklist (comma-separated)")
 	pflag.String("blacklist-del", "", "remove IP(s) from blacklist (comma-separated)")
+	pflag.String("ttl", "", "expire added IP(s) after this duration, e.g. 30m (default: never)")
 	pflag.Bool("view-lists", false, "view whitelist and blacklist")
+	pflag.String("override-add", "", "give login:NAME or cidr:NETWORK limits of its own, set with --capacity, --refill, --algorithm and --window")
+	pflag.String("override-del", "", "remove the override of login:NAME or cidr:NETWORK")
+	pflag.Int("capacity", 0, "bucket capacity of the override")
+	pflag.Int("refill", 0, "tokens refilled per minute by the override")
+	pflag.String("algorithm", "", "algorithm of the override, e.g. gcra (default: token_bucket)")
+	pflag.String("window", "", "window of the override, e.g. 10m (default: 1m)")
+	pflag.Bool("view-overrides", false, "view the rate-limit overrides")
+	pflag.Bool("audit", false, "view the audit log of admin calls, newest first")
+	pflag.String("audit-actor", "", "only show audit entries of this actor")
+	pflag.String("audit-action", "", "only show audit entries of this action, e.g. whitelist.add")
+	pflag.String("audit-since", "", "only show audit entries since this RFC 3339 time or duration ago, e.g. 24h")
+	pflag.Int("audit-limit", 0, "show at most this many audit entries (default: server default)")
+	pflag.String("token", "", "API key or bearer token for the admin endpoints")
+	pflag.String("cacert", "", "CA certificate file to verify the server with (default: system roots)")
+	pflag.String("cert", "", "client certificate file for mutual TLS")
+	pflag.String("key", "", "client certificate key file for mutual TLS")
+	pflag.String("config", "", "config file (JSON, YAML or TOML) holding addr, token and the TLS options")
 
 	pflag.Parse()
 	_ = viper.BindPFlags(pflag.CommandLine)
 	viper.SetEnvPrefix("cli")
 	viper.AutomaticEnv()
+	if path := viper.GetString("config"); path != "" {
+		viper.SetConfigFile(path)
+		if err := viper.ReadInConfig(); err != nil {
+			log.Fatalf("failed to read config: %v", err)
+		}
+	}
 
 	addr := viper.GetString("addr")
+	token = viper.GetString("token")
+	if ca, cert, key := viper.GetString("cacert"), viper.GetString("cert"), viper.GetString("key"); ca != "" || cert != "" || key != "" {
+		c, err := newClient(ca, cert, key)
+		if err != nil {
+			log.Fatalf("invalid TLS options: %v", err)
+		}
+		client = c
+	}
 
 	if viper.GetBool("reset-all") {
 		resetBuckets(addr)
@@ -38,7 +73,7 @@ func main() {
 	}
 
 	if ips := viper.GetString("whitelist-add"); ips != "" {
-		whitelistAdd(addr, ips)
+		whitelistAdd(addr, ips, viper.GetString("ttl"))
 		return
 	}
 
@@ -48,7 +83,7 @@ func main() {
 	}
 
 	if ips := viper.GetString("blacklist-add"); ips != "" {
-		blacklistAdd(addr, ips)
+		blacklistAdd(addr, ips, viper.GetString("ttl"))
 		return
 	}
 
@@ -57,6 +92,32 @@ func main() {
 		return
 	}
 
+	if target := viper.GetString("override-add"); target != "" {
+		kind, match := splitTarget(target)
+		overrideAdd(addr, override{
+			Kind: kind, Match: match,
+			Algorithm: viper.GetString("algorithm"), Capacity: viper.GetInt("capacity"),
+			RefillPerMinute: viper.GetInt("refill"), Window: viper.GetString("window"),
+		})
+		return
+	}
+
+	if target := viper.GetString("override-del"); target != "" {
+		overrideDel(addr, target)
+		return
+	}
+
+	if viper.GetBool("view-overrides") {
+		viewOverrides(addr)
+		return
+	}
+
+	if viper.GetBool("audit") {
+		viewAudit(addr, viper.GetString("audit-actor"), viper.GetString("audit-action"),
+			viper.GetString("audit-since"), viper.GetInt("audit-limit"))
+		return
+	}
+
 	if viper.GetBool("view-lists") {
 		resp := doGet(addr + "/api/view/lists")
 		var ipView IPView
@@ -64,12 +125,15 @@ func main() {
 			log.Fatalf("failed to unmarshal response: %v", err)
 		}
 		if len(ipView.Whitelist) > 0 {
-			fmt.Printf("Whitelisted: %s\n", strings.Join(ipView.Whitelist, ", "))
+			fmt.Printf("Whitelisted: %s\n", strings.Join(withTTL(ipView.Whitelist, ipView.WhitelistTTL), ", "))
 		} else {
 			fmt.Printf("Whitelist is empty\n")
 		}
+		if len(ipView.AutoBlacklist) > 0 {
+			fmt.Printf("Auto-blacklisted: %s\n", strings.Join(withTTL(ipView.AutoBlacklist, ipView.BlacklistTTL), ", "))
+		}
 		if len(ipView.Blacklist) > 0 {
-			fmt.Printf("Blacklisted: %s\n", strings.Join(ipView.Blacklist, ", "))
+			fmt.Printf("Blacklisted: %s\n", strings.Join(withTTL(ipView.Blacklist, ipView.BlacklistTTL), ", "))
 			return
 		}
 		fmt.Printf("Blacklist is empty\n")
@@ -2,12 +2,19 @@ package main
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type response struct {
@@ -15,6 +22,39 @@ type response struct {
 	Reason string `json:"reason,omitempty"`
 }
 
+// token is sent as a bearer token with every request when set.
+var token string
+
+// client sends every request, newClient replaces it when TLS options are set.
+var client = http.DefaultClient
+
+// newClient returns a client trusting the CA in caFile, on top of the system
+// roots when empty, and presenting the certificate in certFile and keyFile
+// when set.
+func newClient(caFile, certFile, keyFile string) (*http.Client, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+	return &http.Client{Transport: transport}, nil
+}
+
 func doPost(url string, data any) []byte {
 	var body *bytes.Reader
 	if data != nil {
@@ -23,37 +63,45 @@ func doPost(url string, data any) []byte {
 	} else {
 		body = bytes.NewReader([]byte{})
 	}
-	//nolint: gosec // reason - предположительно, что админ в курсе
-	resp, err := http.Post(url, "application/json", body) //nolint: noctx
-	if err != nil {
-		log.Fatalf("POST %s failed: %v", url, err)
-	}
-	defer resp.Body.Close()
-	buf := new(bytes.Buffer)
-	buf.ReadFrom(resp.Body)
-	if resp.StatusCode != http.StatusOK {
-		resp.Body.Close()
-		//nolint: gocritic
-		log.Fatalf("server error: %s, response: %s", resp.Status, buf.String())
-	}
-	return buf.Bytes()
+	return do(http.MethodPost, url, body)
 }
 
 func doGet(url string) []byte {
+	return do(http.MethodGet, url, nil)
+}
+
+func do(method, url string, body io.Reader) []byte {
+	req, err := http.NewRequest(method, url, body) //nolint: noctx
+	if err != nil {
+		log.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
 	//nolint: gosec // reason - предположительно, что админ в курсе
-	resp, err := http.Get(url) //nolint: noctx
+	resp, err := client.Do(req)
 	if err != nil {
-		log.Fatalf("GET %s failed: %v", url, err)
+		log.Fatalf("%s %s failed: %v", method, url, err)
 	}
 	defer resp.Body.Close()
 	buf := new(bytes.Buffer)
 	buf.ReadFrom(resp.Body)
-	if resp.StatusCode != http.StatusOK {
-		resp.Body.Close()
-		//nolint: gocritic
-		log.Fatalf("server error: %s, response: %s", resp.Status, buf.String())
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return buf.Bytes()
+	case http.StatusUnauthorized:
+		log.Fatalf("server refused the credentials (%s): set --token, CLI_TOKEN or token in the --config file, "+
+			"and --cert and --key when the server verifies client certificates", resp.Status)
+	case http.StatusForbidden:
+		log.Fatalf("the token lacks the role for %s (%s): %s", url, resp.Status, strings.TrimSpace(buf.String()))
 	}
-	return buf.Bytes()
+	resp.Body.Close()
+	//nolint: gocritic
+	log.Fatalf("server error: %s, response: %s", resp.Status, buf.String())
+	return nil
 }
 
 func expandIPs(input string) []string {
@@ -74,7 +122,17 @@ func expandIPs(input string) []string {
 			if start == nil || end == nil {
 				log.Fatalf("invalid IP in range: %s", raw)
 			}
-			for ip := start; ; ip = nextIP(ip) {
+			start, end = normalizeIP(start), normalizeIP(end)
+			if len(start) != len(end) {
+				log.Fatalf("mixed IPv4 and IPv6 in range: %s", raw)
+			}
+			if bytes.Compare(start, end) > 0 {
+				log.Fatalf("range start is after range end: %s", raw)
+			}
+			for ip, n := start, 0; ; ip, n = nextIP(ip), n+1 {
+				if n >= maxRangeSize {
+					log.Fatalf("IP range too large, at most %d addresses: %s", maxRangeSize, raw)
+				}
 				results = append(results, ip.String())
 				if ip.Equal(end) {
 					break
@@ -98,11 +156,18 @@ func expandIPs(input string) []string {
 	return results
 }
 
-func nextIP(ip net.IP) net.IP {
-	ip = ip.To4()
-	if ip == nil {
-		log.Fatalf("only IPv4 supported: %s", ip)
+// maxRangeSize bounds start-end expansion, IPv6 ranges can be astronomically large.
+const maxRangeSize = 65536
+
+func normalizeIP(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
 	}
+	return ip.To16()
+}
+
+func nextIP(ip net.IP) net.IP {
+	ip = normalizeIP(ip)
 	next := make(net.IP, len(ip))
 	copy(next, ip)
 	for i := len(next) - 1; i >= 0; i-- {
@@ -114,6 +179,25 @@ func nextIP(ip net.IP) net.IP {
 	return next
 }
 
+func listRequest(ip string, ttl string) map[string]string {
+	if ttl == "" {
+		return map[string]string{"ip": ip}
+	}
+	return map[string]string{"ip": ip, "ttl": ttl}
+}
+
+// withTTL appends the time left to the entries that expire.
+func withTTL(entries []string, expiresIn map[string]int64) []string {
+	out := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if left, ok := expiresIn[entry]; ok {
+			entry = fmt.Sprintf("%s (expires in %s)", entry, time.Duration(left)*time.Second)
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
 func resetBuckets(addr string) {
 	var resp response
 	if err := json.Unmarshal(doPost(addr+"/api/bucket/reset", nil), &resp); err != nil {
@@ -130,10 +214,10 @@ func resetBuckets(addr string) {
 	fmt.Println("✅ All buckets successfully reset")
 }
 
-func whitelistAdd(addr string, ip string) {
+func whitelistAdd(addr string, ip string, ttl string) {
 	var resp response
 	for _, ip := range expandIPs(ip) {
-		if err := json.Unmarshal(doPost(addr+"/api/whitelist/add", map[string]string{"ip": ip}), &resp); err != nil {
+		if err := json.Unmarshal(doPost(addr+"/api/whitelist/add", listRequest(ip, ttl)), &resp); err != nil {
 			fmt.Printf("❌ Failed to whitelist %s: %v\n", ip, err)
 		}
 		if !resp.Ok {
@@ -167,10 +251,10 @@ func whitelistDel(addr string, ip string) {
 	}
 }
 
-func blacklistAdd(addr string, ip string) {
+func blacklistAdd(addr string, ip string, ttl string) {
 	var resp response
 	for _, ip := range expandIPs(ip) {
-		if err := json.Unmarshal(doPost(addr+"/api/blacklist/add", map[string]string{"ip": ip}), &resp); err != nil {
+		if err := json.Unmarshal(doPost(addr+"/api/blacklist/add", listRequest(ip, ttl)), &resp); err != nil {
 			fmt.Printf("❌ Failed to blacklist %s: %v\n", ip, err)
 		}
 		if !resp.Ok {
@@ -201,3 +285,113 @@ func blacklistDel(addr string, ips string) {
 		fmt.Printf("✅ Removed %s from blacklist\n", ip)
 	}
 }
+
+type auditEntry struct {
+	Time     time.Time       `json:"time"`
+	Actor    string          `json:"actor"`
+	Action   string          `json:"action"`
+	SourceIP string          `json:"source_ip"`
+	Payload  json.RawMessage `json:"payload"`
+	Status   string          `json:"status"`
+	OK       bool            `json:"ok"`
+	Result   json.RawMessage `json:"result"`
+}
+
+func viewAudit(addr, actor, action, since string, limit int) {
+	q := url.Values{}
+	for key, value := range map[string]string{"actor": actor, "action": action, "since": since} {
+		if value != "" {
+			q.Set(key, value)
+		}
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	var resp struct {
+		Entries []auditEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(doGet(addr+"/api/audit?"+q.Encode()), &resp); err != nil {
+		log.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(resp.Entries) == 0 {
+		fmt.Println("No audit entries")
+		return
+	}
+	for _, e := range resp.Entries {
+		mark := "✅"
+		if !e.OK {
+			mark = "❌"
+		}
+		fmt.Printf("%s %s %s by %s from %s: %s -> %s %s\n",
+			mark, e.Time.Local().Format(time.DateTime), e.Action, e.Actor, e.SourceIP, e.Payload, e.Status, e.Result)
+	}
+}
+
+type override struct {
+	Kind            string `json:"kind"`
+	Match           string `json:"match"`
+	Algorithm       string `json:"algorithm,omitempty"`
+	Capacity        int    `json:"capacity"`
+	RefillPerMinute int    `json:"refill_per_minute"`
+	Window          string `json:"window,omitempty"`
+}
+
+// splitTarget splits a "login:NAME" or "cidr:NETWORK" target.
+func splitTarget(target string) (kind, match string) {
+	kind, match, ok := strings.Cut(target, ":")
+	if !ok || (kind != "login" && kind != "cidr") {
+		log.Fatalf("invalid override target %q: expected login:NAME or cidr:NETWORK", target)
+	}
+	return kind, match
+}
+
+func overrideAdd(addr string, o override) {
+	var resp response
+	if err := json.Unmarshal(doPost(addr+"/api/override/add", o), &resp); err != nil {
+		fmt.Printf("❌ Failed to add override for %s %s: %v\n", o.Kind, o.Match, err)
+		return
+	}
+	if !resp.Ok {
+		fmt.Printf("❌ Failed to add override for %s %s: %s\n", o.Kind, o.Match, resp.Reason)
+		return
+	}
+	fmt.Printf("✅ Override for %s %s set\n", o.Kind, o.Match)
+}
+
+func overrideDel(addr string, target string) {
+	kind, match := splitTarget(target)
+	var resp response
+	if err := json.Unmarshal(doPost(addr+"/api/override/del", map[string]string{"kind": kind, "match": match}), &resp); err != nil {
+		fmt.Printf("❌ Failed to remove override for %s %s: %v\n", kind, match, err)
+		return
+	}
+	if !resp.Ok {
+		fmt.Printf("❌ Failed to remove override for %s %s: %s\n", kind, match, resp.Reason)
+		return
+	}
+	fmt.Printf("✅ Removed override for %s %s\n", kind, match)
+}
+
+func viewOverrides(addr string) {
+	var resp struct {
+		Overrides []override `json:"overrides"`
+	}
+	if err := json.Unmarshal(doGet(addr+"/api/view/overrides"), &resp); err != nil {
+		log.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(resp.Overrides) == 0 {
+		fmt.Println("No overrides")
+		return
+	}
+	for _, o := range resp.Overrides {
+		algorithm, window := o.Algorithm, o.Window
+		if algorithm == "" {
+			algorithm = "token_bucket"
+		}
+		if window == "" {
+			window = "1m"
+		}
+		fmt.Printf("%s %s: %s capacity %d, refill %d/min, window %s\n",
+			o.Kind, o.Match, algorithm, o.Capacity, o.RefillPerMinute, window)
+	}
+}
@@ -1,42 +1,509 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/meladark/special-train/configs"
 	"github.com/meladark/special-train/internal/api"
 	"github.com/meladark/special-train/internal/app"
+	"github.com/meladark/special-train/internal/audit"
+	"github.com/meladark/special-train/internal/auth"
+	"github.com/meladark/special-train/internal/autoban"
 	"github.com/meladark/special-train/internal/bucket"
+	"github.com/meladark/special-train/internal/gateway"
+	"github.com/meladark/special-train/internal/logging"
+	"github.com/meladark/special-train/internal/metrics"
+	"github.com/meladark/special-train/internal/overrides"
+	"github.com/meladark/special-train/internal/proxy"
 	"github.com/meladark/special-train/internal/service"
 	"github.com/meladark/special-train/internal/storage"
+	"github.com/meladark/special-train/internal/tracing"
 	"github.com/redis/go-redis/v9"
+	"github.com/spf13/pflag"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 func main() {
-	cfg := configs.LoadConfig()
-	store := storage.NewInMemoryStorage()
+	check := pflag.Bool("check-config", false, "print the effective config, check it and exit, nonzero when it has problems")
+	configFile := pflag.String("config", "", "config file (JSON, YAML or TOML), overrides CONFIG_FILE")
+	sets := pflag.StringArray("set", nil, "override a setting named like its environment variable, e.g. --set CAPACITY_LOGIN=20; repeatable")
+	pflag.Parse()
+	if pflag.CommandLine.Changed("config") {
+		*sets = append(*sets, "CONFIG_FILE="+*configFile)
+	}
+	for _, set := range *sets {
+		key, value, ok := strings.Cut(set, "=")
+		if !ok {
+			log.Fatalf("invalid --set %q: expected KEY=VALUE", set)
+		}
+		if err := configs.Override(key, value); err != nil {
+			log.Fatalf("invalid --set %q: %v", set, err)
+		}
+	}
+	cfg, err := configs.LoadConfig()
+	if err != nil {
+		log.Fatalf("invalid config: %v", err)
+	}
+	if *check {
+		os.Exit(checkConfig(cfg))
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("invalid config:\n%v", err)
+	}
+	logLevel := new(slog.LevelVar)
+	logger, err := logging.New(os.Stdout, logging.Config{
+		Level:        cfg.LogLevel,
+		Format:       cfg.LogFormat,
+		RedactLogins: cfg.LogRedactLogins,
+		LevelVar:     logLevel,
+	})
+	if err != nil {
+		log.Fatalf("invalid config: %v", err)
+	}
+	slog.SetDefault(logger)
+	slog.Info("service configuration", "config", cfg)
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    cfg.TracingExporter,
+		Endpoint:    cfg.TracingEndpoint,
+		Insecure:    cfg.TracingInsecure,
+		SampleRatio: cfg.TracingSampleRatio,
+	})
+	if err != nil {
+		fatal("invalid config", "err", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("failed to flush traces", "err", err)
+		}
+	}()
 	rdb := redis.NewClient(&redis.Options{
 		Addr: cfg.RedisAddr,
 		DB:   0,
 	})
 	defer func() {
 		if err := rdb.Close(); err != nil {
-			log.Printf("failed to close redis: %v", err)
+			slog.Error("failed to close redis", "err", err)
 		}
 	}()
-	rl := bucket.NewRateLimiter(rdb, 5*time.Minute,
-		bucket.Config{Capacity: cfg.CLogin, RefillPerMinute: cfg.RLogin},
-		bucket.Config{Capacity: cfg.CPass, RefillPerMinute: cfg.RPass},
-		bucket.Config{Capacity: cfg.CIP, RefillPerMinute: cfg.RIP})
+	var store storage.Storage
+	switch cfg.Storage {
+	case "redis":
+		store = storage.NewRedisStorage(rdb)
+	case "memory":
+		store = storage.NewInMemoryStorage()
+	default:
+		fatal("unknown storage, expected memory or redis", "storage", cfg.Storage)
+	}
+	defer store.Close()
+	err = metrics.RegisterListSizes(func() (int, int, error) {
+		whitelist, blacklist, err := store.BlackWhiteLists(context.Background())
+		if err != nil {
+			slog.Warn("failed to read the lists for metrics", "err", err)
+		}
+		return len(whitelist), len(blacklist), err
+	})
+	if err != nil {
+		fatal("failed to register metrics", "err", err)
+	}
+	loginCfg, passCfg, ipCfg := cfg.Buckets()
+	consumeMode, err := bucket.ParseConsumeMode(cfg.ConsumeMode)
+	if err != nil {
+		fatal("invalid config", "err", err)
+	}
+	policy, err := service.ParseFailurePolicy(cfg.FailurePolicy)
+	if err != nil {
+		fatal("invalid config", "err", err)
+	}
+	onSuccess, err := service.ParseSuccessPolicy(cfg.ReportSuccess)
+	if err != nil {
+		fatal("invalid config", "err", err)
+	}
+	passwords, err := cfg.PasswordHasher()
+	if err != nil {
+		fatal("invalid config", "err", err)
+	}
+	if cfg.EphemeralPasswordKey() {
+		slog.Warn("PASSWORD_HASH_KEY is not set, password buckets are keyed with a random key: "+
+			"they reset on restart and replicas do not share them; set PASSWORD_HASH_KEY in production",
+			"rate_limiter", cfg.Limiter)
+	}
+	table, err := newOverrides(cfg, rdb)
+	if err != nil {
+		fatal("failed to load rate-limit overrides", "err", err)
+	}
+	defer table.Close()
+	var rl, fallback bucket.Backend
+	var limiters []limitSetter
+	switch cfg.Limiter {
+	case "redis":
+		redisRL := bucket.NewRateLimiter(rdb, 5*time.Minute, loginCfg, passCfg, ipCfg)
+		redisRL.SetPasswordHasher(passwords)
+		redisRL.SetOverrides(table)
+		redisRL.SetCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown)
+		rl = redisRL
+		limiters = append(limiters, redisRL)
+		if policy == service.FailLocal {
+			localRL := bucket.NewMemoryRateLimiter(5*time.Minute, loginCfg, passCfg, ipCfg)
+			localRL.SetPasswordHasher(passwords)
+			localRL.SetOverrides(table)
+			defer localRL.Close()
+			fallback = localRL
+			limiters = append(limiters, localRL)
+		}
+	case "memory":
+		memRL := bucket.NewMemoryRateLimiter(5*time.Minute, loginCfg, passCfg, ipCfg)
+		memRL.SetPasswordHasher(passwords)
+		memRL.SetOverrides(table)
+		defer memRL.Close()
+		rl = memRL
+		limiters = append(limiters, memRL)
+	default:
+		fatal("unknown rate limiter, expected redis or memory", "rate_limiter", cfg.Limiter)
+	}
+	subnets, err := cfg.Subnets()
+	if err != nil {
+		fatal("invalid config", "err", err)
+	}
+	for _, l := range limiters {
+		if err := l.SetLimits(loginCfg, passCfg, ipCfg, subnets, consumeMode); err != nil {
+			fatal("invalid config", "err", err)
+		}
+	}
 	svc := service.New(store, rl)
-	router := api.NewRouter(svc)
+	svc.SetFailurePolicy(policy, fallback)
+	svc.SetReportPolicy(onSuccess, cfg.ReportPenalty)
+	svc.SetOverrides(table)
+	ban := autoban.New(autoban.Config{
+		Threshold: cfg.AutoBanThreshold,
+		Window:    cfg.AutoBanWindow,
+		BanTTL:    cfg.AutoBanTTL,
+		PrefixV4:  cfg.AutoBanPrefixV4,
+		PrefixV6:  cfg.AutoBanPrefixV6,
+	}, store)
+	ban.Start()
+	defer ban.Close()
+	svc.SetAutoBan(ban)
+	gw := gateway.Config{
+		Prefer:         gateway.Source(cfg.GatewayPrefer),
+		LoginHeader:    cfg.GatewayLoginHeader,
+		PasswordHeader: cfg.GatewayPasswordHeader,
+		IPHeader:       cfg.GatewayIPHeader,
+		TrustedHops:    cfg.GatewayTrustedHops,
+		LoginField:     cfg.GatewayLoginField,
+		PasswordField:  cfg.GatewayPasswordField,
+	}
+	authn, err := newAuthenticator(cfg)
+	if err != nil {
+		fatal("invalid config", "err", err)
+	}
+	tlsCfg, err := newTLSConfig(cfg, authn)
+	if err != nil {
+		fatal("invalid tls config", "err", err)
+	}
+	switch {
+	case !authn.Enabled():
+		slog.Warn("AUTH_DISABLED is set, admin endpoints are open to anyone")
+	case !authn.HasKeys() && (cfg.TLSClientAuth == "" || cfg.TLSClientAuth == string(app.ClientAuthNone)):
+		slog.Warn("no API keys configured, admin endpoints refuse every request; " +
+			"set AUTH_KEYS or AUTH_KEYS_FILE, or AUTH_DISABLED=true to open them")
+	}
+	auditLog, err := newAuditLog(cfg, rdb)
+	if err != nil {
+		fatal("invalid audit config", "err", err)
+	}
+	// live is the config in force, reloads swap it.
+	var live atomic.Pointer[configs.Config]
+	live.Store(&cfg)
+	router := api.NewRouter(svc, gw, authn, auditLog, func() map[string]any {
+		return live.Load().Effective()
+	})
 	srv := app.NewServer(":"+cfg.Port, router)
+	var grpcOpts []grpc.ServerOption
+	if tlsCfg != nil {
+		srv.SetTLS(tlsCfg)
+		grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+	}
+	if cfg.GRPCPort != "" {
+		srv.SetGRPCServer(":"+cfg.GRPCPort, api.NewGRPCServer(svc, gw, authn, auditLog, grpcOpts...))
+	}
+	if cfg.ProxyUpstream != "" {
+		upstream, err := url.Parse(cfg.ProxyUpstream)
+		if err != nil || upstream.Host == "" {
+			fatal("invalid proxy upstream", "upstream", cfg.ProxyUpstream)
+		}
+		failures, err := proxy.ParseStatuses(cfg.ProxyFailureStatuses)
+		if err != nil {
+			fatal("invalid config", "err", err)
+		}
+		// Clients talk to the proxy directly, so credentials come from the
+		// body the upstream sees and never from headers they could forge.
+		proxyGW := gateway.Config{
+			Prefer:        gateway.SourceBody,
+			IPHeader:      cfg.ProxyIPHeader,
+			TrustedHops:   cfg.ProxyTrustedHops,
+			LoginField:    cfg.GatewayLoginField,
+			PasswordField: cfg.GatewayPasswordField,
+		}
+		handler := proxy.New(svc, upstream, cfg.ProxyLoginPath, failures, proxyGW)
+		srv.SetProxy(":"+cfg.ProxyPort, logging.Middleware(otelhttp.NewHandler(handler, "proxy")))
+	}
+	var files []string
+	if cfg.ConfigFile != "" {
+		files = append(files, cfg.ConfigFile)
+	}
+	// loaded is the config last read, restart-only changes included. The
+	// reloader runs one reload at a time.
+	loaded := cfg
+	reloader, err := app.NewReloader(files, func(trigger string) {
+		var next configs.Config
+		loaded, next = reloadConfig(trigger, loaded, *live.Load(), limiters, logLevel)
+		live.Store(&next)
+	})
+	if err != nil {
+		fatal("failed to watch the config", "err", err)
+	}
+	defer reloader.Close()
 	if err := srv.Run(); err != nil {
 		if err := rdb.Close(); err != nil {
-			log.Printf("failed to close redis: %v", err)
+			slog.Error("failed to close redis", "err", err)
 		}
-		//nolint: gocritic
-		log.Fatalf("server error: %v", err)
+		fatal("server error", "err", err)
+	}
+}
+
+// newAuthenticator builds the authenticator from the inline keys and the
+// keys file of cfg, or one letting everyone through with AUTH_DISABLED.
+func newAuthenticator(cfg configs.Config) (*auth.Authenticator, error) {
+	if cfg.AuthDisabled {
+		return auth.NewDisabled(), nil
+	}
+	keys, err := auth.ParseKeys(cfg.AuthKeys)
+	if err != nil {
+		return nil, err
 	}
+	if cfg.AuthKeysFile != "" {
+		fileKeys, err := auth.LoadKeysFile(cfg.AuthKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fileKeys...)
+	}
+	return auth.New(keys)
+}
+
+// limitSetter is a rate limiter whose limits can change while it runs.
+type limitSetter interface {
+	SetLimits(loginCfg, passCfg, ipCfg bucket.Config, subnets []bucket.Subnet, mode bucket.ConsumeMode) error
+}
+
+// reloadable lists the fields reloadConfig applies to the running server,
+// the others only take effect after a restart.
+var reloadable = map[string]bool{
+	"CLogin": true, "CPass": true, "CIP": true,
+	"RLogin": true, "RPass": true, "RIP": true,
+	"ALogin": true, "APass": true, "AIP": true,
+	"WLogin": true, "WPass": true, "WIP": true,
+	"SubnetBuckets": true,
+	"ConsumeMode":   true,
+	"LogLevel":      true,
+}
+
+// reloadConfig reads the config again and applies its reloadable settings
+// to limiters and level. Changes are found against loaded, the config read
+// last, so a restart-only change is warned about once. A config that fails
+// validation is rejected as a whole and loaded and live are returned as they
+// are. Otherwise the new config is returned along with live carrying its
+// reloadable settings, as they are now in force.
+func reloadConfig(
+	trigger string,
+	loaded, live configs.Config,
+	limiters []limitSetter,
+	level *slog.LevelVar,
+) (nextLoaded, nextLive configs.Config) {
+	cfg, err := configs.Reload()
+	if err != nil {
+		slog.Error("config reload failed, keeping the current config", "trigger", trigger, "err", err)
+		return loaded, live
+	}
+	changed := loaded.Changed(cfg)
+	if len(changed) == 0 {
+		slog.Info("config reloaded, nothing changed", "trigger", trigger)
+		return loaded, live
+	}
+	if err := cfg.Validate(); err != nil {
+		slog.Error("config reload rejected, keeping the current config", "trigger", trigger, "err", err)
+		return loaded, live
+	}
+	newLevel, err := logging.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		slog.Error("config reload rejected, keeping the current config", "trigger", trigger, "err", err)
+		return loaded, live
+	}
+	loginCfg, passCfg, ipCfg := cfg.Buckets()
+	subnets, err := cfg.Subnets()
+	if err != nil {
+		slog.Error("config reload rejected, keeping the current config", "trigger", trigger, "err", err)
+		return loaded, live
+	}
+	// Every limiter validates the same limits, so only the first can refuse
+	// them, before anything changed.
+	for _, l := range limiters {
+		if err := l.SetLimits(loginCfg, passCfg, ipCfg, subnets, bucket.ConsumeMode(cfg.ConsumeMode)); err != nil {
+			slog.Error("config reload rejected, keeping the current config", "trigger", trigger, "err", err)
+			return loaded, live
+		}
+	}
+	level.Set(newLevel)
+	fields, next := reflect.ValueOf(&live).Elem(), reflect.ValueOf(cfg)
+	var restart []string
+	for _, name := range changed {
+		if !reloadable[name] {
+			restart = append(restart, name)
+			continue
+		}
+		fields.FieldByName(name).Set(next.FieldByName(name))
+	}
+	if len(restart) > 0 {
+		slog.Warn("some config changes only apply after a restart", "fields", restart)
+	}
+	slog.Info("config reloaded", "trigger", trigger, "changed", changed)
+	return cfg, live
+}
+
+// checkConfig prints the effective config to stdout and every problem found
+// in it to stderr, then returns the exit code of --check-config. Beyond
+// Validate it loads the keys file and the certificates, as startup would.
+func checkConfig(cfg configs.Config) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(cfg.Effective()); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to print the config: %v\n", err)
+		return 1
+	}
+	errs := []error{cfg.Validate()}
+	if cfg.AuthKeysFile != "" {
+		if _, err := auth.LoadKeysFile(cfg.AuthKeysFile); err != nil {
+			errs = append(errs, fmt.Errorf("AUTH_KEYS_FILE: %w", err))
+		}
+	}
+	if cfg.TLSCertFile != "" && cfg.TLSKeyFile != "" {
+		if _, err := app.NewCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSClientCAFile); err != nil {
+			errs = append(errs, fmt.Errorf("TLS_CERT_FILE, TLS_KEY_FILE, TLS_CLIENT_CA_FILE: %w", err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		fmt.Fprintf(os.Stderr, "config has problems:\n%v\n", err)
+		return 1
+	}
+	fmt.Fprintln(os.Stderr, "config OK")
+	return 0
+}
+
+// newOverrides loads the rate-limit overrides from Redis with the Redis
+// storage, so replicas share them, and from the overrides file otherwise. A
+// failed load from Redis is only a warning.
+func newOverrides(cfg configs.Config, rdb *redis.Client) (*overrides.Table, error) {
+	var store overrides.Store
+	switch {
+	case cfg.Storage == "redis":
+		store = overrides.NewRedisStore(rdb)
+	case cfg.OverridesFile != "":
+		fileStore, err := overrides.NewFileStore(cfg.OverridesFile)
+		if err != nil {
+			return nil, err
+		}
+		store = fileStore
+	default:
+		slog.Warn("no overrides file configured, rate-limit overrides are lost on restart; set OVERRIDES_FILE")
+		store = overrides.NewMemoryStore()
+	}
+	table := overrides.NewTable(store)
+	if cfg.Storage != "redis" {
+		if err := table.Load(context.Background()); err != nil {
+			return nil, err
+		}
+		return table, nil
+	}
+	// Redis may be down at startup: the table starts empty and Watch loads
+	// it once Redis answers.
+	if err := table.Load(context.Background()); err != nil {
+		slog.Warn("failed to load rate-limit overrides, starting without them", "err", err,
+			"retry_in", cfg.OverridesRefresh)
+	}
+	table.Watch(cfg.OverridesRefresh)
+	return table, nil
+}
+
+// newAuditLog opens the audit sink of cfg, nil when auditing is off.
+func newAuditLog(cfg configs.Config, rdb *redis.Client) (*audit.Log, error) {
+	switch cfg.AuditSink {
+	case "none", "":
+		return nil, nil
+	case "file":
+		sink, err := audit.NewFileSink(cfg.AuditFile)
+		if err != nil {
+			return nil, err
+		}
+		return audit.New(sink), nil
+	case "redis":
+		return audit.New(audit.NewRedisSink(rdb, cfg.AuditStream, cfg.AuditMaxLen)), nil
+	}
+	return nil, fmt.Errorf("unknown audit sink %q, expected none, file or redis", cfg.AuditSink)
+}
+
+// newTLSConfig loads the certificates of cfg and watches them for rotation.
+// It returns nil when TLS is off. When client certificates are verified,
+// authn demands one on the admin endpoints.
+func newTLSConfig(cfg configs.Config, authn *auth.Authenticator) (*tls.Config, error) {
+	clientAuth, err := app.ParseClientAuth(cfg.TLSClientAuth)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.TLSCertFile == "" && cfg.TLSKeyFile == "" {
+		if clientAuth != app.ClientAuthNone {
+			return nil, errors.New("client certificate verification needs TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	certs, err := app.NewCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsCfg, err := certs.TLSConfig(clientAuth)
+	if err != nil {
+		return nil, err
+	}
+	if err := certs.Watch(); err != nil {
+		return nil, err
+	}
+	if clientAuth != app.ClientAuthNone {
+		authn.RequireClientCert()
+	}
+	return tlsCfg, nil
+}
+
+// fatal logs msg at error level and exits, skipping deferred calls like
+// log.Fatal does.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
@@ -1,26 +1,195 @@
 package configs
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/meladark/special-train/internal/app"
+	"github.com/meladark/special-train/internal/auth"
+	"github.com/meladark/special-train/internal/bucket"
+	"github.com/meladark/special-train/internal/gateway"
+	"github.com/meladark/special-train/internal/logging"
+	"github.com/meladark/special-train/internal/proxy"
+	"github.com/meladark/special-train/internal/service"
+	"github.com/meladark/special-train/internal/tracing"
 
 	"github.com/spf13/viper"
 )
 
 type Config struct {
+	// ConfigFile is an optional YAML, TOML or JSON file holding the same
+	// keys as the environment, in any case. The environment wins over it.
+	ConfigFile string
+
 	Port      string
+	GRPCPort  string
 	RedisAddr string
+	Storage   string
+	Limiter   string
 	CLogin    int
 	CPass     int
 	CIP       int
 	RLogin    int
 	RPass     int
 	RIP       int
+	ALogin    string
+	APass     string
+	AIP       string
+	WLogin    time.Duration
+	WPass     time.Duration
+	WIP       time.Duration
+
+	// SubnetBuckets adds a bucket per network next to the one of the exact
+	// address, in the form bucket.ParseSubnets reads, e.g.
+	// "ipv4/24=100:100,ipv6/64=100:100".
+	SubnetBuckets string
+
+	ConsumeMode string
+
+	// PasswordHashKey is the HMAC secret naming password buckets.
+	// PasswordHashPreviousKeys, comma separated, are still checked after a
+	// rotation until PasswordHashPreviousUntil (RFC 3339), or for as long as
+	// they are set when it is empty.
+	PasswordHashKey           string
+	PasswordHashPreviousKeys  string
+	PasswordHashPreviousUntil string
+
+	FailurePolicy    string
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	ReportSuccess string
+	ReportPenalty int
+
+	TracingExporter    string
+	TracingEndpoint    string
+	TracingInsecure    bool
+	TracingSampleRatio float64
+
+	LogLevel        string
+	LogFormat       string
+	LogRedactLogins bool
+
+	// AuthKeys holds inline API keys as name:role+role:secret, comma separated.
+	// Without any key the admin endpoints refuse every request, unless a
+	// client certificate is required or AuthDisabled opens them to anyone.
+	AuthKeys     string
+	AuthKeysFile string
+	AuthDisabled bool
+
+	// TLSCertFile and TLSKeyFile turn on TLS for the API and gRPC servers.
+	// TLSClientAuth is none, admin or all; admin and all verify client
+	// certificates against TLSClientCAFile.
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+	TLSClientAuth   string
+
+	// AuditSink is none, file or redis. The file sink appends to AuditFile,
+	// the redis sink to the AuditStream stream, trimmed to about AuditMaxLen
+	// entries when positive.
+	AuditSink   string
+	AuditFile   string
+	AuditStream string
+	AuditMaxLen int64
+
+	AutoBanThreshold int
+	AutoBanWindow    time.Duration
+	AutoBanTTL       time.Duration
+	AutoBanPrefixV4  int
+	AutoBanPrefixV6  int
+
+	// Rate-limit overrides live in Redis with the Redis storage, otherwise
+	// in OverridesFile, or in memory only when it is empty. Every replica
+	// reloads them each OverridesRefresh to pick up changes made elsewhere.
+	OverridesFile    string
+	OverridesRefresh time.Duration
+
+	// GatewayPrefer is body or headers, the credential source that wins
+	// when a request carries both. GatewayTrustedHops and ProxyTrustedHops
+	// count the proxies that append to the IP header, the client address is
+	// the entry the outermost one added.
+	GatewayPrefer         string
+	GatewayLoginHeader    string
+	GatewayPasswordHeader string
+	GatewayIPHeader       string
+	GatewayTrustedHops    int
+	GatewayLoginField     string
+	GatewayPasswordField  string
+
+	// The proxy gives the tokens of a login back when the upstream answers
+	// 2xx or 3xx with a status not in ProxyFailureStatuses.
+	ProxyUpstream        string
+	ProxyPort            string
+	ProxyLoginPath       string
+	ProxyFailureStatuses string
+	ProxyIPHeader        string
+	ProxyTrustedHops     int
 }
 
-func LoadConfig() Config {
+// LoadConfig reads the environment and, when CONFIG_FILE is set, the config
+// file it names.
+func LoadConfig() (Config, error) {
+	setDefaults()
+	viper.AutomaticEnv()
+	if file := viper.GetString("CONFIG_FILE"); file != "" {
+		viper.SetConfigFile(file)
+		if err := viper.ReadInConfig(); err != nil {
+			return Config{}, fmt.Errorf("read config file: %w", err)
+		}
+	}
+	return current(), nil
+}
+
+// Override sets key, named like its environment variable, above the
+// environment and the config file. It backs command line flags and must be
+// called before LoadConfig.
+func Override(key, value string) error {
+	setDefaults()
+	if !slices.Contains(viper.AllKeys(), strings.ToLower(key)) {
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	viper.Set(key, value)
+	return nil
+}
+
+// Reload reads the config file of LoadConfig again. When it cannot be read
+// the error is returned and viper keeps the values read before.
+func Reload() (Config, error) {
+	if viper.ConfigFileUsed() != "" {
+		if err := viper.ReadInConfig(); err != nil {
+			return Config{}, fmt.Errorf("read config file: %w", err)
+		}
+	}
+	return current(), nil
+}
+
+// Changed returns the names of the fields whose values differ in other.
+func (c Config) Changed(other Config) []string {
+	var names []string
+	a, b := reflect.ValueOf(c), reflect.ValueOf(other)
+	for i := range a.NumField() {
+		if !a.Field(i).Equal(b.Field(i)) {
+			names = append(names, a.Type().Field(i).Name)
+		}
+	}
+	return names
+}
+
+func setDefaults() {
+	viper.SetDefault("CONFIG_FILE", "")
 	viper.SetDefault("PORT", "8080")
+	viper.SetDefault("GRPC_PORT", "9090")
 	viper.SetDefault("REDIS_ADDR", "127.0.0.1:6379")
+	viper.SetDefault("STORAGE", "memory")
+	viper.SetDefault("RATE_LIMITER", "redis")
 
 	viper.SetDefault("CAPACITY_LOGIN", 10)
 	viper.SetDefault("CAPACITY_PASS", 100)
@@ -29,11 +198,85 @@ func LoadConfig() Config {
 	viper.SetDefault("REFILL_LOGIN", 10)
 	viper.SetDefault("REFILL_PASS", 100)
 	viper.SetDefault("REFILL_IP", 1000)
-	viper.AutomaticEnv()
 
+	viper.SetDefault("ALGORITHM_LOGIN", "token_bucket")
+	viper.SetDefault("ALGORITHM_PASS", "token_bucket")
+	viper.SetDefault("ALGORITHM_IP", "token_bucket")
+
+	viper.SetDefault("WINDOW_LOGIN", time.Minute)
+	viper.SetDefault("WINDOW_PASS", time.Minute)
+	viper.SetDefault("WINDOW_IP", time.Minute)
+
+	viper.SetDefault("SUBNET_BUCKETS", "")
+	viper.SetDefault("CONSUME_MODE", "each")
+
+	viper.SetDefault("PASSWORD_HASH_KEY", "")
+	viper.SetDefault("PASSWORD_HASH_PREVIOUS_KEYS", "")
+	viper.SetDefault("PASSWORD_HASH_PREVIOUS_UNTIL", "")
+
+	viper.SetDefault("FAILURE_POLICY", "local")
+	viper.SetDefault("BREAKER_THRESHOLD", 5)
+	viper.SetDefault("BREAKER_COOLDOWN", 10*time.Second)
+
+	viper.SetDefault("REPORT_SUCCESS", "refund")
+	viper.SetDefault("REPORT_PENALTY", 0)
+
+	viper.SetDefault("TRACING_EXPORTER", "none")
+	viper.SetDefault("TRACING_ENDPOINT", "localhost:4317")
+	viper.SetDefault("TRACING_INSECURE", true)
+	viper.SetDefault("TRACING_SAMPLE_RATIO", 1.0)
+
+	viper.SetDefault("LOG_LEVEL", "info")
+	viper.SetDefault("LOG_FORMAT", "json")
+	viper.SetDefault("LOG_REDACT_LOGINS", false)
+
+	viper.SetDefault("AUTH_KEYS", "")
+	viper.SetDefault("AUTH_KEYS_FILE", "")
+	viper.SetDefault("AUTH_DISABLED", false)
+
+	viper.SetDefault("TLS_CERT_FILE", "")
+	viper.SetDefault("TLS_KEY_FILE", "")
+	viper.SetDefault("TLS_CLIENT_CA_FILE", "")
+	viper.SetDefault("TLS_CLIENT_AUTH", "none")
+
+	viper.SetDefault("AUDIT_SINK", "none")
+	viper.SetDefault("AUDIT_FILE", "audit.log")
+	viper.SetDefault("AUDIT_STREAM", "audit:admin")
+	viper.SetDefault("AUDIT_MAX_LEN", 0)
+
+	viper.SetDefault("AUTOBAN_THRESHOLD", 0)
+	viper.SetDefault("AUTOBAN_WINDOW", 10*time.Minute)
+	viper.SetDefault("AUTOBAN_TTL", time.Hour)
+	viper.SetDefault("AUTOBAN_PREFIX_V4", 32)
+	viper.SetDefault("AUTOBAN_PREFIX_V6", 128)
+	viper.SetDefault("OVERRIDES_FILE", "")
+	viper.SetDefault("OVERRIDES_REFRESH", 5*time.Second)
+
+	viper.SetDefault("GATEWAY_PREFER", "body")
+	viper.SetDefault("GATEWAY_LOGIN_HEADER", "X-Login")
+	viper.SetDefault("GATEWAY_PASSWORD_HEADER", "X-Password")
+	viper.SetDefault("GATEWAY_IP_HEADER", "X-Real-IP")
+	viper.SetDefault("GATEWAY_TRUSTED_HOPS", 1)
+	viper.SetDefault("GATEWAY_LOGIN_FIELD", "login")
+	viper.SetDefault("GATEWAY_PASSWORD_FIELD", "password")
+
+	viper.SetDefault("PROXY_UPSTREAM", "")
+	viper.SetDefault("PROXY_PORT", "8000")
+	viper.SetDefault("PROXY_LOGIN_PATH", "/login")
+	viper.SetDefault("PROXY_FAILURE_STATUSES", "401,403")
+	viper.SetDefault("PROXY_IP_HEADER", "")
+	viper.SetDefault("PROXY_TRUSTED_HOPS", 1)
+}
+
+func current() Config {
 	cfg := Config{
+		ConfigFile: viper.ConfigFileUsed(),
+
 		Port:      viper.GetString("PORT"),
+		GRPCPort:  viper.GetString("GRPC_PORT"),
 		RedisAddr: viper.GetString("REDIS_ADDR"),
+		Storage:   viper.GetString("STORAGE"),
+		Limiter:   viper.GetString("RATE_LIMITER"),
 
 		CLogin: viper.GetInt("CAPACITY_LOGIN"),
 		CPass:  viper.GetInt("CAPACITY_PASS"),
@@ -42,20 +285,396 @@ func LoadConfig() Config {
 		RLogin: viper.GetInt("REFILL_LOGIN"),
 		RPass:  viper.GetInt("REFILL_PASS"),
 		RIP:    viper.GetInt("REFILL_IP"),
+
+		ALogin: viper.GetString("ALGORITHM_LOGIN"),
+		APass:  viper.GetString("ALGORITHM_PASS"),
+		AIP:    viper.GetString("ALGORITHM_IP"),
+
+		WLogin: viper.GetDuration("WINDOW_LOGIN"),
+		WPass:  viper.GetDuration("WINDOW_PASS"),
+		WIP:    viper.GetDuration("WINDOW_IP"),
+
+		SubnetBuckets: viper.GetString("SUBNET_BUCKETS"),
+
+		ConsumeMode: viper.GetString("CONSUME_MODE"),
+
+		PasswordHashKey:           viper.GetString("PASSWORD_HASH_KEY"),
+		PasswordHashPreviousKeys:  viper.GetString("PASSWORD_HASH_PREVIOUS_KEYS"),
+		PasswordHashPreviousUntil: viper.GetString("PASSWORD_HASH_PREVIOUS_UNTIL"),
+
+		FailurePolicy:    viper.GetString("FAILURE_POLICY"),
+		BreakerThreshold: viper.GetInt("BREAKER_THRESHOLD"),
+		BreakerCooldown:  viper.GetDuration("BREAKER_COOLDOWN"),
+
+		ReportSuccess: viper.GetString("REPORT_SUCCESS"),
+		ReportPenalty: viper.GetInt("REPORT_PENALTY"),
+
+		TracingExporter:    viper.GetString("TRACING_EXPORTER"),
+		TracingEndpoint:    viper.GetString("TRACING_ENDPOINT"),
+		TracingInsecure:    viper.GetBool("TRACING_INSECURE"),
+		TracingSampleRatio: viper.GetFloat64("TRACING_SAMPLE_RATIO"),
+
+		LogLevel:        viper.GetString("LOG_LEVEL"),
+		LogFormat:       viper.GetString("LOG_FORMAT"),
+		LogRedactLogins: viper.GetBool("LOG_REDACT_LOGINS"),
+
+		AuthKeys:     viper.GetString("AUTH_KEYS"),
+		AuthKeysFile: viper.GetString("AUTH_KEYS_FILE"),
+		AuthDisabled: viper.GetBool("AUTH_DISABLED"),
+
+		TLSCertFile:     viper.GetString("TLS_CERT_FILE"),
+		TLSKeyFile:      viper.GetString("TLS_KEY_FILE"),
+		TLSClientCAFile: viper.GetString("TLS_CLIENT_CA_FILE"),
+		TLSClientAuth:   viper.GetString("TLS_CLIENT_AUTH"),
+
+		AuditSink:   viper.GetString("AUDIT_SINK"),
+		AuditFile:   viper.GetString("AUDIT_FILE"),
+		AuditStream: viper.GetString("AUDIT_STREAM"),
+		AuditMaxLen: viper.GetInt64("AUDIT_MAX_LEN"),
+
+		AutoBanThreshold: viper.GetInt("AUTOBAN_THRESHOLD"),
+		AutoBanWindow:    viper.GetDuration("AUTOBAN_WINDOW"),
+		AutoBanTTL:       viper.GetDuration("AUTOBAN_TTL"),
+		AutoBanPrefixV4:  viper.GetInt("AUTOBAN_PREFIX_V4"),
+		AutoBanPrefixV6:  viper.GetInt("AUTOBAN_PREFIX_V6"),
+
+		OverridesFile:    viper.GetString("OVERRIDES_FILE"),
+		OverridesRefresh: viper.GetDuration("OVERRIDES_REFRESH"),
+
+		GatewayPrefer:         viper.GetString("GATEWAY_PREFER"),
+		GatewayLoginHeader:    viper.GetString("GATEWAY_LOGIN_HEADER"),
+		GatewayPasswordHeader: viper.GetString("GATEWAY_PASSWORD_HEADER"),
+		GatewayIPHeader:       viper.GetString("GATEWAY_IP_HEADER"),
+		GatewayTrustedHops:    viper.GetInt("GATEWAY_TRUSTED_HOPS"),
+		GatewayLoginField:     viper.GetString("GATEWAY_LOGIN_FIELD"),
+		GatewayPasswordField:  viper.GetString("GATEWAY_PASSWORD_FIELD"),
+
+		ProxyUpstream:        viper.GetString("PROXY_UPSTREAM"),
+		ProxyPort:            viper.GetString("PROXY_PORT"),
+		ProxyLoginPath:       viper.GetString("PROXY_LOGIN_PATH"),
+		ProxyFailureStatuses: viper.GetString("PROXY_FAILURE_STATUSES"),
+		ProxyIPHeader:        viper.GetString("PROXY_IP_HEADER"),
+		ProxyTrustedHops:     viper.GetInt("PROXY_TRUSTED_HOPS"),
 	}
-	cfg.prettyPrint()
 	return cfg
 }
 
-func (c Config) prettyPrint() {
-	border := strings.Repeat("=", 40)
-	log.Println(border)
-	log.Println("Service configuration:")
-	log.Printf("  Port:            %s\n", c.Port)
-	log.Printf("  Redis Addr:      %s\n", c.RedisAddr)
-	log.Println("  --- Buckets ---")
-	log.Printf("  Login:           capacity=%d refill/min=%d\n", c.CLogin, c.RLogin)
-	log.Printf("  Password:        capacity=%d refill/min=%d\n", c.CPass, c.RPass)
-	log.Printf("  IP:              capacity=%d refill/min=%d\n", c.CIP, c.RIP)
-	log.Println(border)
+// LogValue lays the configuration out in groups for the startup log line.
+// Secrets are masked, only whether they are set shows.
+func (c Config) LogValue() slog.Value {
+	bucket := func(algorithm string, capacity, refill int, window time.Duration) slog.Value {
+		return slog.GroupValue(
+			slog.String("algorithm", algorithm),
+			slog.Int("capacity", capacity),
+			slog.Int("refill_per_minute", refill),
+			slog.String("window", window.String()),
+		)
+	}
+	attrs := []slog.Attr{
+		slog.String("config_file", c.ConfigFile),
+		slog.String("port", c.Port),
+		slog.String("grpc_port", c.GRPCPort),
+		slog.String("redis_addr", c.RedisAddr),
+		slog.String("storage", c.Storage),
+		slog.String("rate_limiter", c.Limiter),
+		slog.Group("buckets",
+			slog.Any("login", bucket(c.ALogin, c.CLogin, c.RLogin, c.WLogin)),
+			slog.Any("pass", bucket(c.APass, c.CPass, c.RPass, c.WPass)),
+			slog.Any("ip", bucket(c.AIP, c.CIP, c.RIP, c.WIP)),
+			slog.String("subnets", c.SubnetBuckets),
+			slog.String("consume_mode", c.ConsumeMode),
+		),
+		slog.Group("password_hash",
+			slog.String("key", mask(c.PasswordHashKey)),
+			slog.String("previous_keys", mask(c.PasswordHashPreviousKeys)),
+			slog.String("previous_until", c.PasswordHashPreviousUntil),
+		),
+		slog.Group("redis_outages",
+			slog.String("failure_policy", c.FailurePolicy),
+			slog.Int("breaker_threshold", c.BreakerThreshold),
+			slog.String("breaker_cooldown", c.BreakerCooldown.String()),
+		),
+		slog.Group("report",
+			slog.String("on_success", c.ReportSuccess),
+			slog.Int("penalty", c.ReportPenalty),
+		),
+		slog.Group("tracing",
+			slog.String("exporter", c.TracingExporter),
+			slog.String("endpoint", c.TracingEndpoint),
+			slog.Bool("insecure", c.TracingInsecure),
+			slog.Float64("sample_ratio", c.TracingSampleRatio),
+		),
+		slog.Group("logging",
+			slog.String("level", c.LogLevel),
+			slog.String("format", c.LogFormat),
+			slog.Bool("redact_logins", c.LogRedactLogins),
+		),
+		slog.Group("auth",
+			slog.String("inline_keys", mask(c.AuthKeys)),
+			slog.String("keys_file", c.AuthKeysFile),
+			slog.Bool("disabled", c.AuthDisabled),
+		),
+		slog.Group("tls",
+			slog.String("cert_file", c.TLSCertFile),
+			slog.String("key_file", c.TLSKeyFile),
+			slog.String("client_ca_file", c.TLSClientCAFile),
+			slog.String("client_auth", c.TLSClientAuth),
+		),
+		slog.Group("audit",
+			slog.String("sink", c.AuditSink),
+			slog.String("file", c.AuditFile),
+			slog.String("stream", c.AuditStream),
+			slog.Int64("max_len", c.AuditMaxLen),
+		),
+		slog.Group("autoban",
+			slog.Int("threshold", c.AutoBanThreshold),
+			slog.String("window", c.AutoBanWindow.String()),
+			slog.String("ttl", c.AutoBanTTL.String()),
+			slog.Int("prefix_v4", c.AutoBanPrefixV4),
+			slog.Int("prefix_v6", c.AutoBanPrefixV6),
+		),
+		slog.Group("overrides",
+			slog.String("file", c.OverridesFile),
+			slog.String("refresh", c.OverridesRefresh.String()),
+		),
+		slog.Group("gateway",
+			slog.String("prefer", c.GatewayPrefer),
+			slog.String("login_header", c.GatewayLoginHeader),
+			slog.String("password_header", c.GatewayPasswordHeader),
+			slog.String("ip_header", c.GatewayIPHeader),
+			slog.Int("trusted_hops", c.GatewayTrustedHops),
+			slog.String("login_field", c.GatewayLoginField),
+			slog.String("password_field", c.GatewayPasswordField),
+		),
+	}
+	if c.ProxyUpstream != "" {
+		attrs = append(attrs, slog.Group("proxy",
+			slog.String("upstream", redactURL(c.ProxyUpstream)),
+			slog.String("port", c.ProxyPort),
+			slog.String("login_path", c.ProxyLoginPath),
+			slog.String("failure_statuses", c.ProxyFailureStatuses),
+			slog.String("ip_header", valueOr(c.ProxyIPHeader, "peer address")),
+			slog.Int("trusted_hops", c.ProxyTrustedHops),
+		))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// Effective returns the configuration as LogValue lays it out, as nested
+// maps ready to be encoded.
+func (c Config) Effective() map[string]any {
+	m, _ := valueMap(c.LogValue()).(map[string]any)
+	return m
+}
+
+func valueMap(v slog.Value) any {
+	v = v.Resolve()
+	if v.Kind() != slog.KindGroup {
+		return v.Any()
+	}
+	m := make(map[string]any)
+	for _, a := range v.Group() {
+		m[a.Key] = valueMap(a.Value)
+	}
+	return m
+}
+
+// mask hides a secret, leaving empty values empty.
+func mask(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return logging.Redacted
+}
+
+// redactURL hides the password of a URL with user info.
+func redactURL(raw string) string {
+	if u, err := url.Parse(raw); err == nil {
+		return u.Redacted()
+	}
+	return raw
+}
+
+func valueOr(s, fallback string) string {
+	if s == "" {
+		return fallback
+	}
+	return s
+}
+
+// Buckets returns the login, password and IP bucket configs.
+func (c Config) Buckets() (loginCfg, passCfg, ipCfg bucket.Config) {
+	loginCfg = bucket.Config{
+		Capacity: c.CLogin, RefillPerMinute: c.RLogin,
+		Algorithm: bucket.Algorithm(c.ALogin), Window: c.WLogin,
+	}
+	passCfg = bucket.Config{
+		Capacity: c.CPass, RefillPerMinute: c.RPass,
+		Algorithm: bucket.Algorithm(c.APass), Window: c.WPass,
+	}
+	ipCfg = bucket.Config{
+		Capacity: c.CIP, RefillPerMinute: c.RIP,
+		Algorithm: bucket.Algorithm(c.AIP), Window: c.WIP,
+	}
+	return loginCfg, passCfg, ipCfg
+}
+
+// Subnets returns the subnet buckets.
+func (c Config) Subnets() ([]bucket.Subnet, error) {
+	subnets, err := bucket.ParseSubnets(c.SubnetBuckets)
+	if err != nil {
+		return nil, fmt.Errorf("SUBNET_BUCKETS: %w", err)
+	}
+	return subnets, nil
+}
+
+// PasswordHasher builds the password bucket hasher. Without a key it hashes
+// with a random one that lasts as long as the process, see
+// EphemeralPasswordKey.
+func (c Config) PasswordHasher() (*bucket.PasswordHasher, error) {
+	if c.PasswordHashKey == "" {
+		if c.PasswordHashPreviousKeys != "" {
+			return nil, errors.New("PASSWORD_HASH_PREVIOUS_KEYS needs PASSWORD_HASH_KEY")
+		}
+		return bucket.NewRandomPasswordHasher(), nil
+	}
+	var previous []string
+	for _, key := range strings.Split(c.PasswordHashPreviousKeys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			previous = append(previous, key)
+		}
+	}
+	var until time.Time
+	if c.PasswordHashPreviousUntil != "" {
+		var err error
+		if until, err = time.Parse(time.RFC3339, c.PasswordHashPreviousUntil); err != nil {
+			return nil, fmt.Errorf("PASSWORD_HASH_PREVIOUS_UNTIL must be an RFC 3339 time: %w", err)
+		}
+	}
+	h, err := bucket.NewPasswordHasher(c.PasswordHashKey, previous, until)
+	if err != nil {
+		return nil, fmt.Errorf("PASSWORD_HASH_KEY: %w", err)
+	}
+	return h, nil
+}
+
+// EphemeralPasswordKey reports whether password buckets are named with a
+// random key. With the Redis limiter that key differs between replicas and
+// restarts, so each of them counts password attempts on its own.
+func (c Config) EphemeralPasswordKey() bool {
+	return c.PasswordHashKey == ""
+}
+
+// Validate reports every setting that is out of range or that the server
+// would refuse, each error naming its environment variable. It reads no
+// files, the keys file and the certificates are only checked when the
+// server loads them.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	add := func(prefix string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", prefix, err))
+		}
+	}
+
+	check(validPort(c.Port), "PORT must be a port number, got %q", c.Port)
+	check(c.GRPCPort == "" || validPort(c.GRPCPort), "GRPC_PORT must be a port number or empty, got %q", c.GRPCPort)
+	check(c.Storage == "memory" || c.Storage == "redis", "STORAGE must be memory or redis, got %q", c.Storage)
+	check(c.Limiter == "memory" || c.Limiter == "redis", "RATE_LIMITER must be memory or redis, got %q", c.Limiter)
+	check(c.RedisAddr != "" || (c.Storage != "redis" && c.Limiter != "redis" && c.AuditSink != "redis"),
+		"REDIS_ADDR must be set when Redis is used")
+
+	loginCfg, passCfg, ipCfg := c.Buckets()
+	add("login bucket (ALGORITHM_LOGIN, CAPACITY_LOGIN, REFILL_LOGIN, WINDOW_LOGIN)", loginCfg.Validate())
+	add("password bucket (ALGORITHM_PASS, CAPACITY_PASS, REFILL_PASS, WINDOW_PASS)", passCfg.Validate())
+	add("IP bucket (ALGORITHM_IP, CAPACITY_IP, REFILL_IP, WINDOW_IP)", ipCfg.Validate())
+	if _, err := c.Subnets(); err != nil {
+		errs = append(errs, err)
+	}
+	_, err := bucket.ParseConsumeMode(c.ConsumeMode)
+	add("CONSUME_MODE", err)
+	_, err = c.PasswordHasher()
+	add("password hash", err)
+
+	_, err = service.ParseFailurePolicy(c.FailurePolicy)
+	add("FAILURE_POLICY", err)
+	check(c.BreakerThreshold >= 0, "BREAKER_THRESHOLD must not be negative, got %d", c.BreakerThreshold)
+	check(c.BreakerThreshold == 0 || c.BreakerCooldown > 0,
+		"BREAKER_COOLDOWN must be positive when the breaker is on, got %s", c.BreakerCooldown)
+	_, err = service.ParseSuccessPolicy(c.ReportSuccess)
+	add("REPORT_SUCCESS", err)
+	check(c.ReportPenalty >= 0, "REPORT_PENALTY must not be negative, got %d", c.ReportPenalty)
+
+	switch c.TracingExporter {
+	case tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP:
+	default:
+		check(false, "TRACING_EXPORTER must be none, stdout or otlp, got %q", c.TracingExporter)
+	}
+	check(c.TracingSampleRatio >= 0 && c.TracingSampleRatio <= 1,
+		"TRACING_SAMPLE_RATIO must be between 0 and 1, got %v", c.TracingSampleRatio)
+
+	_, err = logging.ParseLevel(c.LogLevel)
+	add("LOG_LEVEL", err)
+	check(c.LogFormat == "json" || c.LogFormat == "text", "LOG_FORMAT must be json or text, got %q", c.LogFormat)
+
+	check(!c.AuthDisabled || (c.AuthKeys == "" && c.AuthKeysFile == ""),
+		"AUTH_DISABLED cannot be combined with AUTH_KEYS or AUTH_KEYS_FILE")
+	if keys, err := auth.ParseKeys(c.AuthKeys); err != nil {
+		add("AUTH_KEYS", err)
+	} else if _, err := auth.New(keys); err != nil {
+		add("AUTH_KEYS", err)
+	}
+
+	clientAuth, err := app.ParseClientAuth(c.TLSClientAuth)
+	add("TLS_CLIENT_AUTH", err)
+	check((c.TLSCertFile == "") == (c.TLSKeyFile == ""), "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	if err == nil && clientAuth != app.ClientAuthNone {
+		check(c.TLSCertFile != "", "TLS_CLIENT_AUTH=%s needs TLS_CERT_FILE and TLS_KEY_FILE", clientAuth)
+		check(c.TLSClientCAFile != "", "TLS_CLIENT_AUTH=%s needs TLS_CLIENT_CA_FILE", clientAuth)
+	}
+
+	switch c.AuditSink {
+	case "none", "", "redis":
+	case "file":
+		check(c.AuditFile != "", "AUDIT_FILE must be set for the file sink")
+	default:
+		check(false, "AUDIT_SINK must be none, file or redis, got %q", c.AuditSink)
+	}
+	check(c.AuditMaxLen >= 0, "AUDIT_MAX_LEN must not be negative, got %d", c.AuditMaxLen)
+
+	check(c.AutoBanThreshold >= 0, "AUTOBAN_THRESHOLD must not be negative, got %d", c.AutoBanThreshold)
+	if c.AutoBanThreshold > 0 {
+		check(c.AutoBanWindow > 0, "AUTOBAN_WINDOW must be positive, got %s", c.AutoBanWindow)
+		check(c.AutoBanTTL >= 0, "AUTOBAN_TTL must not be negative, got %s", c.AutoBanTTL)
+		check(c.AutoBanPrefixV4 >= 1 && c.AutoBanPrefixV4 <= 32,
+			"AUTOBAN_PREFIX_V4 must be between 1 and 32, got %d", c.AutoBanPrefixV4)
+		check(c.AutoBanPrefixV6 >= 1 && c.AutoBanPrefixV6 <= 128,
+			"AUTOBAN_PREFIX_V6 must be between 1 and 128, got %d", c.AutoBanPrefixV6)
+	}
+
+	check(c.OverridesRefresh > 0, "OVERRIDES_REFRESH must be positive, got %s", c.OverridesRefresh)
+
+	_, err = gateway.ParseSource(c.GatewayPrefer)
+	add("GATEWAY_PREFER", err)
+	check(c.GatewayTrustedHops >= 1, "GATEWAY_TRUSTED_HOPS must be at least 1, got %d", c.GatewayTrustedHops)
+
+	if c.ProxyUpstream != "" {
+		u, err := url.Parse(c.ProxyUpstream)
+		check(err == nil && u.Host != "", "PROXY_UPSTREAM must be an absolute URL, got %q", c.ProxyUpstream)
+		check(validPort(c.ProxyPort), "PROXY_PORT must be a port number, got %q", c.ProxyPort)
+		_, err = proxy.ParseStatuses(c.ProxyFailureStatuses)
+		add("PROXY_FAILURE_STATUSES", err)
+		check(c.ProxyTrustedHops >= 1, "PROXY_TRUSTED_HOPS must be at least 1, got %d", c.ProxyTrustedHops)
+	}
+	return errors.Join(errs...)
+}
+
+func validPort(s string) bool {
+	n, err := strconv.Atoi(s)
+	return err == nil && n > 0 && n <= 65535
 }
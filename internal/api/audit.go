@@ -0,0 +1,202 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/meladark/special-train/internal/audit"
+	"github.com/meladark/special-train/internal/auth"
+	"github.com/meladark/special-train/internal/logging"
+	pb "github.com/meladark/special-train/pkg/antibruteforcepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxAuditBody bounds the request and response bytes kept in an entry.
+const maxAuditBody = 16 << 10
+
+// auditMiddleware records every call to next as action, with the request
+// body as payload and the response as result. It runs behind the auth check
+// so the principal is known.
+func auditMiddleware(log *audit.Log, action string, next http.Handler) http.Handler {
+	if log == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(io.LimitReader(r.Body, maxAuditBody))
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+		rec := &bodyRecorder{StatusRecorder: logging.NewStatusRecorder(w)}
+		next.ServeHTTP(rec, r)
+		p, _ := auth.FromContext(r.Context())
+		result := bytes.TrimSpace(rec.body.Bytes())
+		log.Record(r.Context(), audit.Entry{
+			Actor:     p.Name,
+			Action:    action,
+			SourceIP:  hostOf(r.RemoteAddr),
+			RequestID: logging.RequestID(r.Context()),
+			Payload:   audit.RawJSON(body),
+			Status:    strconv.Itoa(rec.Status),
+			OK:        rec.Status < http.StatusMultipleChoices && resultOK(result),
+			Result:    audit.RawJSON(result),
+		})
+	})
+}
+
+// resultOK reads the ok field list responses use to report a refusal with
+// a 200. Bodies without it are taken as a success.
+func resultOK(body []byte) bool {
+	var r struct {
+		Ok *bool `json:"ok"`
+	}
+	if json.Unmarshal(body, &r) != nil || r.Ok == nil {
+		return true
+	}
+	return *r.Ok
+}
+
+func hostOf(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// bodyRecorder keeps the status and the start of the body written.
+type bodyRecorder struct {
+	*logging.StatusRecorder
+	body bytes.Buffer
+}
+
+func (r *bodyRecorder) Write(b []byte) (int, error) {
+	if room := maxAuditBody - r.body.Len(); room > 0 {
+		r.body.Write(b[:min(len(b), room)])
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// auditHandler serves the entries matching the actor, action, since and
+// limit query parameters, since being a time in RFC 3339 or a duration back
+// from now such as 24h.
+func auditHandler(log *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		f := audit.Filter{Actor: q.Get("actor"), Action: q.Get("action")}
+		if s := q.Get("since"); s != "" {
+			since, err := parseSince(s, time.Now())
+			if err != nil {
+				http.Error(w, "invalid since: expected an RFC 3339 time or a duration", http.StatusBadRequest)
+				return
+			}
+			f.Since = since
+		}
+		if s := q.Get("limit"); s != "" {
+			limit, err := strconv.Atoi(s)
+			if err != nil || limit <= 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			f.Limit = limit
+		}
+		entries, err := log.Query(r.Context(), f)
+		if err != nil {
+			http.Error(w, "service error: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Ok      bool          `json:"ok"`
+			Entries []audit.Entry `json:"entries"`
+		}{Ok: true, Entries: entries})
+	}
+}
+
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// auditMethods maps the mutating RPCs to their action. ResetBucket is
+// refined by grpcAction from the fields it resets.
+var auditMethods = map[string]string{
+	pb.AntiBruteforce_ResetBucket_FullMethodName:         audit.ActionBucketReset,
+	pb.AntiBruteforce_AddToWhitelist_FullMethodName:      audit.ActionWhitelistAdd,
+	pb.AntiBruteforce_AddToBlacklist_FullMethodName:      audit.ActionBlacklistAdd,
+	pb.AntiBruteforce_RemoveFromWhitelist_FullMethodName: audit.ActionWhitelistDel,
+	pb.AntiBruteforce_RemoveFromBlacklist_FullMethodName: audit.ActionBlacklistDel,
+}
+
+func grpcAction(method string, req any) (string, bool) {
+	action, ok := auditMethods[method]
+	if r, isReset := req.(*pb.ResetBucketRequest); isReset {
+		switch {
+		case r.GetIp() != "" && r.GetLogin() == "":
+			action = audit.ActionBucketResetIP
+		case r.GetIp() == "" && r.GetLogin() != "":
+			action = audit.ActionBucketResetLogin
+		}
+	}
+	return action, ok
+}
+
+// auditInterceptor is the gRPC counterpart of auditMiddleware, chained after
+// authInterceptor.
+func auditInterceptor(log *audit.Log) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		action, ok := grpcAction(info.FullMethod, req)
+		if log == nil || !ok {
+			return handler(ctx, req)
+		}
+		resp, err := handler(ctx, req)
+		p, _ := auth.FromContext(ctx)
+		e := audit.Entry{
+			Actor:     p.Name,
+			Action:    action,
+			SourceIP:  peerIP(ctx),
+			RequestID: logging.RequestID(ctx),
+			Payload:   protoJSON(req),
+			Status:    status.Code(err).String(),
+		}
+		if err != nil {
+			e.Result = audit.RawJSON([]byte(status.Convert(err).Message()))
+		} else {
+			e.Result = protoJSON(resp)
+			e.OK = resultOK(e.Result)
+		}
+		log.Record(ctx, e)
+		return resp, err
+	}
+}
+
+func protoJSON(v any) json.RawMessage {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil
+	}
+	data, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(m)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func peerIP(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return hostOf(p.Addr.String())
+	}
+	return ""
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/meladark/special-train/internal/gateway"
+	"github.com/meladark/special-train/internal/service"
+)
+
+// maxAuthBody bounds how much of a forwarded login body is read.
+const maxAuthBody = 64 << 10
+
+// authRequestHandler answers nginx auth_request subrequests with the status
+// the original request should get. The reason of a denial is set in the
+// X-Auth-Reason header, which nginx can pick up with auth_request_set.
+func authRequestHandler(svc *service.Service, gw gateway.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxAuthBody))
+		if err != nil {
+			slog.WarnContext(r.Context(), "failed to read auth request body", "err", err)
+		}
+		req := gw.Extract(r.Header.Get, r.Header.Get("Content-Type"), string(body), r.RemoteAddr)
+		resp, err := svc.Authorize(r.Context(), req)
+		switch {
+		case errors.Is(err, service.ErrInvalidIP):
+			w.Header().Set("X-Auth-Reason", err.Error())
+			w.WriteHeader(http.StatusForbidden)
+		case err != nil:
+			http.Error(w, "service error: "+err.Error(), http.StatusServiceUnavailable)
+		default:
+			if resp.Reason != "" {
+				w.Header().Set("X-Auth-Reason", resp.Reason)
+			}
+			w.WriteHeader(gateway.Status(resp))
+		}
+	}
+}
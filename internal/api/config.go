@@ -0,0 +1,22 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// configHandler serves the effective configuration config returns, with
+// its secrets already masked.
+func configHandler(config func() map[string]any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Ok     bool           `json:"ok"`
+			Config map[string]any `json:"config"`
+		}{Ok: true, Config: config()})
+	}
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/meladark/special-train/internal/gateway"
+	"github.com/meladark/special-train/internal/service"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+)
+
+// extAuthzServer implements the Envoy external authorization service, so
+// that Envoy can check login requests before they reach the application.
+type extAuthzServer struct {
+	authv3.UnimplementedAuthorizationServer
+	svc *service.Service
+	gw  gateway.Config
+}
+
+func (e *extAuthzServer) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	httpReq := req.GetAttributes().GetRequest().GetHttp()
+	headers := httpReq.GetHeaders()
+	// Envoy passes header names lowercased.
+	get := func(name string) string { return headers[strings.ToLower(name)] }
+	body := httpReq.GetBody()
+	if body == "" {
+		body = string(httpReq.GetRawBody())
+	}
+	peer := req.GetAttributes().GetSource().GetAddress().GetSocketAddress().GetAddress()
+	resp, err := e.svc.Authorize(ctx, e.gw.Extract(get, get("Content-Type"), body, peer))
+	switch {
+	case errors.Is(err, service.ErrInvalidIP):
+		return denied(http.StatusForbidden, err.Error()), nil
+	case err != nil:
+		// Envoy applies its failure_mode_allow setting to errors.
+		return nil, grpcError(err)
+	}
+	if code := gateway.Status(resp); code != http.StatusOK {
+		return denied(code, resp.Reason), nil
+	}
+	return &authv3.CheckResponse{
+		Status:       &rpcstatus.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: &authv3.OkHttpResponse{}},
+	}, nil
+}
+
+func denied(code int, reason string) *authv3.CheckResponse {
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(codes.PermissionDenied), Message: reason},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: &authv3.DeniedHttpResponse{
+			Status: &typev3.HttpStatus{Code: typev3.StatusCode(code)},
+			Body:   reason,
+		}},
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/meladark/special-train/internal/gateway"
+	"google.golang.org/grpc/codes"
+)
+
+var testGateway = gateway.Config{
+	LoginHeader:    "X-Login",
+	PasswordHeader: "X-Password",
+	IPHeader:       "X-Real-IP",
+	LoginField:     "login",
+	PasswordField:  "password",
+}
+
+func TestAuthRequest(t *testing.T) {
+	svc := newTestService(t)
+	router := NewRouter(svc, testGateway, nil, nil, nil)
+	if _, err := svc.AddToBlacklist(t.Context(), "203.0.113.0/24", false, 0); err != nil {
+		t.Fatalf("AddToBlacklist: %v", err)
+	}
+	subrequest := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth_request", strings.NewReader("login=alice&password=pw"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Real-IP", ip)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	for i, expect := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if rec := subrequest("192.0.2.1"); rec.Code != expect {
+			t.Fatalf("attempt %d: expected %d, got %d", i+1, expect, rec.Code)
+		}
+	}
+	rec := subrequest("203.0.113.5")
+	if rec.Code != http.StatusForbidden || rec.Header().Get("X-Auth-Reason") == "" {
+		t.Fatalf("expected 403 with a reason for a blacklisted IP, got %d %q", rec.Code, rec.Header().Get("X-Auth-Reason"))
+	}
+}
+
+func TestExtAuthzCheck(t *testing.T) {
+	srv := &extAuthzServer{svc: newTestService(t), gw: testGateway}
+	check := func() *authv3.CheckResponse {
+		resp, err := srv.Check(context.Background(), &authv3.CheckRequest{
+			Attributes: &authv3.AttributeContext{
+				Source: &authv3.AttributeContext_Peer{Address: &corev3.Address{
+					Address: &corev3.Address_SocketAddress{SocketAddress: &corev3.SocketAddress{Address: "192.0.2.9"}},
+				}},
+				Request: &authv3.AttributeContext_Request{Http: &authv3.AttributeContext_HttpRequest{
+					Headers: map[string]string{"x-login": "bob", "x-password": "pw"},
+				}},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Check: %v", err)
+		}
+		return resp
+	}
+	for i := 0; i < 2; i++ {
+		if resp := check(); codes.Code(resp.GetStatus().GetCode()) != codes.OK || resp.GetOkResponse() == nil {
+			t.Fatalf("attempt %d: expected OK, got %v", i+1, resp)
+		}
+	}
+	resp := check()
+	if codes.Code(resp.GetStatus().GetCode()) != codes.PermissionDenied ||
+		resp.GetDeniedResponse().GetStatus().GetCode() != http.StatusTooManyRequests {
+		t.Fatalf("expected a 429 denial, got %v", resp)
+	}
+}
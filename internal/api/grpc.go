@@ -0,0 +1,240 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/meladark/special-train/internal/audit"
+	"github.com/meladark/special-train/internal/auth"
+	"github.com/meladark/special-train/internal/gateway"
+	"github.com/meladark/special-train/internal/logging"
+	"github.com/meladark/special-train/internal/service"
+	pb "github.com/meladark/special-train/pkg/antibruteforcepb"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcServer serves the AntiBruteforce gRPC service on top of the same
+// service.Service methods the HTTP handlers use.
+type grpcServer struct {
+	pb.UnimplementedAntiBruteforceServer
+	svc *service.Service
+}
+
+// adminMethods maps the admin RPCs, and Report, to the role they need, like
+// the guarded routes of NewRouter. Methods missing here are decisions and
+// stay open.
+var adminMethods = map[string]auth.Role{
+	pb.AntiBruteforce_Report_FullMethodName:              auth.RoleReporter,
+	pb.AntiBruteforce_ResetBucket_FullMethodName:         auth.RoleResetter,
+	pb.AntiBruteforce_AddToWhitelist_FullMethodName:      auth.RoleListEditor,
+	pb.AntiBruteforce_AddToBlacklist_FullMethodName:      auth.RoleListEditor,
+	pb.AntiBruteforce_RemoveFromWhitelist_FullMethodName: auth.RoleListEditor,
+	pb.AntiBruteforce_RemoveFromBlacklist_FullMethodName: auth.RoleListEditor,
+	pb.AntiBruteforce_ListLists_FullMethodName:           auth.RoleViewer,
+}
+
+// NewGRPCServer serves the AntiBruteforce service and the Envoy external
+// authorization service, which reads credentials as gw describes. Admin
+// RPCs are checked against authn and the mutating ones recorded in auditLog.
+// opts, such as grpc.Creds, are added to the server options.
+func NewGRPCServer(
+	svc *service.Service,
+	gw gateway.Config,
+	authn *auth.Authenticator,
+	auditLog *audit.Log,
+	opts ...grpc.ServerOption,
+) *grpc.Server {
+	srv := grpc.NewServer(append([]grpc.ServerOption{
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(loggingInterceptor, authInterceptor(authn), auditInterceptor(auditLog)),
+	}, opts...)...)
+	pb.RegisterAntiBruteforceServer(srv, &grpcServer{svc: svc})
+	authv3.RegisterAuthorizationServer(srv, &extAuthzServer{svc: svc, gw: gw})
+	return srv
+}
+
+func loggingInterceptor(
+	ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (any, error) {
+	start := time.Now()
+	id := logging.NewRequestID()
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(logging.RequestIDHeader); len(ids) > 0 && logging.ValidRequestID(ids[0]) {
+			id = ids[0]
+		}
+	}
+	ctx = logging.WithRequestID(ctx, id)
+	_ = grpc.SetHeader(ctx, metadata.Pairs(logging.RequestIDHeader, id))
+	resp, err := handler(ctx, req)
+	slog.InfoContext(ctx, "grpc request",
+		"method", info.FullMethod,
+		"code", status.Code(err).String(),
+		"duration", time.Since(start),
+	)
+	return resp, err
+}
+
+func authInterceptor(authn *auth.Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		role, ok := adminMethods[info.FullMethod]
+		if !ok {
+			return handler(ctx, req)
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		token := auth.Token(func(key string) string {
+			if v := md.Get(key); len(v) > 0 {
+				return v[0]
+			}
+			return ""
+		})
+		p, err := authn.AuthorizePeer(token, peerTLS(ctx), role)
+		switch {
+		case errors.Is(err, auth.ErrUnauthenticated):
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		case err != nil:
+			slog.WarnContext(ctx, "access denied", "principal", p.Name, "role", role, "method", info.FullMethod)
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		return handler(auth.WithPrincipal(ctx, p), req)
+	}
+}
+
+// peerTLS returns the TLS state of the caller, nil over plain text.
+func peerTLS(ctx context.Context) *tls.ConnectionState {
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			return &info.State
+		}
+	}
+	return nil
+}
+
+// grpcError maps service errors to gRPC status codes.
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidIP), errors.Is(err, service.ErrInvalidTTL):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, service.ErrUnavailable), errors.Is(err, service.ErrListsUnavailable):
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return status.Error(codes.Internal, "service error: "+err.Error())
+	}
+}
+
+func (g *grpcServer) Authorize(ctx context.Context, req *pb.AuthorizeRequest) (*pb.AuthorizeResponse, error) {
+	resp, err := g.svc.Authorize(ctx, service.AuthorizeRequest{
+		Login:    req.GetLogin(),
+		Password: req.GetPassword(),
+		IP:       req.GetIp(),
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.AuthorizeResponse{Ok: resp.Ok, Reason: resp.Reason, Mode: resp.Mode, Subnet: resp.Subnet}, nil
+}
+
+func (g *grpcServer) Report(ctx context.Context, req *pb.ReportRequest) (*pb.ReportResponse, error) {
+	err := g.svc.Report(ctx, service.ReportRequest{
+		Login:    req.GetLogin(),
+		Password: req.GetPassword(),
+		IP:       req.GetIp(),
+		Success:  req.GetSuccess(),
+	})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.ReportResponse{Ok: true}, nil
+}
+
+func (g *grpcServer) ResetBucket(ctx context.Context, req *pb.ResetBucketRequest) (*pb.ResetBucketResponse, error) {
+	var err error
+	switch {
+	case req.GetLogin() == "" && req.GetIp() == "":
+		err = g.svc.ResetAll(ctx)
+	case req.GetIp() == "":
+		err = g.svc.ResetLogin(ctx, req.GetLogin())
+	default:
+		if err = g.svc.ResetIP(ctx, req.GetIp()); err == nil && req.GetLogin() != "" {
+			err = g.svc.ResetLogin(ctx, req.GetLogin())
+		}
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.ResetBucketResponse{Ok: true}, nil
+}
+
+func (g *grpcServer) addToList(
+	ctx context.Context,
+	add func(context.Context, string, bool, time.Duration) (service.ListReponse, error),
+	req *pb.ListRequest,
+) (*pb.ListResponse, error) {
+	// As over HTTP, an unset TTL keeps the entry, a set one must be positive.
+	var ttl time.Duration
+	if req.GetTtl() != nil {
+		if err := req.GetTtl().CheckValid(); err != nil || req.GetTtl().AsDuration() <= 0 {
+			return nil, grpcError(service.ErrInvalidTTL)
+		}
+		ttl = req.GetTtl().AsDuration()
+	}
+	resp, err := add(ctx, req.GetIp(), req.GetForce(), ttl)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.ListResponse{Ok: resp.Ok, Reason: resp.Reason}, nil
+}
+
+func (g *grpcServer) AddToWhitelist(ctx context.Context, req *pb.ListRequest) (*pb.ListResponse, error) {
+	return g.addToList(ctx, g.svc.AddToWhitelist, req)
+}
+
+func (g *grpcServer) AddToBlacklist(ctx context.Context, req *pb.ListRequest) (*pb.ListResponse, error) {
+	return g.addToList(ctx, g.svc.AddToBlacklist, req)
+}
+
+func (g *grpcServer) removeFromList(
+	ctx context.Context,
+	remove func(context.Context, string) (service.ListReponse, error),
+	req *pb.ListRequest,
+) (*pb.ListResponse, error) {
+	resp, err := remove(ctx, req.GetIp())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.ListResponse{Ok: resp.Ok, Reason: resp.Reason}, nil
+}
+
+func (g *grpcServer) RemoveFromWhitelist(ctx context.Context, req *pb.ListRequest) (*pb.ListResponse, error) {
+	return g.removeFromList(ctx, g.svc.RemoveFromWhitelist, req)
+}
+
+func (g *grpcServer) RemoveFromBlacklist(ctx context.Context, req *pb.ListRequest) (*pb.ListResponse, error) {
+	return g.removeFromList(ctx, g.svc.RemoveFromBlacklist, req)
+}
+
+func (g *grpcServer) ListLists(ctx context.Context, _ *pb.ListListsRequest) (*pb.ListListsResponse, error) {
+	lists, err := g.svc.Lists(ctx)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.ListListsResponse{
+		Whitelist:          lists.Whitelist,
+		Blacklist:          lists.Blacklist,
+		AutoBlacklist:      lists.AutoBlacklist,
+		WhitelistExpiresIn: lists.WhitelistExpiresIn,
+		BlacklistExpiresIn: lists.BlacklistExpiresIn,
+	}, nil
+}
@@ -0,0 +1,258 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/meladark/special-train/internal/audit"
+	"github.com/meladark/special-train/internal/auth"
+	"github.com/meladark/special-train/internal/bucket"
+	"github.com/meladark/special-train/internal/service"
+	"github.com/meladark/special-train/internal/storage"
+	pb "github.com/meladark/special-train/pkg/antibruteforcepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func newTestService(t *testing.T) *service.Service {
+	t.Helper()
+	rl := bucket.NewMemoryRateLimiter(5*time.Minute,
+		bucket.Config{Capacity: 2, RefillPerMinute: 0},
+		bucket.Config{Capacity: 100, RefillPerMinute: 0},
+		bucket.Config{Capacity: 100, RefillPerMinute: 0})
+	t.Cleanup(rl.Close)
+	store := storage.NewInMemoryStorage()
+	t.Cleanup(store.Close)
+	return service.New(store, rl)
+}
+
+func newTestClient(
+	t *testing.T,
+	svc *service.Service,
+	authn *auth.Authenticator,
+	auditLog *audit.Log,
+) pb.AntiBruteforceClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := NewGRPCServer(svc, testGateway, authn, auditLog)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("grpc.NewClient: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return pb.NewAntiBruteforceClient(conn)
+}
+
+func TestGRPCMatchesHTTP(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(t)
+	client := newTestClient(t, svc, nil, nil)
+	router := NewRouter(svc, testGateway, nil, nil, nil)
+	httpAuthorize := func() service.AuthorizeResponse {
+		b, _ := json.Marshal(service.AuthorizeRequest{Login: "alice", Password: "pw", IP: "192.0.2.1"})
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/authorize", bytes.NewReader(b)))
+		var resp service.AuthorizeResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid JSON response %q: %v", rec.Body.String(), err)
+		}
+		return resp
+	}
+	grpcAuthorize := func() service.AuthorizeResponse {
+		resp, err := client.Authorize(ctx, &pb.AuthorizeRequest{Login: "alice", Password: "pw", Ip: "192.0.2.1"})
+		if err != nil {
+			t.Fatalf("Authorize: %v", err)
+		}
+		return service.AuthorizeResponse{
+			Ok: resp.GetOk(), Reason: resp.GetReason(), Mode: resp.GetMode(), Subnet: resp.GetSubnet(),
+		}
+	}
+	// Both transports drain the same login bucket of two tokens.
+	expect := []service.AuthorizeResponse{
+		{Ok: true, Mode: service.ModeNormal},
+		{Ok: true, Mode: service.ModeNormal},
+		{Ok: false, Reason: "rate limit exceeded", Mode: service.ModeNormal},
+		{Ok: false, Reason: "rate limit exceeded", Mode: service.ModeNormal},
+	}
+	got := []service.AuthorizeResponse{httpAuthorize(), grpcAuthorize(), httpAuthorize(), grpcAuthorize()}
+	for i := range expect {
+		if got[i] != expect[i] {
+			t.Fatalf("call %d: expected %+v, got %+v", i+1, expect[i], got[i])
+		}
+	}
+	if _, err := client.ResetBucket(ctx, &pb.ResetBucketRequest{Login: "alice"}); err != nil {
+		t.Fatalf("ResetBucket: %v", err)
+	}
+	if resp := grpcAuthorize(); !resp.Ok {
+		t.Fatalf("expected allowed after reset, got %+v", resp)
+	}
+}
+
+func TestGRPCSubnetDenial(t *testing.T) {
+	ctx := context.Background()
+	large := bucket.Config{Capacity: 100, RefillPerMinute: 1}
+	rl := bucket.NewMemoryRateLimiter(5*time.Minute, large, large, large)
+	t.Cleanup(rl.Close)
+	subnets, err := bucket.ParseSubnets("ipv4/24=2:1")
+	if err != nil {
+		t.Fatalf("ParseSubnets: %v", err)
+	}
+	if err := rl.SetLimits(large, large, large, subnets, bucket.ConsumeEach); err != nil {
+		t.Fatalf("SetLimits: %v", err)
+	}
+	store := storage.NewInMemoryStorage()
+	t.Cleanup(store.Close)
+	client := newTestClient(t, service.New(store, rl), nil, nil)
+	var resp *pb.AuthorizeResponse
+	// Each address of the /24 has a fresh bucket, the subnet has two tokens.
+	for _, ip := range []string{"203.0.113.1", "203.0.113.2", "203.0.113.3"} {
+		if resp, err = client.Authorize(ctx, &pb.AuthorizeRequest{Login: ip, Password: "pw", Ip: ip}); err != nil {
+			t.Fatalf("Authorize: %v", err)
+		}
+	}
+	if resp.GetOk() || resp.GetReason() != service.ReasonSubnetRateLimited || resp.GetSubnet() != "ipv4/24" {
+		t.Fatalf("expected the subnet to be named in the denial, got %+v", resp)
+	}
+}
+
+func TestGRPCLists(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, newTestService(t), nil, nil)
+	resp, err := client.AddToBlacklist(ctx, &pb.ListRequest{Ip: "192.0.2.0/24", Ttl: durationpb.New(time.Minute)})
+	if err != nil || !resp.GetOk() {
+		t.Fatalf("expected blacklist add, got %v, %v", resp, err)
+	}
+	resp, err = client.AddToWhitelist(ctx, &pb.ListRequest{Ip: "192.0.2.7"})
+	if err != nil || resp.GetOk() || resp.GetReason() == "" {
+		t.Fatalf("expected overlap refusal, got %v, %v", resp, err)
+	}
+	lists, err := client.ListLists(ctx, &pb.ListListsRequest{})
+	if err != nil {
+		t.Fatalf("ListLists: %v", err)
+	}
+	if len(lists.GetBlacklist()) != 1 || lists.GetBlacklistExpiresIn()["192.0.2.0/24"] <= 0 {
+		t.Fatalf("unexpected lists: %v", lists)
+	}
+	if resp, err := client.RemoveFromBlacklist(ctx, &pb.ListRequest{Ip: "192.0.2.0/24"}); err != nil || !resp.GetOk() {
+		t.Fatalf("expected blacklist removal, got %v, %v", resp, err)
+	}
+	_, err = client.AddToWhitelist(ctx, &pb.ListRequest{Ip: "not-an-ip"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+	// An explicit TTL must be positive, as over HTTP.
+	_, err = client.AddToWhitelist(ctx, &pb.ListRequest{Ip: "198.51.100.0/24", Ttl: durationpb.New(0)})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for a zero ttl, got %v", err)
+	}
+	_, err = client.Authorize(ctx, &pb.AuthorizeRequest{Login: "x", Ip: "not-an-ip"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+}
+
+// listsDown is a storage whose lists cannot be read.
+type listsDown struct {
+	storage.Storage
+}
+
+func (listsDown) BlackWhiteLists(context.Context) (map[string]*net.IPNet, map[string]*net.IPNet, error) {
+	return nil, nil, errors.New("connection refused")
+}
+
+func TestGRPCListsUnavailable(t *testing.T) {
+	rl := bucket.NewMemoryRateLimiter(5*time.Minute,
+		bucket.Config{Capacity: 1, RefillPerMinute: 0},
+		bucket.Config{Capacity: 1, RefillPerMinute: 0},
+		bucket.Config{Capacity: 1, RefillPerMinute: 0})
+	t.Cleanup(rl.Close)
+	client := newTestClient(t, service.New(listsDown{}, rl), nil, nil)
+	if _, err := client.ListLists(context.Background(), &pb.ListListsRequest{}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable when the lists cannot be read, got %v", err)
+	}
+}
+
+func TestGRPCAdminNeedsRoles(t *testing.T) {
+	authn, err := auth.New([]auth.Key{{Name: "viewer", Roles: []auth.Role{auth.RoleViewer}, Key: "view-secret"}})
+	if err != nil {
+		t.Fatalf("auth.New: %v", err)
+	}
+	client := newTestClient(t, newTestService(t), authn, nil)
+	ctx := context.Background()
+	viewer := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer view-secret")
+	if _, err := client.ListLists(ctx, &pb.ListListsRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated without credentials, got %v", err)
+	}
+	if _, err := client.ListLists(viewer, &pb.ListListsRequest{}); err != nil {
+		t.Fatalf("expected the viewer to list, got %v", err)
+	}
+	if _, err := client.AddToBlacklist(viewer, &pb.ListRequest{Ip: "192.0.2.0/24"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for the viewer, got %v", err)
+	}
+	if _, err := client.Authorize(ctx, &pb.AuthorizeRequest{Login: "x", Password: "pw", Ip: "192.0.2.1"}); err != nil {
+		t.Fatalf("expected Authorize to stay open, got %v", err)
+	}
+	report := &pb.ReportRequest{Login: "x", Password: "pw", Ip: "192.0.2.1", Success: true}
+	if _, err := client.Report(viewer, report); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for a report without the reporter role, got %v", err)
+	}
+}
+
+func TestGRPCAdminWithoutKeys(t *testing.T) {
+	keyless, err := auth.New(nil)
+	if err != nil {
+		t.Fatalf("auth.New: %v", err)
+	}
+	ctx := context.Background()
+	closed := newTestClient(t, newTestService(t), keyless, nil)
+	_, err = closed.AddToBlacklist(ctx, &pb.ListRequest{Ip: "192.0.2.0/24"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected no keys to close the admin RPCs, got %v", err)
+	}
+	open := newTestClient(t, newTestService(t), auth.NewDisabled(), nil)
+	if _, err := open.AddToBlacklist(ctx, &pb.ListRequest{Ip: "192.0.2.0/24"}); err != nil {
+		t.Fatalf("expected AUTH_DISABLED to open the admin RPCs, got %v", err)
+	}
+}
+
+func TestGRPCAudit(t *testing.T) {
+	auditLog := newTestAuditLog(t)
+	client := newTestClient(t, newTestService(t), nil, auditLog)
+	ctx := context.Background()
+	if _, err := client.ResetBucket(ctx, &pb.ResetBucketRequest{Login: "alice"}); err != nil {
+		t.Fatalf("ResetBucket: %v", err)
+	}
+	if _, err := client.AddToWhitelist(ctx, &pb.ListRequest{Ip: "not-an-ip"}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+	if _, err := client.ListLists(ctx, &pb.ListListsRequest{}); err != nil {
+		t.Fatalf("ListLists: %v", err)
+	}
+	entries, err := auditLog.Query(ctx, audit.Filter{})
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %+v, %v", entries, err)
+	}
+	if e := entries[0]; e.Action != audit.ActionWhitelistAdd || e.OK || e.Status != "InvalidArgument" || e.Actor != "anonymous" {
+		t.Fatalf("expected the failed whitelist add, got %+v", e)
+	}
+	if e := entries[1]; e.Action != audit.ActionBucketResetLogin || !e.OK || !strings.Contains(string(e.Payload), "alice") {
+		t.Fatalf("expected the login reset, got %+v", e)
+	}
+}
@@ -1,15 +1,24 @@
 package api
 
 import (
-	"log"
 	"net/http"
 	"time"
+
+	"github.com/meladark/special-train/internal/logging"
+	"github.com/meladark/special-train/internal/metrics"
 )
 
-func loggingMiddleware(next http.Handler) http.Handler {
+// metricsMiddleware records the latency of every request under the route
+// pattern the mux matched, so paths never blow up the label set.
+func metricsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		next.ServeHTTP(w, r)
-		log.Printf("%s %s %s", r.Method, r.URL.Path, time.Since(start))
+		rec := logging.NewStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.ObserveHTTP(route, r.Method, rec.Status, start)
 	})
 }
@@ -3,19 +3,55 @@ package api
 import (
 	"net/http"
 
+	"github.com/meladark/special-train/internal/audit"
+	"github.com/meladark/special-train/internal/auth"
+	"github.com/meladark/special-train/internal/gateway"
+	"github.com/meladark/special-train/internal/logging"
+	"github.com/meladark/special-train/internal/metrics"
 	"github.com/meladark/special-train/internal/service"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
-func NewRouter(svc *service.Service) http.Handler {
+// NewRouter serves the decision endpoints, which the login flow calls and
+// which stay open, next to /api/report and the admin endpoints, which need
+// credentials holding the role of the route when authn is enabled: a report
+// refunds tokens, so only the login service may send one. Admin calls that
+// change state are recorded in auditLog. When config is set it returns the
+// live configuration, secrets masked, for /api/view/config.
+func NewRouter(
+	svc *service.Service,
+	gw gateway.Config,
+	authn *auth.Authenticator,
+	auditLog *audit.Log,
+	config func() map[string]any,
+) http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/authorize", svc.AuthorizeHandler)
-	mux.HandleFunc("/api/bucket/reset", svc.ResetBucketHandler)
-	mux.HandleFunc("/api/bucket/reset/ip", svc.ResetBucketIPHandler)
-	mux.HandleFunc("/api/bucket/reset/login", svc.ResetBucketLoginHandler)
-	mux.HandleFunc("/api/whitelist/add", svc.WhitelistHandler)
-	mux.HandleFunc("/api/blacklist/add", svc.BlacklistHandler)
-	mux.HandleFunc("/api/whitelist/del", svc.RemoveFromWhitelistHandler)
-	mux.HandleFunc("/api/blacklist/del", svc.RemoveFromBlacklistHandler)
-	mux.HandleFunc("/api/view/lists", svc.ViewListsHandler)
-	return loggingMiddleware(mux)
+	mux.HandleFunc("/api/auth_request", authRequestHandler(svc, gw))
+	mux.Handle("/metrics", metrics.Handler())
+
+	admin := func(pattern string, role auth.Role, h http.Handler) {
+		mux.Handle(pattern, authn.Require(role, h))
+	}
+	audited := func(pattern string, role auth.Role, action string, h http.HandlerFunc) {
+		admin(pattern, role, auditMiddleware(auditLog, action, h))
+	}
+	admin("/api/report", auth.RoleReporter, http.HandlerFunc(svc.ReportHandler))
+	audited("/api/bucket/reset", auth.RoleResetter, audit.ActionBucketReset, svc.ResetBucketHandler)
+	audited("/api/bucket/reset/ip", auth.RoleResetter, audit.ActionBucketResetIP, svc.ResetBucketIPHandler)
+	audited("/api/bucket/reset/login", auth.RoleResetter, audit.ActionBucketResetLogin, svc.ResetBucketLoginHandler)
+	audited("/api/whitelist/add", auth.RoleListEditor, audit.ActionWhitelistAdd, svc.WhitelistHandler)
+	audited("/api/blacklist/add", auth.RoleListEditor, audit.ActionBlacklistAdd, svc.BlacklistHandler)
+	audited("/api/whitelist/del", auth.RoleListEditor, audit.ActionWhitelistDel, svc.RemoveFromWhitelistHandler)
+	audited("/api/blacklist/del", auth.RoleListEditor, audit.ActionBlacklistDel, svc.RemoveFromBlacklistHandler)
+	audited("/api/override/add", auth.RoleLimitEditor, audit.ActionOverrideAdd, svc.OverrideAddHandler)
+	audited("/api/override/del", auth.RoleLimitEditor, audit.ActionOverrideDel, svc.OverrideDelHandler)
+	admin("/api/view/lists", auth.RoleViewer, http.HandlerFunc(svc.ViewListsHandler))
+	admin("/api/view/autoban", auth.RoleViewer, http.HandlerFunc(svc.ViewAutoBanHandler))
+	admin("/api/view/overrides", auth.RoleViewer, http.HandlerFunc(svc.ViewOverridesHandler))
+	admin("/api/audit", auth.RoleViewer, auditHandler(auditLog))
+	if config != nil {
+		admin("/api/view/config", auth.RoleViewer, configHandler(config))
+	}
+	return logging.Middleware(otelhttp.NewHandler(metricsMiddleware(mux), "http.server"))
 }
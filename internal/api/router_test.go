@@ -0,0 +1,195 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/meladark/special-train/internal/audit"
+	"github.com/meladark/special-train/internal/auth"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	router := NewRouter(newTestService(t), testGateway, nil, nil, nil)
+	req := httptest.NewRequest(http.MethodPost, "/api/authorize",
+		strings.NewReader(`{"login":"carol","password":"pw","ip":"192.0.2.3"}`))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 from /metrics, got %d", rec.Code)
+	}
+	for _, want := range []string{
+		`antibruteforce_http_request_duration_seconds_count{code="200",method="POST",route="/api/authorize"}`,
+		`antibruteforce_authorize_decisions_total{mode="normal",outcome="allowed",reason="within_limits"}`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Fatalf("expected %s in /metrics output", want)
+		}
+	}
+}
+
+func TestAdminRoutesNeedRoles(t *testing.T) {
+	authn, err := auth.New([]auth.Key{
+		{Name: "viewer", Roles: []auth.Role{auth.RoleViewer}, Key: "view-secret"},
+		{Name: "editor", Roles: []auth.Role{auth.RoleListEditor}, Key: "edit-secret"},
+		{Name: "login-service", Roles: []auth.Role{auth.RoleReporter}, Key: "report-secret"},
+	})
+	if err != nil {
+		t.Fatalf("auth.New: %v", err)
+	}
+	router := NewRouter(newTestService(t), testGateway, authn, nil, nil)
+	serve := func(path, token, body string) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	tests := []struct {
+		path, token string
+		want        int
+	}{
+		{"/api/whitelist/add", "", http.StatusUnauthorized},
+		{"/api/whitelist/add", "view-secret", http.StatusForbidden},
+		{"/api/whitelist/add", "edit-secret", http.StatusOK},
+		{"/api/bucket/reset", "edit-secret", http.StatusForbidden},
+		{"/api/view/lists", "view-secret", http.StatusOK},
+		{"/api/view/lists", "wrong", http.StatusUnauthorized},
+		{"/api/authorize", "", http.StatusOK},
+		{"/api/report", "", http.StatusUnauthorized},
+		{"/api/report", "edit-secret", http.StatusForbidden},
+		{"/api/report", "report-secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		body := `{"ip":"192.0.2.50"}`
+		if tt.path == "/api/authorize" || tt.path == "/api/report" {
+			body = `{"login":"dave","password":"pw","ip":"192.0.2.4","success":true}`
+		}
+		if got := serve(tt.path, tt.token, body); got != tt.want {
+			t.Fatalf("%s with %q: expected %d, got %d", tt.path, tt.token, tt.want, got)
+		}
+	}
+}
+
+func TestAdminRoutesWithoutKeys(t *testing.T) {
+	keyless, err := auth.New(nil)
+	if err != nil {
+		t.Fatalf("auth.New: %v", err)
+	}
+	serve := func(authn *auth.Authenticator, path, body string) int {
+		router := NewRouter(newTestService(t), testGateway, authn, nil, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return rec.Code
+	}
+	add := `{"ip":"192.0.2.50"}`
+	if got := serve(keyless, "/api/whitelist/add", add); got != http.StatusUnauthorized {
+		t.Fatalf("expected no keys to close the admin routes, got %d", got)
+	}
+	if got := serve(keyless, "/api/authorize", `{"login":"dave","password":"pw","ip":"192.0.2.4"}`); got != http.StatusOK {
+		t.Fatalf("expected the decision routes to stay open, got %d", got)
+	}
+	if got := serve(auth.NewDisabled(), "/api/whitelist/add", add); got != http.StatusOK {
+		t.Fatalf("expected AUTH_DISABLED to open the admin routes, got %d", got)
+	}
+}
+
+func newTestAuditLog(t *testing.T) *audit.Log {
+	t.Helper()
+	sink, err := audit.NewFileSink(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatalf("NewFileSink: %v", err)
+	}
+	t.Cleanup(func() { _ = sink.Close() })
+	return audit.New(sink)
+}
+
+func TestAuditRecordsAdminCalls(t *testing.T) {
+	authn, err := auth.New([]auth.Key{
+		{Name: "viewer", Roles: []auth.Role{auth.RoleViewer}, Key: "view-secret"},
+		{Name: "editor", Roles: []auth.Role{auth.RoleListEditor}, Key: "edit-secret"},
+	})
+	if err != nil {
+		t.Fatalf("auth.New: %v", err)
+	}
+	router := NewRouter(newTestService(t), testGateway, authn, newTestAuditLog(t), nil)
+	serve := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	serve(http.MethodPost, "/api/blacklist/add", "edit-secret", `{"ip":"10.0.0.0/8"}`)
+	// Overlaps the blacklist entry, refused with a 200 and ok false.
+	serve(http.MethodPost, "/api/whitelist/add", "edit-secret", `{"ip":"10.1.0.0/16"}`)
+	serve(http.MethodGet, "/api/view/lists", "view-secret", "")
+	serve(http.MethodPost, "/api/whitelist/add", "view-secret", `{"ip":"10.2.0.0/16"}`)
+
+	rec := serve(http.MethodGet, "/api/audit?actor=editor", "view-secret", "")
+	var resp struct {
+		Entries []audit.Entry `json:"entries"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON response %q: %v", rec.Body.String(), err)
+	}
+	if len(resp.Entries) != 2 {
+		t.Fatalf("expected the 2 calls of the editor, views and denied calls left out, got %+v", resp.Entries)
+	}
+	refused, added := resp.Entries[0], resp.Entries[1]
+	if refused.Action != audit.ActionWhitelistAdd || refused.OK || refused.Status != "200" ||
+		!strings.Contains(string(refused.Payload), "10.1.0.0/16") || !strings.Contains(string(refused.Result), "reason") {
+		t.Fatalf("expected the refused whitelist add, got %+v", refused)
+	}
+	if added.Action != audit.ActionBlacklistAdd || !added.OK || added.SourceIP != "192.0.2.1" || added.RequestID == "" {
+		t.Fatalf("expected the blacklist add from 192.0.2.1, got %+v", added)
+	}
+	if rec := serve(http.MethodGet, "/api/audit", "edit-secret", ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected the audit log to need the viewer role, got %d", rec.Code)
+	}
+	if rec := serve(http.MethodGet, "/api/audit?since=yesterday", "view-secret", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid since, got %d", rec.Code)
+	}
+}
+
+func TestViewConfig(t *testing.T) {
+	authn, err := auth.New([]auth.Key{
+		{Name: "viewer", Roles: []auth.Role{auth.RoleViewer}, Key: "view-secret"},
+		{Name: "editor", Roles: []auth.Role{auth.RoleListEditor}, Key: "edit-secret"},
+	})
+	if err != nil {
+		t.Fatalf("auth.New: %v", err)
+	}
+	port := "8080"
+	config := func() map[string]any { return map[string]any{"port": port} }
+	router := NewRouter(newTestService(t), testGateway, authn, nil, config)
+	serve := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/view/config", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := serve("edit-secret"); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected the config to need the viewer role, got %d", rec.Code)
+	}
+	port = "9000"
+	rec := serve("view-secret")
+	var resp struct {
+		Ok     bool           `json:"ok"`
+		Config map[string]any `json:"config"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid JSON response %q: %v", rec.Body.String(), err)
+	}
+	if !resp.Ok || resp.Config["port"] != "9000" {
+		t.Fatalf("expected the live config, got %+v", resp)
+	}
+}
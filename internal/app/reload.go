@@ -0,0 +1,126 @@
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchFiles calls onChange for every change to files until the returned
+// watcher is closed. Directories are watched rather than the files, so
+// replacing a file by a rename, as editors do, is noticed too; events on
+// their other entries are ignored. A file that is a symlink also counts as
+// changed when its target does, which is how Kubernetes volume mounts swap
+// their content.
+func watchFiles(files []string, onChange func(fsnotify.Event)) (*fsnotify.Watcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	dirs := map[string]bool{}
+	targets := map[string]string{}
+	for _, f := range files {
+		if f == "" {
+			continue
+		}
+		f = filepath.Clean(f)
+		targets[f], _ = filepath.EvalSymlinks(f)
+		dir := filepath.Dir(f)
+		if dirs[dir] {
+			continue
+		}
+		dirs[dir] = true
+		if err := w.Add(dir); err != nil {
+			w.Close()
+			return nil, fmt.Errorf("watch %s: %w", dir, err)
+		}
+	}
+	// changed reports whether ev concerns one of the files, updating the
+	// symlink targets on the way.
+	changed := func(ev fsnotify.Event) bool {
+		_, hit := targets[filepath.Clean(ev.Name)]
+		for f, target := range targets {
+			if now, _ := filepath.EvalSymlinks(f); now != target {
+				targets[f] = now
+				hit = true
+			}
+		}
+		return hit
+	}
+	go func() {
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if !ev.Has(fsnotify.Chmod) && changed(ev) {
+					onChange(ev)
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("file watcher error", "err", err)
+			}
+		}
+	}()
+	return w, nil
+}
+
+// Reloader calls reload whenever one of its files changes or the process
+// receives SIGHUP, one call at a time. reload is told what triggered it.
+type Reloader struct {
+	mu      sync.Mutex
+	reload  func(trigger string)
+	watcher *fsnotify.Watcher
+	signals chan os.Signal
+	done    chan struct{}
+}
+
+// NewReloader starts watching files, which may be empty to reload on SIGHUP
+// only.
+func NewReloader(files []string, reload func(trigger string)) (*Reloader, error) {
+	r := &Reloader{reload: reload, signals: make(chan os.Signal, 1), done: make(chan struct{})}
+	if len(files) > 0 {
+		w, err := watchFiles(files, func(ev fsnotify.Event) { r.run("file " + ev.Name) })
+		if err != nil {
+			return nil, fmt.Errorf("reload: %w", err)
+		}
+		r.watcher = w
+	}
+	signal.Notify(r.signals, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-r.signals:
+				r.run("SIGHUP")
+			case <-r.done:
+				return
+			}
+		}
+	}()
+	return r, nil
+}
+
+func (r *Reloader) run(trigger string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reload(trigger)
+}
+
+// Close stops watching, SIGHUP gets its default behavior back.
+func (r *Reloader) Close() error {
+	signal.Stop(r.signals)
+	close(r.done)
+	if r.watcher == nil {
+		return nil
+	}
+	return r.watcher.Close()
+}
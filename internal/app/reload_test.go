@@ -0,0 +1,86 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReloaderTriggers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeFile(t, path, []byte("capacity_login: 10\n"))
+	triggers := make(chan string, 16)
+	r, err := NewReloader([]string{path}, func(trigger string) { triggers <- trigger })
+	if err != nil {
+		t.Fatalf("NewReloader: %v", err)
+	}
+	t.Cleanup(func() { _ = r.Close() })
+	wait := func(what string) string {
+		t.Helper()
+		select {
+		case trigger := <-triggers:
+			return trigger
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected a reload on %s", what)
+		}
+		return ""
+	}
+
+	writeFile(t, path, []byte("capacity_login: 20\n"))
+	if trigger := wait("a file change"); trigger == "SIGHUP" {
+		t.Fatalf("expected a file trigger, got %s", trigger)
+	}
+	p, _ := os.FindProcess(os.Getpid())
+	if err := p.Signal(syscall.SIGHUP); err != nil {
+		t.Skipf("cannot send SIGHUP here: %v", err)
+	}
+	// Late events of the rename may still come first.
+	for trigger := wait("SIGHUP"); trigger != "SIGHUP"; trigger = wait("SIGHUP") {
+	}
+}
+
+func TestReloaderWatchesOnlyItsFiles(t *testing.T) {
+	dir := t.TempDir()
+	// Laid out like a Kubernetes volume mount: the file links through ..data,
+	// which is swapped to a new directory on update.
+	for _, v := range []string{"v1", "v2"} {
+		if err := os.Mkdir(filepath.Join(dir, v), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, filepath.Join(dir, v, "config.yaml"), []byte("capacity_login: 10\n"))
+	}
+	if err := os.Symlink("v1", filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.Symlink(filepath.Join("..data", "config.yaml"), path); err != nil {
+		t.Fatal(err)
+	}
+	triggers := make(chan string, 16)
+	r, err := NewReloader([]string{path}, func(trigger string) { triggers <- trigger })
+	if err != nil {
+		t.Fatalf("NewReloader: %v", err)
+	}
+	t.Cleanup(func() { _ = r.Close() })
+
+	writeFile(t, filepath.Join(dir, "other.yaml"), []byte("unrelated\n"))
+	select {
+	case trigger := <-triggers:
+		t.Fatalf("expected a sibling file to be ignored, got a reload on %s", trigger)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if err := os.Symlink("v2", filepath.Join(dir, "..data_tmp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-triggers:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a reload when the mount swaps the file's target")
+	}
+}
@@ -2,15 +2,23 @@ package app
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"time"
+
+	"google.golang.org/grpc"
 )
 
 type Server struct {
-	httpServer *http.Server
+	httpServer  *http.Server
+	proxyServer *http.Server
+	grpcServer  *grpc.Server
+	grpcAddr    string
+	tls         bool
 }
 
 func NewServer(addr string, handler http.Handler) *Server {
@@ -23,17 +31,75 @@ func NewServer(addr string, handler http.Handler) *Server {
 	}
 }
 
+// SetGRPCServer makes Run serve srv on addr next to the HTTP server.
+func (s *Server) SetGRPCServer(addr string, srv *grpc.Server) {
+	s.grpcAddr = addr
+	s.grpcServer = srv
+}
+
+// SetTLS makes the API server accept TLS connections only, negotiated with
+// cfg, whose certificates come from GetCertificate or GetConfigForClient.
+func (s *Server) SetTLS(cfg *tls.Config) {
+	s.httpServer.TLSConfig = cfg
+	s.tls = true
+}
+
+// SetProxy makes Run serve the reverse proxy handler on addr next to the API.
+func (s *Server) SetProxy(addr string, handler http.Handler) {
+	s.proxyServer = &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+}
+
 func (s *Server) Run() error {
 	go func() {
-		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("listen error: %v", err)
+		if err := s.listen(); err != nil && err != http.ErrServerClosed {
+			slog.Error("listen error", "err", err)
+			os.Exit(1)
 		}
 	}()
+	if s.proxyServer != nil {
+		go func() {
+			if err := s.proxyServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("proxy listen error", "err", err)
+				os.Exit(1)
+			}
+		}()
+	}
+	if s.grpcServer != nil {
+		lis, err := net.Listen("tcp", s.grpcAddr)
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := s.grpcServer.Serve(lis); err != nil {
+				slog.Error("grpc listen error", "err", err)
+				os.Exit(1)
+			}
+		}()
+	}
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt)
 	<-quit
-	log.Println("Shutting down server...")
+	slog.Info("shutting down server")
+	if s.grpcServer != nil {
+		s.grpcServer.GracefulStop()
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	if s.proxyServer != nil {
+		if err := s.proxyServer.Shutdown(ctx); err != nil {
+			slog.Error("proxy shutdown error", "err", err)
+		}
+	}
 	return s.httpServer.Shutdown(ctx)
 }
+
+func (s *Server) listen() error {
+	if s.tls {
+		return s.httpServer.ListenAndServeTLS("", "")
+	}
+	return s.httpServer.ListenAndServe()
+}
@@ -0,0 +1,142 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ClientAuth selects how the TLS listeners treat client certificates.
+type ClientAuth string
+
+const (
+	// ClientAuthNone never asks for a client certificate.
+	ClientAuthNone ClientAuth = "none"
+	// ClientAuthAdmin verifies a client certificate when one is presented and
+	// leaves it to the admin endpoints to demand one.
+	ClientAuthAdmin ClientAuth = "admin"
+	// ClientAuthAll refuses connections without a verified client certificate.
+	ClientAuthAll ClientAuth = "all"
+)
+
+// ParseClientAuth parses a TLS_CLIENT_AUTH value, empty meaning none.
+func ParseClientAuth(s string) (ClientAuth, error) {
+	switch c := ClientAuth(s); c {
+	case "":
+		return ClientAuthNone, nil
+	case ClientAuthNone, ClientAuthAdmin, ClientAuthAll:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown client auth %q, expected none, admin or all", s)
+}
+
+func (c ClientAuth) tlsType() tls.ClientAuthType {
+	switch c {
+	case ClientAuthAdmin:
+		return tls.VerifyClientCertIfGiven
+	case ClientAuthAll:
+		return tls.RequireAndVerifyClientCert
+	}
+	return tls.NoClientCert
+}
+
+// CertReloader serves a certificate, and the CA pool client certificates are
+// verified against, read from files that it reloads whenever they change, so
+// certificates can be rotated without a restart.
+type CertReloader struct {
+	certFile, keyFile, caFile string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	pool *x509.CertPool
+
+	watcher *fsnotify.Watcher
+}
+
+// NewCertReloader loads the key pair and, when caFile is set, the client CA
+// bundle.
+func NewCertReloader(certFile, keyFile, caFile string) (*CertReloader, error) {
+	c := &CertReloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload reads the files again. On error the certificates already loaded
+// stay in use.
+func (c *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("tls: load key pair: %w", err)
+	}
+	var pool *x509.CertPool
+	if c.caFile != "" {
+		pem, err := os.ReadFile(c.caFile)
+		if err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("tls: no certificate found in %s", c.caFile)
+		}
+	}
+	c.mu.Lock()
+	c.cert, c.pool = &cert, pool
+	c.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the files on every change until Close.
+func (c *CertReloader) Watch() error {
+	w, err := watchFiles([]string{c.certFile, c.keyFile, c.caFile}, func(ev fsnotify.Event) {
+		// The certificate and the key are seldom written at once, so a
+		// mismatch here usually heals with the next event.
+		if err := c.Reload(); err != nil {
+			slog.Warn("certificate reload failed, keeping the previous one", "file", ev.Name, "err", err)
+			return
+		}
+		slog.Info("certificates reloaded", "file", ev.Name)
+	})
+	if err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	c.watcher = w
+	return nil
+}
+
+// Close stops watching the files.
+func (c *CertReloader) Close() error {
+	if c.watcher == nil {
+		return nil
+	}
+	return c.watcher.Close()
+}
+
+// TLSConfig returns a server configuration that always presents the latest
+// certificate and verifies client certificates as auth asks.
+func (c *CertReloader) TLSConfig(auth ClientAuth) (*tls.Config, error) {
+	if auth != ClientAuthNone && c.caFile == "" {
+		return nil, errors.New("tls: client certificate verification needs a client CA file")
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c.mu.RLock()
+			defer c.mu.RUnlock()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*c.cert},
+				ClientAuth:   auth.tlsType(),
+				ClientCAs:    c.pool,
+				NextProtos:   []string{"h2", "http/1.1"},
+			}, nil
+		},
+	}, nil
+}
@@ -0,0 +1,210 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the PEM certificate and key of a leaf named cn.
+func (ca *testCA) issue(t *testing.T, cn string, serial int64, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	// Write then rename, the way secret mounts and cert managers swap files.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startTLS serves 200 behind the reloader, with the server certificate
+// issued to localhost.
+func startTLS(t *testing.T, ca *testCA, auth ClientAuth) (*httptest.Server, *CertReloader, string) {
+	t.Helper()
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	certPEM, keyPEM := ca.issue(t, "localhost", 2, x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+	writeFile(t, caFile, ca.pem)
+	certs, err := NewCertReloader(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("NewCertReloader: %v", err)
+	}
+	t.Cleanup(func() { certs.Close() })
+	cfg, err := certs.TLSConfig(auth)
+	if err != nil {
+		t.Fatalf("TLSConfig: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.VerifiedChains) > 0 {
+			w.Header().Set("X-Client", r.TLS.VerifiedChains[0][0].Subject.CommonName)
+		}
+	}))
+	srv.TLS = cfg
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv, certs, dir
+}
+
+func testClient(t *testing.T, ca *testCA, clientCert bool) *http.Client {
+	t.Helper()
+	cfg := &tls.Config{RootCAs: x509.NewCertPool(), ServerName: "localhost"}
+	cfg.RootCAs.AddCert(ca.cert)
+	if clientCert {
+		certPEM, keyPEM := ca.issue(t, "ops", 3, x509.ExtKeyUsageClientAuth)
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+}
+
+func TestClientAuthModes(t *testing.T) {
+	ca := newTestCA(t)
+	tests := []struct {
+		auth           ClientAuth
+		withoutCertOK  bool
+		wantClientName string
+	}{
+		{ClientAuthNone, true, ""},
+		{ClientAuthAdmin, true, "ops"},
+		{ClientAuthAll, false, "ops"},
+	}
+	for _, tt := range tests {
+		srv, _, _ := startTLS(t, ca, tt.auth)
+		resp, err := testClient(t, ca, false).Get(srv.URL)
+		if (err == nil) != tt.withoutCertOK {
+			t.Fatalf("%s: expected success %v without a client certificate, got %v", tt.auth, tt.withoutCertOK, err)
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+		resp, err = testClient(t, ca, true).Get(srv.URL)
+		if err != nil {
+			t.Fatalf("%s: expected a client certificate to be accepted, got %v", tt.auth, err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("X-Client"); got != tt.wantClientName {
+			t.Fatalf("%s: expected verified client %q, got %q", tt.auth, tt.wantClientName, got)
+		}
+	}
+}
+
+func TestClientAuthNeedsCA(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certPEM, keyPEM := ca.issue(t, "localhost", 2, x509.ExtKeyUsageServerAuth)
+	writeFile(t, filepath.Join(dir, "tls.crt"), certPEM)
+	writeFile(t, filepath.Join(dir, "tls.key"), keyPEM)
+	certs, err := NewCertReloader(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), "")
+	if err != nil {
+		t.Fatalf("NewCertReloader: %v", err)
+	}
+	if _, err := certs.TLSConfig(ClientAuthAdmin); err == nil {
+		t.Fatal("expected an error for client auth without a CA")
+	}
+	if _, err := ParseClientAuth("optional"); err == nil {
+		t.Fatal("expected an error for an unknown client auth")
+	}
+}
+
+func TestCertReloadOnChange(t *testing.T) {
+	ca := newTestCA(t)
+	srv, certs, dir := startTLS(t, ca, ClientAuthNone)
+	if err := certs.Watch(); err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	serial := func() int64 {
+		resp, err := testClient(t, ca, false).Get(srv.URL)
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].SerialNumber.Int64()
+	}
+	if got := serial(); got != 2 {
+		t.Fatalf("expected serial 2, got %d", got)
+	}
+	certPEM, keyPEM := ca.issue(t, "localhost", 42, x509.ExtKeyUsageServerAuth)
+	writeFile(t, filepath.Join(dir, "tls.key"), keyPEM)
+	writeFile(t, filepath.Join(dir, "tls.crt"), certPEM)
+	deadline := time.Now().Add(5 * time.Second)
+	for serial() != 42 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the rotated certificate to be served")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
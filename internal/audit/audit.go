@@ -0,0 +1,119 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+// Actions recorded for the admin calls that change state.
+const (
+	ActionWhitelistAdd = "whitelist.add"
+	ActionWhitelistDel = "whitelist.del"
+	ActionBlacklistAdd = "blacklist.add"
+	ActionBlacklistDel = "blacklist.del"
+	ActionBucketReset  = "bucket.reset"
+	// ActionBucketResetIP and ActionBucketResetLogin reset the buckets of a
+	// single IP or login.
+	ActionBucketResetIP    = "bucket.reset_ip"
+	ActionBucketResetLogin = "bucket.reset_login"
+	ActionOverrideAdd      = "override.add"
+	ActionOverrideDel      = "override.del"
+)
+
+// Entry is one admin call. Payload is the request as the client sent it and
+// Result the response, Status being the HTTP status or the gRPC code. ID is
+// set by the sink.
+type Entry struct {
+	ID        string          `json:"id,omitempty"`
+	Time      time.Time       `json:"time"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	SourceIP  string          `json:"source_ip"`
+	RequestID string          `json:"request_id,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Status    string          `json:"status"`
+	OK        bool            `json:"ok"`
+	Result    json.RawMessage `json:"result,omitempty"`
+}
+
+// Filter selects entries in Query. Zero fields match everything, Limit
+// defaults to DefaultLimit.
+type Filter struct {
+	Actor  string
+	Action string
+	Since  time.Time
+	Limit  int
+}
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+func (f Filter) limit() int {
+	switch {
+	case f.Limit <= 0:
+		return DefaultLimit
+	case f.Limit > MaxLimit:
+		return MaxLimit
+	}
+	return f.Limit
+}
+
+func (f Filter) match(e Entry) bool {
+	return (f.Actor == "" || e.Actor == f.Actor) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		!e.Time.Before(f.Since)
+}
+
+// Sink stores entries and never changes or drops the ones it stored.
+type Sink interface {
+	Append(ctx context.Context, e Entry) error
+	// Query returns the matching entries, newest first.
+	Query(ctx context.Context, f Filter) ([]Entry, error)
+}
+
+// Log records admin calls in a sink. A nil Log records nothing.
+type Log struct {
+	sink Sink
+	now  func() time.Time
+}
+
+func New(sink Sink) *Log {
+	return &Log{sink: sink, now: time.Now}
+}
+
+// Record stamps e with the current time and appends it. A failure is logged
+// rather than returned: the call it describes already happened.
+func (l *Log) Record(ctx context.Context, e Entry) {
+	if l == nil {
+		return
+	}
+	e.Time = l.now().UTC()
+	if err := l.sink.Append(ctx, e); err != nil {
+		slog.ErrorContext(ctx, "failed to write audit entry", "action", e.Action, "actor", e.Actor, "err", err)
+	}
+}
+
+// Query returns the entries matching f, newest first.
+func (l *Log) Query(ctx context.Context, f Filter) ([]Entry, error) {
+	if l == nil {
+		return []Entry{}, nil
+	}
+	return l.sink.Query(ctx, f)
+}
+
+// RawJSON returns data when it is valid JSON and data as a JSON string
+// otherwise, so plain text bodies can still be stored in Payload and Result.
+func RawJSON(data []byte) json.RawMessage {
+	if len(data) == 0 {
+		return nil
+	}
+	if json.Valid(data) {
+		return json.RawMessage(data)
+	}
+	s, _ := json.Marshal(string(data))
+	return s
+}
@@ -0,0 +1,99 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// FileSink appends entries as JSON lines to a file opened in append-only
+// mode. Entry IDs are line numbers.
+type FileSink struct {
+	mu    sync.Mutex
+	f     *os.File
+	lines int
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("audit: %w", err)
+	}
+	s := &FileSink{f: f}
+	if err := s.scan(func(Entry) {}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) Append(_ context.Context, e Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.ID = strconv.Itoa(s.lines + 1)
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	if _, err := s.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	s.lines++
+	return nil
+}
+
+// Query reads the whole file, it is meant for the occasional investigation.
+func (s *FileSink) Query(_ context.Context, f Filter) ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var matched []Entry
+	if err := s.scan(func(e Entry) {
+		if f.match(e) {
+			matched = append(matched, e)
+		}
+	}); err != nil {
+		return nil, err
+	}
+	out := make([]Entry, 0, min(len(matched), f.limit()))
+	for i := len(matched) - 1; i >= 0 && len(out) < f.limit(); i-- {
+		out = append(out, matched[i])
+	}
+	return out, nil
+}
+
+// scan calls fn for every entry and counts the lines. Callers hold s.mu or
+// own s exclusively.
+func (s *FileSink) scan(fn func(Entry)) error {
+	r, err := os.Open(s.f.Name())
+	if err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	defer r.Close()
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	s.lines = 0
+	for sc.Scan() {
+		s.lines++
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			// A crash can leave a torn last line, which must not make the
+			// rest of the log unreadable.
+			slog.Warn("skipping unreadable audit line", "file", s.f.Name(), "line", s.lines, "err", err)
+			continue
+		}
+		fn(e)
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	return nil
+}
+
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultStream is the stream RedisSink writes to.
+const DefaultStream = "audit:admin"
+
+// RedisSink appends entries to a Redis stream, so every replica writes to and
+// reads from the same log. Entry IDs are stream IDs.
+type RedisSink struct {
+	rdb    *redis.Client
+	stream string
+	maxLen int64
+}
+
+// NewRedisSink writes to stream. A positive maxLen caps the stream length
+// approximately, dropping the oldest entries; zero keeps every entry.
+func NewRedisSink(rdb *redis.Client, stream string, maxLen int64) *RedisSink {
+	if stream == "" {
+		stream = DefaultStream
+	}
+	return &RedisSink{rdb: rdb, stream: stream, maxLen: maxLen}
+}
+
+func (s *RedisSink) Append(ctx context.Context, e Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	args := &redis.XAddArgs{Stream: s.stream, Values: []any{"entry", data}}
+	if s.maxLen > 0 {
+		args.MaxLen, args.Approx = s.maxLen, true
+	}
+	if err := s.rdb.XAdd(ctx, args).Err(); err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	return nil
+}
+
+// clockSkew is the lag of the Redis clock behind ours that Query tolerates
+// when it cuts the scan at Since.
+const clockSkew = time.Minute
+
+// queryBatch is the number of stream entries read per round trip.
+const queryBatch = 256
+
+// Query walks the stream backwards from the newest entry, stopping shortly
+// before Since as stream IDs start with the time they were added at.
+func (s *RedisSink) Query(ctx context.Context, f Filter) ([]Entry, error) {
+	end := "-"
+	if !f.Since.IsZero() {
+		end = strconv.FormatInt(f.Since.Add(-clockSkew).UnixMilli(), 10)
+	}
+	out := make([]Entry, 0)
+	start := "+"
+	for len(out) < f.limit() {
+		msgs, err := s.rdb.XRevRangeN(ctx, s.stream, start, end, queryBatch).Result()
+		if err != nil {
+			return nil, fmt.Errorf("audit: %w", err)
+		}
+		for _, msg := range msgs {
+			raw, _ := msg.Values["entry"].(string)
+			var e Entry
+			if err := json.Unmarshal([]byte(raw), &e); err != nil {
+				return nil, fmt.Errorf("audit: entry %s: %w", msg.ID, err)
+			}
+			e.ID = msg.ID
+			if f.match(e) && len(out) < f.limit() {
+				out = append(out, e)
+			}
+		}
+		if len(msgs) < queryBatch {
+			break
+		}
+		start = "(" + msgs[len(msgs)-1].ID
+	}
+	return out, nil
+}
@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Role grants access to a group of admin endpoints.
+type Role string
+
+const (
+	// RoleViewer may read the lists, the autoban entries and the overrides.
+	RoleViewer Role = "viewer"
+	// RoleListEditor may add and remove whitelist and blacklist entries.
+	RoleListEditor Role = "list-editor"
+	// RoleResetter may reset buckets.
+	RoleResetter Role = "bucket-resetter"
+	// RoleLimitEditor may add and remove rate-limit overrides.
+	RoleLimitEditor Role = "limit-editor"
+	// RoleReporter may report login outcomes, which refund or charge
+	// buckets; it belongs to the login service.
+	RoleReporter Role = "reporter"
+	// RoleAdmin holds every other role.
+	RoleAdmin Role = "admin"
+)
+
+var roles = []Role{RoleViewer, RoleListEditor, RoleResetter, RoleLimitEditor, RoleReporter, RoleAdmin}
+
+var (
+	ErrUnauthenticated = errors.New("missing or invalid credentials")
+	ErrForbidden       = errors.New("credentials lack the required role")
+	// ErrClientCert is returned when RequireClientCert is set and the caller
+	// did not present a verified client certificate.
+	ErrClientCert = fmt.Errorf("%w: verified client certificate required", ErrUnauthenticated)
+)
+
+// Key is an API key, also accepted as a bearer token. The secret is given
+// either in clear in Key or as the hex SHA-256 of it in KeySHA256, so key
+// files need not hold secrets.
+type Key struct {
+	Name      string `json:"name"`
+	Roles     []Role `json:"roles"`
+	Key       string `json:"key,omitempty"`
+	KeySHA256 string `json:"key_sha256,omitempty"`
+}
+
+// Principal is the caller a key belongs to.
+type Principal struct {
+	Name  string
+	Roles []Role
+}
+
+// Has reports whether p holds role, directly or through RoleAdmin.
+func (p Principal) Has(role Role) bool {
+	return slices.Contains(p.Roles, role) || slices.Contains(p.Roles, RoleAdmin)
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying p.
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal of an authenticated request.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+type entry struct {
+	principal Principal
+	digest    [sha256.Size]byte
+}
+
+// Authenticator checks credentials against a fixed set of keys. Without
+// keys it refuses every request, unless RequireClientCert makes a verified
+// client certificate the credential. A nil or a disabled Authenticator lets
+// every request through.
+type Authenticator struct {
+	keys       []entry
+	clientCert bool
+	disabled   bool
+}
+
+// NewDisabled returns an Authenticator that lets every request through, for
+// deployments that opt out of authentication. RequireClientCert still
+// applies.
+func NewDisabled() *Authenticator {
+	return &Authenticator{disabled: true}
+}
+
+func New(keys []Key) (*Authenticator, error) {
+	a := &Authenticator{}
+	names := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if k.Name == "" {
+			return nil, errors.New("auth: key without a name")
+		}
+		if names[k.Name] {
+			return nil, fmt.Errorf("auth: duplicate key name %q", k.Name)
+		}
+		names[k.Name] = true
+		if len(k.Roles) == 0 {
+			return nil, fmt.Errorf("auth: key %q has no roles", k.Name)
+		}
+		for _, r := range k.Roles {
+			if !slices.Contains(roles, r) {
+				return nil, fmt.Errorf("auth: key %q: unknown role %q, expected one of %v", k.Name, r, roles)
+			}
+		}
+		e := entry{principal: Principal{Name: k.Name, Roles: k.Roles}}
+		switch {
+		case k.Key != "" && k.KeySHA256 != "":
+			return nil, fmt.Errorf("auth: key %q sets both key and key_sha256", k.Name)
+		case k.Key != "":
+			e.digest = sha256.Sum256([]byte(k.Key))
+		default:
+			raw, err := hex.DecodeString(k.KeySHA256)
+			if err != nil || len(raw) != sha256.Size {
+				return nil, fmt.Errorf("auth: key %q: key_sha256 must be 64 hex characters", k.Name)
+			}
+			copy(e.digest[:], raw)
+		}
+		a.keys = append(a.keys, e)
+	}
+	return a, nil
+}
+
+// RequireClientCert makes AuthorizePeer also demand a client certificate
+// verified by the TLS layer, on top of the keys.
+func (a *Authenticator) RequireClientCert() {
+	a.clientCert = true
+}
+
+// Enabled reports whether credentials are checked at all.
+func (a *Authenticator) Enabled() bool {
+	return a != nil && !a.disabled
+}
+
+// HasKeys reports whether any key is configured.
+func (a *Authenticator) HasKeys() bool {
+	return a != nil && len(a.keys) > 0
+}
+
+// Authenticate returns the principal token belongs to. Every key is compared
+// in constant time so the timing does not tell which one came close.
+func (a *Authenticator) Authenticate(token string) (Principal, error) {
+	if token == "" {
+		return Principal{}, ErrUnauthenticated
+	}
+	digest := sha256.Sum256([]byte(token))
+	var found *Principal
+	for i := range a.keys {
+		if subtle.ConstantTimeCompare(digest[:], a.keys[i].digest[:]) == 1 {
+			found = &a.keys[i].principal
+		}
+	}
+	if found == nil {
+		return Principal{}, ErrUnauthenticated
+	}
+	return *found, nil
+}
+
+// Authorize authenticates token and checks that it holds role. With a nil
+// or disabled Authenticator everyone is authorized as an anonymous principal.
+func (a *Authenticator) Authorize(token string, role Role) (Principal, error) {
+	if !a.Enabled() {
+		return Principal{Name: "anonymous"}, nil
+	}
+	p, err := a.Authenticate(token)
+	if err != nil {
+		return Principal{}, err
+	}
+	if !p.Has(role) {
+		return p, ErrForbidden
+	}
+	return p, nil
+}
+
+// AuthorizePeer is Authorize for a caller connected over state, nil for a
+// plain text connection. Without keys, a caller identified by its
+// certificate is named after its common name; when RequireClientCert is set
+// the certificate is all it needs.
+func (a *Authenticator) AuthorizePeer(token string, state *tls.ConnectionState, role Role) (Principal, error) {
+	verified := state != nil && len(state.VerifiedChains) > 0
+	if a != nil && a.clientCert && !verified {
+		return Principal{}, ErrClientCert
+	}
+	if a != nil && a.clientCert && !a.HasKeys() {
+		return Principal{Name: "cert:" + state.VerifiedChains[0][0].Subject.CommonName}, nil
+	}
+	p, err := a.Authorize(token, role)
+	if err == nil && !a.HasKeys() && verified {
+		p.Name = "cert:" + state.VerifiedChains[0][0].Subject.CommonName
+	}
+	return p, err
+}
+
+// Token extracts the credentials from an "Authorization: Bearer" or an
+// "X-API-Key" header, get being the header lookup of the transport.
+func Token(get func(string) string) string {
+	if v := get("Authorization"); v != "" {
+		scheme, token, ok := strings.Cut(v, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	return get("X-API-Key")
+}
+
+// ParseKeys parses keys written as name:role+role:secret, separated by
+// commas.
+func ParseKeys(s string) ([]Key, error) {
+	var keys []Key
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		parts := strings.SplitN(field, ":", 3)
+		if len(parts) != 3 || parts[2] == "" {
+			return nil, fmt.Errorf("auth: key %q is not name:roles:secret", parts[0])
+		}
+		var keyRoles []Role
+		for _, r := range strings.Split(parts[1], "+") {
+			keyRoles = append(keyRoles, Role(r))
+		}
+		keys = append(keys, Key{Name: parts[0], Roles: keyRoles, Key: parts[2]})
+	}
+	return keys, nil
+}
+
+// LoadKeysFile reads a JSON array of keys.
+func LoadKeysFile(path string) ([]Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("auth: %w", err)
+	}
+	var keys []Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("auth: %s: %w", path, err)
+	}
+	return keys, nil
+}
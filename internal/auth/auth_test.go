@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewValidatesKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		key  Key
+	}{
+		{"no name", Key{Roles: []Role{RoleViewer}, Key: "s"}},
+		{"no roles", Key{Name: "a", Key: "s"}},
+		{"unknown role", Key{Name: "a", Roles: []Role{"root"}, Key: "s"}},
+		{"both secrets", Key{Name: "a", Roles: []Role{RoleViewer}, Key: "s", KeySHA256: hex.EncodeToString(make([]byte, 32))}},
+		{"bad digest", Key{Name: "a", Roles: []Role{RoleViewer}, KeySHA256: "abc"}},
+	}
+	for _, tt := range tests {
+		if _, err := New([]Key{tt.key}); err == nil {
+			t.Fatalf("%s: expected an error", tt.name)
+		}
+	}
+	if _, err := New([]Key{{Name: "a", Roles: []Role{RoleViewer}, Key: "x"}, {Name: "a", Roles: []Role{RoleViewer}, Key: "y"}}); err == nil {
+		t.Fatal("expected an error for duplicate names")
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	digest := sha256.Sum256([]byte("hashed-secret"))
+	a, err := New([]Key{
+		{Name: "viewer", Roles: []Role{RoleViewer}, Key: "view-secret"},
+		{Name: "ops", Roles: []Role{RoleAdmin}, KeySHA256: hex.EncodeToString(digest[:])},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if p, err := a.Authorize("view-secret", RoleViewer); err != nil || p.Name != "viewer" {
+		t.Fatalf("expected viewer, got %+v %v", p, err)
+	}
+	if _, err := a.Authorize("view-secret", RoleListEditor); err != ErrForbidden {
+		t.Fatalf("expected ErrForbidden, got %v", err)
+	}
+	if p, err := a.Authorize("hashed-secret", RoleResetter); err != nil || p.Name != "ops" {
+		t.Fatalf("expected admin to hold every role, got %+v %v", p, err)
+	}
+	for _, token := range []string{"", "wrong"} {
+		if _, err := a.Authorize(token, RoleViewer); err != ErrUnauthenticated {
+			t.Fatalf("token %q: expected ErrUnauthenticated, got %v", token, err)
+		}
+	}
+	var none *Authenticator
+	if _, err := none.Authorize("", RoleAdmin); err != nil {
+		t.Fatalf("expected a nil authenticator to let everyone through, got %v", err)
+	}
+	if _, err := NewDisabled().Authorize("", RoleAdmin); err != nil {
+		t.Fatalf("expected a disabled authenticator to let everyone through, got %v", err)
+	}
+	keyless, err := New(nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	for _, token := range []string{"", "anything"} {
+		if _, err := keyless.Authorize(token, RoleViewer); err != ErrUnauthenticated {
+			t.Fatalf("token %q: expected no keys to refuse everyone, got %v", token, err)
+		}
+	}
+}
+
+func TestToken(t *testing.T) {
+	tests := []struct {
+		headers map[string]string
+		want    string
+	}{
+		{map[string]string{"Authorization": "Bearer abc"}, "abc"},
+		{map[string]string{"Authorization": "bearer abc"}, "abc"},
+		{map[string]string{"Authorization": "Basic abc"}, ""},
+		{map[string]string{"X-API-Key": "key"}, "key"},
+		{map[string]string{}, ""},
+	}
+	for _, tt := range tests {
+		if got := Token(func(k string) string { return tt.headers[k] }); got != tt.want {
+			t.Fatalf("headers %v: expected %q, got %q", tt.headers, tt.want, got)
+		}
+	}
+}
+
+func TestParseAndLoadKeys(t *testing.T) {
+	keys, err := ParseKeys("ci:viewer:abc, ops:list-editor+bucket-resetter:def")
+	if err != nil || len(keys) != 2 || len(keys[1].Roles) != 2 || keys[1].Key != "def" {
+		t.Fatalf("unexpected keys %+v, err %v", keys, err)
+	}
+	if _, err := ParseKeys("ci:viewer"); err == nil {
+		t.Fatal("expected an error for a key without a secret")
+	}
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte(`[{"name":"ci","roles":["viewer"],"key":"abc"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err = LoadKeysFile(path)
+	if err != nil || len(keys) != 1 || keys[0].Name != "ci" {
+		t.Fatalf("unexpected keys %+v, err %v", keys, err)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	a, err := New([]Key{{Name: "viewer", Roles: []Role{RoleViewer}, Key: "view-secret"}})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	var principal string
+	h := a.Require(RoleViewer, http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		p, _ := FromContext(r.Context())
+		principal = p.Name
+	}))
+	serve := func(h http.Handler, header, value string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := serve(h, "", ""); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without credentials, got %d", code)
+	}
+	if code := serve(h, "X-API-Key", "view-secret"); code != http.StatusOK || principal != "viewer" {
+		t.Fatalf("expected 200 as viewer, got %d as %q", code, principal)
+	}
+	editor := a.Require(RoleListEditor, http.NotFoundHandler())
+	if code := serve(editor, "Authorization", "Bearer view-secret"); code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a missing role, got %d", code)
+	}
+}
+
+func TestAuthorizePeer(t *testing.T) {
+	a, err := New(nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	verified := &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "ops"}}}},
+	}
+	if _, err := a.AuthorizePeer("", verified, RoleAdmin); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected a certificate alone not to do without RequireClientCert, got %v", err)
+	}
+	if p, err := NewDisabled().AuthorizePeer("", verified, RoleAdmin); err != nil || p.Name != "cert:ops" {
+		t.Fatalf("expected a disabled authenticator to name the caller after its certificate, got %+v %v", p, err)
+	}
+	a.RequireClientCert()
+	for _, state := range []*tls.ConnectionState{nil, {}} {
+		if _, err := a.AuthorizePeer("", state, RoleAdmin); !errors.Is(err, ErrUnauthenticated) {
+			t.Fatalf("expected ErrUnauthenticated without a verified certificate, got %v", err)
+		}
+	}
+	p, err := a.AuthorizePeer("", verified, RoleAdmin)
+	if err != nil || p.Name != "cert:ops" {
+		t.Fatalf("expected cert:ops, got %+v %v", p, err)
+	}
+}
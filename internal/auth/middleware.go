@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// Require lets a request through to next only when its credentials hold
+// role, and when RequireClientCert is set, a verified client certificate.
+// It answers 401 to missing or unknown credentials and 403 to ones
+// lacking the role.
+func (a *Authenticator) Require(role Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := a.AuthorizePeer(Token(r.Header.Get), r.TLS, role)
+		switch {
+		case errors.Is(err, ErrUnauthenticated):
+			w.Header().Set("WWW-Authenticate", `Bearer realm="antibruteforce"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case err != nil:
+			slog.WarnContext(r.Context(), "access denied", "principal", p.Name, "role", role, "path", r.URL.Path)
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+		}
+	})
+}
@@ -0,0 +1,196 @@
+package autoban
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/meladark/special-train/internal/storage"
+)
+
+// Config controls when repeat offenders get blacklisted. An IP is banned once
+// Threshold rate-limit denials fall within Window; the ban covers its /PrefixV4
+// or /PrefixV6 network and lasts BanTTL, or until removed if BanTTL is zero.
+// A zero Threshold disables banning.
+type Config struct {
+	Threshold int
+	Window    time.Duration
+	BanTTL    time.Duration
+	PrefixV4  int
+	PrefixV6  int
+}
+
+// Entry is a blacklist entry added by the engine. ExpiresAt is zero for
+// permanent bans.
+type Entry struct {
+	Network    string    `json:"network"`
+	Violations int       `json:"violations"`
+	AddedAt    time.Time `json:"added_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// record is what the engine tags its blacklist entries with. Keeping it in
+// the storage, next to the entry, lets every replica and restart tell the
+// automatic entries from manual ones.
+type record struct {
+	Violations int       `json:"violations"`
+	AddedAt    time.Time `json:"added_at"`
+}
+
+// Engine counts rate-limit denials per network and blacklists the networks
+// that cross the threshold. Bans are added with a TTL, the storage lifts them.
+// A nil Engine is valid and never bans.
+type Engine struct {
+	cfg        Config
+	store      storage.Storage
+	mu         sync.Mutex
+	violations map[string][]time.Time
+	now        func() time.Time
+	stop       chan struct{}
+	once       sync.Once
+}
+
+func New(cfg Config, store storage.Storage) *Engine {
+	if cfg.PrefixV4 <= 0 || cfg.PrefixV4 > 32 {
+		cfg.PrefixV4 = 32
+	}
+	if cfg.PrefixV6 <= 0 || cfg.PrefixV6 > 128 {
+		cfg.PrefixV6 = 128
+	}
+	return &Engine{
+		cfg:        cfg,
+		store:      store,
+		violations: make(map[string][]time.Time),
+		now:        time.Now,
+		stop:       make(chan struct{}),
+	}
+}
+
+// Enabled reports whether the engine bans anyone at all.
+func (e *Engine) Enabled() bool {
+	return e != nil && e.cfg.Threshold > 0
+}
+
+// banNet returns the network a ban on ip covers.
+func (e *Engine) banNet(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		mask := net.CIDRMask(e.cfg.PrefixV4, 32)
+		return &net.IPNet{IP: ip4.Mask(mask), Mask: mask}
+	}
+	mask := net.CIDRMask(e.cfg.PrefixV6, 128)
+	return &net.IPNet{IP: ip.To16().Mask(mask), Mask: mask}
+}
+
+// Observe records a rate-limit denial for ip and blacklists its network once
+// the threshold is reached. Manual entries inside the network are kept.
+func (e *Engine) Observe(ctx context.Context, ip net.IP) {
+	if !e.Enabled() {
+		return
+	}
+	network := e.banNet(ip)
+	key := network.String()
+	now := e.now()
+
+	// The lock guards the counters only, the storage is called without it.
+	e.mu.Lock()
+	hits := append(trim(e.violations[key], now.Add(-e.cfg.Window)), now)
+	if len(hits) < e.cfg.Threshold {
+		e.violations[key] = hits
+		e.mu.Unlock()
+		return
+	}
+	delete(e.violations, key)
+	e.mu.Unlock()
+	tag, err := json.Marshal(record{Violations: len(hits), AddedAt: now})
+	if err != nil {
+		slog.Error("autoban: encoding entry", "network", key, "err", err)
+		return
+	}
+	ok, err := e.store.AddTaggedToBlacklist(ctx, *network, false, e.cfg.BanTTL, string(tag))
+	if !ok {
+		slog.Warn("autoban: not blacklisting", "network", key, "err", err)
+		return
+	}
+	slog.Info("autoban: blacklisted", "network", key, "violations", len(hits), "ttl", e.cfg.BanTTL)
+}
+
+// trim drops the timestamps before since; hits are in ascending order.
+func trim(hits []time.Time, since time.Time) []time.Time {
+	i := sort.Search(len(hits), func(i int) bool { return hits[i].After(since) })
+	return hits[i:]
+}
+
+// Entries lists the blacklist entries added by an engine, by this one or
+// another instance sharing the storage, oldest first.
+func (e *Engine) Entries(ctx context.Context) ([]Entry, error) {
+	if e == nil {
+		return []Entry{}, nil
+	}
+	tags, err := e.store.BlacklistTags(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, expires, err := e.store.Expirations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(tags))
+	for key, tag := range tags {
+		var r record
+		if err := json.Unmarshal([]byte(tag), &r); err != nil {
+			slog.Warn("autoban: skipping malformed entry", "network", key, "err", err)
+			continue
+		}
+		entries = append(entries, Entry{
+			Network: key, Violations: r.Violations, AddedAt: r.AddedAt, ExpiresAt: expires[key],
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].AddedAt.Before(entries[j].AddedAt) })
+	return entries, nil
+}
+
+// Start runs the cleanup of stale violations until Close is called.
+func (e *Engine) Start() {
+	if !e.Enabled() {
+		return
+	}
+	interval := min(time.Minute, e.cfg.Window)
+	if interval <= 0 {
+		interval = time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-e.stop:
+				return
+			case <-ticker.C:
+				e.reap()
+			}
+		}
+	}()
+}
+
+// Close stops the cleanup. Bans in place stay in the blacklist.
+func (e *Engine) Close() {
+	e.once.Do(func() { close(e.stop) })
+}
+
+// reap forgets violations that fell out of the window.
+func (e *Engine) reap() {
+	now := e.now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for key, hits := range e.violations {
+		if hits = trim(hits, now.Add(-e.cfg.Window)); len(hits) == 0 {
+			delete(e.violations, key)
+		} else {
+			e.violations[key] = hits
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package autoban
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/meladark/special-train/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func newTestEngine(t *testing.T, cfg Config) (*Engine, storage.Storage, *time.Time) {
+	t.Helper()
+	store := storage.NewInMemoryStorage()
+	t.Cleanup(store.Close)
+	e := New(cfg, store)
+	now := epoch
+	e.now = func() time.Time { return now }
+	t.Cleanup(e.Close)
+	return e, store, &now
+}
+
+// listed reports the result of a list lookup that must not fail.
+func listed(t *testing.T, lookup func(context.Context, net.IP) (bool, error), ip net.IP) bool {
+	t.Helper()
+	found, err := lookup(t.Context(), ip)
+	if err != nil {
+		t.Fatalf("lookup %s: %v", ip, err)
+	}
+	return found
+}
+
+// banEntries returns the entries of e, which must be readable.
+func banEntries(t *testing.T, e *Engine) []Entry {
+	t.Helper()
+	entries, err := e.Entries(t.Context())
+	if err != nil {
+		t.Fatalf("Entries: %v", err)
+	}
+	return entries
+}
+
+func TestObserveBansAtThreshold(t *testing.T) {
+	e, store, _ := newTestEngine(t, Config{Threshold: 3, Window: time.Minute, BanTTL: time.Hour})
+	ip := net.ParseIP("192.0.2.10")
+	for i := 0; i < 2; i++ {
+		e.Observe(t.Context(), ip)
+	}
+	if listed(t, store.InBlacklist, ip) {
+		t.Fatal("expected no ban below threshold")
+	}
+	e.Observe(t.Context(), ip)
+	if !listed(t, store.InBlacklist, ip) {
+		t.Fatal("expected ban at threshold")
+	}
+	entries := banEntries(t, e)
+	if len(entries) != 1 || entries[0].Network != "192.0.2.10/32" || entries[0].Violations != 3 ||
+		!entries[0].AddedAt.Equal(epoch) || entries[0].ExpiresAt.IsZero() {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+}
+
+func TestObserveAggregatesNetwork(t *testing.T) {
+	e, store, _ := newTestEngine(t, Config{Threshold: 2, Window: time.Minute, BanTTL: time.Hour, PrefixV4: 24, PrefixV6: 64})
+	e.Observe(t.Context(), net.ParseIP("192.0.2.1"))
+	e.Observe(t.Context(), net.ParseIP("192.0.2.200"))
+	if !listed(t, store.InBlacklist, net.ParseIP("192.0.2.77")) {
+		t.Fatal("expected the whole /24 to be banned")
+	}
+	e.Observe(t.Context(), net.ParseIP("2001:db8::1"))
+	e.Observe(t.Context(), net.ParseIP("2001:db8::ffff"))
+	if !listed(t, store.InBlacklist, net.ParseIP("2001:db8::abcd")) {
+		t.Fatal("expected the whole /64 to be banned")
+	}
+	if listed(t, store.InBlacklist, net.ParseIP("2001:db8:0:1::1")) {
+		t.Fatal("expected the neighbouring /64 to be untouched")
+	}
+}
+
+func TestObserveWindow(t *testing.T) {
+	e, store, now := newTestEngine(t, Config{Threshold: 2, Window: time.Minute, BanTTL: time.Hour})
+	ip := net.ParseIP("192.0.2.20")
+	e.Observe(t.Context(), ip)
+	*now = now.Add(2 * time.Minute)
+	e.Observe(t.Context(), ip)
+	if listed(t, store.InBlacklist, ip) {
+		t.Fatal("expected violations outside the window to be ignored")
+	}
+	*now = now.Add(30 * time.Second)
+	e.Observe(t.Context(), ip)
+	if !listed(t, store.InBlacklist, ip) {
+		t.Fatal("expected ban for violations within the window")
+	}
+}
+
+func TestBanLifecycle(t *testing.T) {
+	e, store, _ := newTestEngine(t, Config{Threshold: 1, Window: time.Minute, BanTTL: time.Hour})
+	before := time.Now()
+	e.Observe(t.Context(), net.ParseIP("192.0.2.30"))
+	e.Observe(t.Context(), net.ParseIP("192.0.2.31"))
+	_, expires, err := store.Expirations(t.Context())
+	if err != nil {
+		t.Fatalf("Expirations: %v", err)
+	}
+	if at := expires["192.0.2.30/32"]; at.Before(before.Add(time.Hour)) || at.After(time.Now().Add(time.Hour)) {
+		t.Fatalf("expected the ban to be stored with its TTL, expires at %v", at)
+	}
+	if ok, err := store.RemoveFromBlacklist(t.Context(), *e.banNet(net.ParseIP("192.0.2.30"))); !ok {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if entries := banEntries(t, e); len(entries) != 1 || entries[0].Network != "192.0.2.31/32" {
+		t.Fatalf("expected only the remaining ban, got %+v", entries)
+	}
+	if ok, err := store.AddToBlacklist(t.Context(), *e.banNet(net.ParseIP("192.0.2.30")), false, 0); !ok {
+		t.Fatalf("add failed: %v", err)
+	}
+	if entries := banEntries(t, e); len(entries) != 1 {
+		t.Fatalf("expected a manual entry not to be reported as automatic, got %+v", entries)
+	}
+}
+
+func TestBanKeepsManualEntries(t *testing.T) {
+	e, store, _ := newTestEngine(t, Config{Threshold: 1, Window: time.Minute, BanTTL: 50 * time.Millisecond, PrefixV4: 24})
+	manual := net.ParseIP("192.0.2.7")
+	if ok, err := store.AddToBlacklist(t.Context(), net.IPNet{IP: manual, Mask: net.CIDRMask(32, 32)}, false, 0); !ok {
+		t.Fatalf("add failed: %v", err)
+	}
+	e.Observe(t.Context(), net.ParseIP("192.0.2.1"))
+	if !listed(t, store.InBlacklist, net.ParseIP("192.0.2.99")) {
+		t.Fatal("expected the /24 to be banned")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if listed(t, store.InBlacklist, net.ParseIP("192.0.2.99")) || !listed(t, store.InBlacklist, manual) {
+		t.Fatal("expected the manual entry to outlast the ban")
+	}
+
+	// A permanent ban outlives every entry, still it leaves the manual ones
+	// in place for when it is lifted.
+	permanent, store, _ := newTestEngine(t, Config{Threshold: 1, Window: time.Minute, PrefixV4: 24})
+	if ok, err := store.AddToBlacklist(t.Context(), mustNet(t, "198.51.100.0/28"), false, time.Hour); !ok {
+		t.Fatalf("add failed: %v", err)
+	}
+	permanent.Observe(t.Context(), net.ParseIP("198.51.100.200"))
+	if ok, err := store.RemoveFromBlacklist(t.Context(), mustNet(t, "198.51.100.0/24")); !ok {
+		t.Fatalf("remove failed: %v", err)
+	}
+	if !listed(t, store.InBlacklist, net.ParseIP("198.51.100.1")) {
+		t.Fatal("expected the manual entry back once the ban is lifted")
+	}
+}
+
+func TestObserveWithExpiredEntry(t *testing.T) {
+	e, store, _ := newTestEngine(t, Config{Threshold: 1, Window: time.Minute, BanTTL: time.Hour})
+	reaped := make(chan string, 1)
+	store.OnExpire(func(list string, ip net.IPNet) {
+		// Hooks may call back into the storage and the engine.
+		_, _ = e.Entries(context.Background())
+		reaped <- list + ":" + ip.String()
+	})
+	if ok, err := store.AddToBlacklist(t.Context(), mustNet(t, "203.0.113.0/24"), false, 10*time.Millisecond); !ok {
+		t.Fatalf("add failed: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		e.Observe(t.Context(), net.ParseIP("192.0.2.50"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Observe did not return with an expired entry pending")
+	}
+	if !listed(t, store.InBlacklist, net.ParseIP("192.0.2.50")) {
+		t.Fatal("expected the offender to be banned")
+	}
+	select {
+	case got := <-reaped:
+		if got != "blacklist:203.0.113.0/24" {
+			t.Fatalf("unexpected expiry event %q", got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected the expired entry to be reported by the reaper")
+	}
+}
+
+func TestEntriesSharedThroughRedis(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("miniredis.Run: %v", err)
+	}
+	t.Cleanup(mr.Close)
+	newStore := func() storage.Storage {
+		rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		store := storage.NewRedisStorage(rdb)
+		t.Cleanup(func() {
+			store.Close()
+			_ = rdb.Close()
+		})
+		return store
+	}
+	store := newStore()
+	e := New(Config{Threshold: 1, Window: time.Minute, BanTTL: time.Hour}, store)
+	e.Observe(t.Context(), net.ParseIP("192.0.2.40"))
+	if ok, err := store.AddToBlacklist(t.Context(), mustNet(t, "203.0.113.0/24"), false, 0); !ok {
+		t.Fatalf("add failed: %v", err)
+	}
+	// A replica, or this instance after a restart, sees the same split.
+	replica := New(Config{Threshold: 1, Window: time.Minute, BanTTL: time.Hour}, newStore())
+	entries := banEntries(t, replica)
+	if len(entries) != 1 || entries[0].Network != "192.0.2.40/32" || entries[0].Violations != 1 {
+		t.Fatalf("expected the automatic entry only, got %+v", entries)
+	}
+}
+
+func mustNet(t *testing.T, cidr string) net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *n
+}
+
+func TestObserveRespectsWhitelist(t *testing.T) {
+	e, store, _ := newTestEngine(t, Config{Threshold: 1, Window: time.Minute, BanTTL: time.Hour, PrefixV4: 24})
+	_, wl, _ := net.ParseCIDR("192.0.2.128/25")
+	if ok, err := store.AddToWhitelist(t.Context(), *wl, false, 0); !ok {
+		t.Fatalf("whitelist add failed: %v", err)
+	}
+	e.Observe(t.Context(), net.ParseIP("192.0.2.1"))
+	if listed(t, store.InBlacklist, net.ParseIP("192.0.2.1")) || len(banEntries(t, e)) != 0 {
+		t.Fatal("expected no ban overlapping the whitelist")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	var nilEngine *Engine
+	nilEngine.Observe(t.Context(), net.ParseIP("192.0.2.1"))
+	if len(banEntries(t, nilEngine)) != 0 {
+		t.Fatal("expected nil engine to do nothing")
+	}
+	e, store, _ := newTestEngine(t, Config{Window: time.Minute, BanTTL: time.Hour})
+	for i := 0; i < 10; i++ {
+		e.Observe(t.Context(), net.ParseIP("192.0.2.1"))
+	}
+	if listed(t, store.InBlacklist, net.ParseIP("192.0.2.1")) {
+		t.Fatal("expected zero threshold to disable banning")
+	}
+}
@@ -0,0 +1,76 @@
+package bucket
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	defer rdb.Close()
+	rl := NewRateLimiter(rdb, 5*time.Minute, Config{}, Config{}, Config{})
+	rl.SetCircuitBreaker(3, 10*time.Second)
+	now := epoch
+	rl.breaker.now = func() time.Time { return now }
+	cfg := Config{Capacity: 10, RefillPerMinute: 10}
+
+	if _, _, err := rl.Allow(ctx, "test:breaker", cfg, 1); err != nil {
+		t.Fatalf("Allow err: %v", err)
+	}
+	mr.Close()
+	for i := 0; i < 3; i++ {
+		_, _, err := rl.Allow(ctx, "test:breaker", cfg, 1)
+		if err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("expected redis error on attempt %d, got %v", i+1, err)
+		}
+	}
+	if _, _, err := rl.Allow(ctx, "test:breaker", cfg, 1); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected open circuit, got %v", err)
+	}
+	if err := rl.ResetAll(ctx, "*"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected open circuit for reset, got %v", err)
+	}
+
+	// After the cooldown a single probe goes through and fails again.
+	now = now.Add(11 * time.Second)
+	if _, _, err := rl.Allow(ctx, "test:breaker", cfg, 1); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected probe to reach redis, got %v", err)
+	}
+	if _, _, err := rl.Allow(ctx, "test:breaker", cfg, 1); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected circuit to reopen after failed probe, got %v", err)
+	}
+
+	if err := mr.Restart(); err != nil {
+		t.Fatalf("miniredis restart: %v", err)
+	}
+	now = now.Add(11 * time.Second)
+	if ok, _, err := rl.Allow(ctx, "test:breaker", cfg, 1); err != nil || !ok {
+		t.Fatalf("expected probe to close the circuit, got ok=%v err=%v", ok, err)
+	}
+	if _, _, err := rl.Allow(ctx, "test:breaker", cfg, 1); err != nil {
+		t.Fatalf("expected closed circuit, got %v", err)
+	}
+}
+
+func TestCircuitBreakerIgnoresReplyErrors(t *testing.T) {
+	b := newBreaker(1, time.Minute)
+	b.record(redis.Nil)
+	b.record(context.Canceled)
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected closed circuit, got %v", err)
+	}
+	b.record(errors.New("dial tcp: connection refused"))
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected open circuit, got %v", err)
+	}
+	if newBreaker(0, time.Minute) != nil {
+		t.Fatal("expected zero threshold to disable the breaker")
+	}
+}
@@ -2,29 +2,256 @@ package bucket
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
-	"errors"
 	"fmt"
 	"math"
+	"net"
 	"strconv"
+	"sync/atomic"
 	"time"
 
+	"github.com/meladark/special-train/internal/metrics"
 	"github.com/redis/go-redis/v9"
 )
 
+// Config describes one rate-limited dimension. Capacity is the burst size
+// (or the number of requests per Window for window algorithms) and
+// RefillPerMinute the sustained rate for token bucket and GCRA. An empty
+// Algorithm means TokenBucket and a zero Window means one minute.
 type Config struct {
 	Capacity        int
 	RefillPerMinute int
+	Algorithm       Algorithm
+	Window          time.Duration
+}
+
+// ConsumeMode decides what happens to the tokens of buckets that allow a
+// request when another bucket of the same check denies it.
+type ConsumeMode string
+
+const (
+	// ConsumeEach takes a token from every bucket that allows the request,
+	// even if the request is denied overall.
+	ConsumeEach ConsumeMode = "each"
+	// ConsumeAllOrNothing takes tokens only when every bucket allows.
+	ConsumeAllOrNothing ConsumeMode = "all"
+	// consumeNone takes no tokens at all, it backs Peek.
+	consumeNone ConsumeMode = "none"
+)
+
+func ParseConsumeMode(s string) (ConsumeMode, error) {
+	switch mode := ConsumeMode(s); mode {
+	case ConsumeEach, ConsumeAllOrNothing:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown consume mode %q, expected %q or %q", s, ConsumeEach, ConsumeAllOrNothing)
+	}
+}
+
+// Backend is what the service needs from a rate limiter. RateLimiter keeps
+// buckets in Redis, MemoryRateLimiter keeps them in process.
+type Backend interface {
+	Allow(ctx context.Context, key string, cfg Config, requested int) (bool, float64, error)
+	CheckAll(ctx context.Context, login, password, ip string) (bool, map[string]bool, error)
+	// Peek reports what CheckAll would decide without taking any tokens.
+	Peek(ctx context.Context, login, password, ip string) (bool, map[string]bool, error)
+	// Refund gives back the tokens CheckAll took for an attempt.
+	Refund(ctx context.Context, login, password, ip string) error
+	// Penalize takes cost more tokens from every bucket of an attempt, or
+	// whatever is left of them.
+	Penalize(ctx context.Context, login, password, ip string, cost int) error
+	ResetIP(ctx context.Context, ip string) error
+	ResetLogin(ctx context.Context, login string) error
+	ResetAll(ctx context.Context, reset string) error
+}
+
+// limits are the settings of CheckAll that can change while it runs.
+type limits struct {
+	consumeMode ConsumeMode
+	loginCfg    Config
+	passCfg     Config
+	ipCfg       Config
+	subnets     []Subnet
+}
+
+// Overrides picks the config of a login or IP bucket ahead of the defaults,
+// for logins and networks that need limits of their own.
+type Overrides interface {
+	Login(login string) (Config, bool)
+	IP(ip string) (Config, bool)
+}
+
+// dimensions holds what every Backend needs to build the login, password
+// and IP checks of CheckAll. The limits are swapped as a whole, so a check
+// never mixes old and new settings.
+type dimensions struct {
+	limits    atomic.Pointer[limits]
+	passwords *PasswordHasher
+	overrides Overrides
+}
+
+func (d *dimensions) init(loginCfg, passCfg, ipCfg Config) {
+	d.limits.Store(&limits{consumeMode: ConsumeEach, loginCfg: loginCfg, passCfg: passCfg, ipCfg: ipCfg})
+}
+
+// SetConsumeMode changes how CheckAll treats tokens on partial denials. It
+// retries when SetLimits swaps the limits meanwhile, so neither change is lost.
+func (d *dimensions) SetConsumeMode(mode ConsumeMode) {
+	for {
+		old := d.limits.Load()
+		l := *old
+		l.consumeMode = mode
+		if d.limits.CompareAndSwap(old, &l) {
+			return
+		}
+	}
+}
+
+// SetLimits replaces the bucket configs, the subnets and the consume mode at
+// once, for the checks that start afterwards. Invalid configs are rejected
+// and the previous ones kept. Buckets keep their state, so a bucket that
+// shrinks may still hold more tokens than its new capacity until it is used.
+func (d *dimensions) SetLimits(loginCfg, passCfg, ipCfg Config, subnets []Subnet, mode ConsumeMode) error {
+	for name, cfg := range map[string]Config{"login": loginCfg, "pass": passCfg, "ip": ipCfg} {
+		if err := cfg.Validate(); err != nil {
+			return fmt.Errorf("%s bucket: %w", name, err)
+		}
+	}
+	for _, sn := range subnets {
+		if err := sn.Validate(); err != nil {
+			return fmt.Errorf("subnet bucket: %w", err)
+		}
+	}
+	if _, err := ParseConsumeMode(string(mode)); err != nil {
+		return err
+	}
+	d.limits.Store(&limits{consumeMode: mode, loginCfg: loginCfg, passCfg: passCfg, ipCfg: ipCfg, subnets: subnets})
+	return nil
+}
+
+// SetPasswordHasher makes password buckets keyed by h instead of an unkeyed
+// SHA-256 of the password.
+func (d *dimensions) SetPasswordHasher(h *PasswordHasher) {
+	d.passwords = h
+}
+
+// SetOverrides makes the login and IP buckets use the configs o has for
+// them instead of the defaults. Buckets keep their state when an override
+// comes or goes, as they do when SetLimits changes the defaults.
+func (d *dimensions) SetOverrides(o Overrides) {
+	d.overrides = o
+}
+
+// checks returns the login, password and IP checks, then one check per
+// subnet of the IP's family and one more password check per previous key of
+// a rotation. Each check names the verdict key it counts towards. An IP
+// under a network override is limited by the override alone, the subnet
+// buckets leave it out.
+func (d *dimensions) checks(l *limits, login, password, ip string) []bucketCheck {
+	loginCfg, ipCfg := l.loginCfg, l.ipCfg
+	subnets := l.subnets
+	if d.overrides != nil {
+		if cfg, ok := d.overrides.Login(login); ok {
+			loginCfg = cfg
+		}
+		if cfg, ok := d.overrides.IP(ip); ok {
+			ipCfg, subnets = cfg, nil
+		}
+	}
+	hashes := d.passwords.hashes(password)
+	checks := []bucketCheck{
+		{dim: "login", key: "bf:login:" + login, cfg: loginCfg, requested: 1},
+		{dim: "pass", key: "bf:pass:" + hashes[0], cfg: l.passCfg, requested: 1},
+		{dim: "ip", key: "bf:ip:" + ip, cfg: ipCfg, requested: 1},
+	}
+	if addr := net.ParseIP(ip); addr != nil {
+		for _, sn := range subnets {
+			if n := sn.network(addr); n != nil {
+				checks = append(checks, bucketCheck{dim: SubnetPrefix + sn.Name(), key: "bf:net:" + n.String(), cfg: sn.Config, requested: 1})
+			}
+		}
+	}
+	for _, h := range hashes[1:] {
+		checks = append(checks, bucketCheck{dim: "pass", key: "bf:pass:" + h, cfg: l.passCfg, requested: 1})
+	}
+	return checks
+}
+
+// verdict turns the results of checks into CheckAll's return values. A key
+// is allowed only when every bucket counting towards it allows, so the
+// password needs the buckets of every hash key.
+func verdict(checks []bucketCheck, results []bucketResult) (bool, map[string]bool) {
+	res := make(map[string]bool, len(checks))
+	for i, c := range checks {
+		allowed, seen := res[c.dim]
+		res[c.dim] = results[i].allowed && (allowed || !seen)
+	}
+	ok := true
+	for _, allowed := range res {
+		ok = ok && allowed
+	}
+	return ok, res
+}
+
+// ipResets returns the ResetAll patterns of the buckets an attempt from ip
+// is charged to, its own and those of its subnets.
+func (d *dimensions) ipResets(ip string) []string {
+	patterns := []string{"ip:" + ip}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return patterns
+	}
+	for _, sn := range d.limits.Load().subnets {
+		if n := sn.network(addr); n != nil {
+			patterns = append(patterns, "net:"+n.String())
+		}
+	}
+	return patterns
+}
+
+// takeFunc is the take method of a Backend.
+type takeFunc func(ctx context.Context, mode ConsumeMode, checks ...bucketCheck) ([]bucketResult, error)
+
+func refund(ctx context.Context, take takeFunc, checks []bucketCheck) error {
+	for i := range checks {
+		checks[i].requested = -checks[i].requested
+	}
+	_, err := take(ctx, ConsumeEach, checks...)
+	return err
+}
+
+// penalize takes cost tokens from every bucket in one pass and drains the
+// buckets that had fewer left in a second one, as a bucket only hands out
+// all the requested tokens or none.
+func penalize(ctx context.Context, take takeFunc, checks []bucketCheck, cost int) error {
+	if cost <= 0 {
+		return nil
+	}
+	for i := range checks {
+		checks[i].requested = cost
+	}
+	results, err := take(ctx, ConsumeEach, checks...)
+	if err != nil {
+		return err
+	}
+	var rest []bucketCheck
+	for i, r := range results {
+		if left := int(math.Floor(r.remaining)); !r.allowed && left > 0 {
+			rest = append(rest, bucketCheck{key: checks[i].key, cfg: checks[i].cfg, requested: left})
+		}
+	}
+	if len(rest) == 0 {
+		return nil
+	}
+	_, err = take(ctx, ConsumeEach, rest...)
+	return err
 }
 
 type RateLimiter struct {
-	rdb        *redis.Client
-	keyTTL     time.Duration
-	maxRetries int
-	loginCfg   Config
-	passCfg    Config
-	ipCfg      Config
+	dimensions
+	rdb     *redis.Client
+	script  *redis.Script
+	keyTTL  time.Duration
+	breaker *breaker
 }
 
 func NewRateLimiter(rdb *redis.Client,
@@ -33,123 +260,134 @@ func NewRateLimiter(rdb *redis.Client,
 	passCfg Config,
 	ipCfg Config,
 ) *RateLimiter {
-	return &RateLimiter{
-		rdb:        rdb,
-		keyTTL:     keyTTL,
-		maxRetries: 5,
-		loginCfg:   loginCfg,
-		passCfg:    passCfg,
-		ipCfg:      ipCfg,
+	rl := &RateLimiter{
+		rdb:     rdb,
+		script:  newScript(),
+		keyTTL:  keyTTL,
+		breaker: newBreaker(5, 10*time.Second),
 	}
+	rl.init(loginCfg, passCfg, ipCfg)
+	return rl
+}
+
+// SetCircuitBreaker makes calls fail fast with ErrCircuitOpen for cooldown
+// after threshold consecutive Redis failures. A threshold of zero disables
+// the breaker.
+func (rl *RateLimiter) SetCircuitBreaker(threshold int, cooldown time.Duration) {
+	rl.breaker = newBreaker(threshold, cooldown)
 }
 
-func hashPassword(pw string) string {
-	h := sha256.Sum256([]byte(pw))
-	return hex.EncodeToString(h[:])
+type bucketCheck struct {
+	dim       string
+	key       string
+	cfg       Config
+	requested int
 }
 
-func (rl *RateLimiter) Allow(ctx context.Context, //nolint: gocognit
+type bucketResult struct {
+	allowed   bool
+	remaining float64
+}
+
+// take runs every check in a single script call, so the whole batch costs
+// one round trip and is evaluated atomically. The script is run with EVALSHA
+// and is only sent in full when Redis answers NOSCRIPT.
+func (rl *RateLimiter) take(ctx context.Context, mode ConsumeMode, checks ...bucketCheck) (results []bucketResult, err error) {
+	ctx, span := startTake(ctx, "redis", mode, checks)
+	defer func() { endTake(span, checks, results, err) }()
+	keys := make([]string, 0, len(checks))
+	args := make([]any, 0, 1+5*len(checks))
+	args = append(args, string(mode))
+	for _, c := range checks {
+		l, ok := lookupLimiter(c.cfg.algorithm())
+		if !ok {
+			return nil, fmt.Errorf("rate limiter: unknown algorithm %q", c.cfg.Algorithm)
+		}
+		a, b := l.Params(c.cfg)
+		// Window state has to outlive the window it describes.
+		ttl := max(rl.keyTTL, 2*c.cfg.window())
+		keys = append(keys, c.key)
+		args = append(args, string(l.Algorithm()), c.requested, a, b, ttl.Milliseconds())
+	}
+	if err := rl.breaker.allow(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	res, err := rl.script.Run(ctx, rl.rdb, keys, args...).Slice()
+	metrics.ObserveRedis("check", start, err)
+	rl.breaker.record(err)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) != 2*len(checks) {
+		return nil, fmt.Errorf("rate limiter: unexpected script reply %v", res)
+	}
+	results = make([]bucketResult, len(checks))
+	for i := range results {
+		allowed, _ := res[2*i].(int64)
+		remainingStr, _ := res[2*i+1].(string)
+		remaining, err := strconv.ParseFloat(remainingStr, 64)
+		if err != nil {
+			return nil, fmt.Errorf("rate limiter: bad remaining tokens %q: %w", remainingStr, err)
+		}
+		results[i] = bucketResult{allowed: allowed == 1, remaining: remaining}
+	}
+	return results, nil
+}
+
+// Allow takes requested tokens from the bucket stored at key.
+func (rl *RateLimiter) Allow(ctx context.Context,
 	key string,
 	cfg Config,
 	requested int,
 ) (bool, float64, error) {
-	refillPerSec := float64(cfg.RefillPerMinute) / 60.0
-	attempts := 0
-	for attempts < rl.maxRetries {
-		attempts++
-		var allowed bool
-		var remaining float64
-		err := rl.rdb.Watch(ctx, func(tx *redis.Tx) error {
-			tokensStr, err := tx.HGet(ctx, key, "tokens").Result()
-			if err != nil && !errors.Is(err, redis.Nil) {
-				return err
-			}
-			tsStr, err2 := tx.HGet(ctx, key, "ts").Result()
-			if err2 != nil && !errors.Is(err2, redis.Nil) {
-				return err2
-			}
-			now := float64(time.Now().UnixNano()) / 1e9
-			var tokens float64
-			if errors.Is(err, redis.Nil) {
-				tokens = float64(cfg.Capacity)
-			} else {
-				tokens, err = strconv.ParseFloat(tokensStr, 64)
-				if err != nil {
-					tokens = float64(cfg.Capacity)
-				}
-			}
-			var lastTs float64
-			if errors.Is(err2, redis.Nil) {
-				lastTs = now
-			} else {
-				lastTs, err2 = strconv.ParseFloat(tsStr, 64)
-				if err2 != nil {
-					lastTs = now
-				}
-			}
-			delta := now - lastTs
-			if delta < 0 {
-				delta = 0
-			}
-			newTokens := math.Min(float64(cfg.Capacity), tokens+delta*refillPerSec)
-			if newTokens >= float64(requested) {
-				newTokens -= float64(requested)
-				allowed = true
-			} else {
-				allowed = false
-			}
-			remaining = newTokens
-			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
-				pipe.HSet(ctx, key, "tokens", strconv.FormatFloat(newTokens, 'f', 6, 64))
-				pipe.HSet(ctx, key, "ts", strconv.FormatFloat(now, 'f', 6, 64))
-				pipe.Expire(ctx, key, rl.keyTTL)
-				return nil
-			})
-			return err
-		}, key)
-		if err == nil {
-			return allowed, remaining, nil
-		}
-		if errors.Is(err, redis.TxFailedErr) {
-			continue
-		}
+	res, err := rl.take(ctx, ConsumeEach, bucketCheck{key: key, cfg: cfg, requested: requested})
+	if err != nil {
 		return false, 0, err
 	}
-	return false, 0, fmt.Errorf("rate limiter: max retries reached")
+	return res[0].allowed, res[0].remaining, nil
 }
 
+// CheckAll checks the login, password and IP buckets in one round trip. The
+// returned map holds each bucket's own verdict; whether tokens are taken when
+// another bucket denies depends on the consume mode.
 func (rl *RateLimiter) CheckAll(ctx context.Context, login, password, ip string) (bool, map[string]bool, error) {
-	passHash := hashPassword(password)
-
-	loginKey := "bf:login:" + login
-	passKey := "bf:pass:" + passHash
-	ipKey := "bf:ip:" + ip
-
-	res := map[string]bool{}
-
-	okLogin, _, err := rl.Allow(ctx, loginKey, rl.loginCfg, 1)
+	l := rl.limits.Load()
+	checks := rl.checks(l, login, password, ip)
+	results, err := rl.take(ctx, l.consumeMode, checks...)
 	if err != nil {
 		return false, nil, err
 	}
-	res["login"] = okLogin
+	ok, res := verdict(checks, results)
+	return ok, res, nil
+}
 
-	okPass, _, err := rl.Allow(ctx, passKey, rl.passCfg, 1)
+func (rl *RateLimiter) Peek(ctx context.Context, login, password, ip string) (bool, map[string]bool, error) {
+	checks := rl.checks(rl.limits.Load(), login, password, ip)
+	results, err := rl.take(ctx, consumeNone, checks...)
 	if err != nil {
 		return false, nil, err
 	}
-	res["pass"] = okPass
+	ok, res := verdict(checks, results)
+	return ok, res, nil
+}
 
-	okIP, _, err := rl.Allow(ctx, ipKey, rl.ipCfg, 1)
-	if err != nil {
-		return false, nil, err
-	}
-	res["ip"] = okIP
+func (rl *RateLimiter) Refund(ctx context.Context, login, password, ip string) error {
+	return refund(ctx, rl.take, rl.checks(rl.limits.Load(), login, password, ip))
+}
 
-	return okLogin && okPass && okIP, res, nil
+func (rl *RateLimiter) Penalize(ctx context.Context, login, password, ip string, cost int) error {
+	return penalize(ctx, rl.take, rl.checks(rl.limits.Load(), login, password, ip), cost)
 }
 
+// ResetIP drops the bucket of ip and those of its subnets.
 func (rl *RateLimiter) ResetIP(ctx context.Context, ip string) error {
-	return rl.ResetAll(ctx, "ip:"+ip)
+	for _, pattern := range rl.ipResets(ip) {
+		if err := rl.ResetAll(ctx, pattern); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (rl *RateLimiter) ResetLogin(ctx context.Context, login string) error {
@@ -157,6 +395,17 @@ func (rl *RateLimiter) ResetLogin(ctx context.Context, login string) error {
 }
 
 func (rl *RateLimiter) ResetAll(ctx context.Context, reset string) error {
+	if err := rl.breaker.allow(); err != nil {
+		return err
+	}
+	start := time.Now()
+	err := rl.resetAll(ctx, reset)
+	metrics.ObserveRedis("reset", start, err)
+	rl.breaker.record(err)
+	return err
+}
+
+func (rl *RateLimiter) resetAll(ctx context.Context, reset string) error {
 	iter := rl.rdb.Scan(ctx, 0, "bf:"+reset, 100).Iterator()
 	for iter.Next(ctx) {
 		if err := rl.rdb.Del(ctx, iter.Val()).Err(); err != nil {
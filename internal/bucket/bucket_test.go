@@ -2,6 +2,9 @@ package bucket
 
 import (
 	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -186,3 +189,219 @@ func TestTokenBucketBurstAndRefill(t *testing.T) {
 		t.Fatalf("expected second post-refill request to be blocked, only 1 token refilled")
 	}
 }
+
+func TestAllowUnderContention(t *testing.T) {
+	ctx := context.Background()
+	rl, cleanup := newTestRL(t)
+	defer cleanup()
+
+	key := "test:contention"
+	cfg := Config{Capacity: 20, RefillPerMinute: 0}
+	var wg sync.WaitGroup
+	var allowed, failed atomic.Int32
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, _, err := rl.Allow(ctx, key, cfg, 1)
+			if err != nil {
+				failed.Add(1)
+				return
+			}
+			if ok {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if failed.Load() != 0 {
+		t.Fatalf("expected no errors under contention, got %d", failed.Load())
+	}
+	if allowed.Load() != 20 {
+		t.Fatalf("expected exactly 20 allowed, got %d", allowed.Load())
+	}
+}
+
+func TestAllowReloadsFlushedScript(t *testing.T) {
+	ctx := context.Background()
+	rl, cleanup := newTestRL(t)
+	defer cleanup()
+
+	cfg := Config{Capacity: 2, RefillPerMinute: 2}
+	if ok, _, err := rl.Allow(ctx, "test:noscript", cfg, 1); err != nil || !ok {
+		t.Fatalf("expected allowed, got ok=%v err=%v", ok, err)
+	}
+	if err := rl.rdb.ScriptFlush(ctx).Err(); err != nil {
+		t.Fatalf("SCRIPT FLUSH: %v", err)
+	}
+	ok, rem, err := rl.Allow(ctx, "test:noscript", cfg, 1)
+	if err != nil {
+		t.Fatalf("expected NOSCRIPT fallback, got err: %v", err)
+	}
+	if !ok || rem >= 1 {
+		t.Fatalf("expected second token taken, got ok=%v remaining=%v", ok, rem)
+	}
+}
+
+func TestAllowUsesServerClock(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	rl := NewRateLimiter(rdb, 5*time.Minute, Config{}, Config{}, Config{})
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	mr.SetTime(start)
+	cfg := Config{Capacity: 3, RefillPerMinute: 6}
+	for i := 0; i < 3; i++ {
+		if ok, _, err := rl.Allow(ctx, "test:clock", cfg, 1); err != nil || !ok {
+			t.Fatalf("expected allowed on attempt %d, got ok=%v err=%v", i+1, ok, err)
+		}
+	}
+	if ok, _, _ := rl.Allow(ctx, "test:clock", cfg, 1); ok {
+		t.Fatalf("expected blocked after drain")
+	}
+	mr.SetTime(start.Add(10 * time.Second))
+	ok, rem, err := rl.Allow(ctx, "test:clock", cfg, 1)
+	if err != nil || !ok {
+		t.Fatalf("expected one token refilled after 10s, got ok=%v err=%v", ok, err)
+	}
+	if rem > 0.001 {
+		t.Fatalf("expected bucket empty again, remaining=%v", rem)
+	}
+}
+
+func TestCheckAllConsumeModes(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		mode          ConsumeMode
+		expectedLogin float64
+	}{
+		{ConsumeEach, 1},
+		{ConsumeAllOrNothing, 2},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			defer rdb.Close()
+			rl := NewRateLimiter(rdb, 5*time.Minute,
+				Config{Capacity: 3, RefillPerMinute: 0},
+				Config{Capacity: 3, RefillPerMinute: 0},
+				Config{Capacity: 1, RefillPerMinute: 0})
+			rl.SetConsumeMode(tt.mode)
+
+			if ok, _, err := rl.CheckAll(ctx, "bob", "pw", "192.0.2.1"); err != nil || !ok {
+				t.Fatalf("expected first check allowed, got ok=%v err=%v", ok, err)
+			}
+			ok, details, err := rl.CheckAll(ctx, "bob", "pw", "192.0.2.1")
+			if err != nil {
+				t.Fatalf("CheckAll err: %v", err)
+			}
+			if ok || details["ip"] || !details["login"] || !details["pass"] {
+				t.Fatalf("expected only ip bucket to deny, got ok=%v details=%v", ok, details)
+			}
+			tokens, err := strconv.ParseFloat(mr.HGet("bf:login:bob", "tokens"), 64)
+			if err != nil {
+				t.Fatalf("failed to read login tokens: %v", err)
+			}
+			if tokens != tt.expectedLogin {
+				t.Fatalf("expected %v login tokens left, got %v", tt.expectedLogin, tokens)
+			}
+		})
+	}
+}
+
+func TestCheckAllSingleRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	rl := NewRateLimiter(rdb, 5*time.Minute,
+		Config{Capacity: 10, RefillPerMinute: 10},
+		Config{Capacity: 10, RefillPerMinute: 10},
+		Config{Capacity: 10, RefillPerMinute: 10})
+	if _, _, err := rl.CheckAll(ctx, "bob", "pw", "192.0.2.1"); err != nil {
+		t.Fatalf("CheckAll err: %v", err)
+	}
+	hook := &countingHook{}
+	rdb.AddHook(hook)
+	if _, _, err := rl.CheckAll(ctx, "bob", "pw", "192.0.2.1"); err != nil {
+		t.Fatalf("CheckAll err: %v", err)
+	}
+	if n := hook.commands.Load(); n != 1 {
+		t.Fatalf("expected a single EVALSHA per CheckAll, got %d commands", n)
+	}
+}
+
+type countingHook struct {
+	commands atomic.Int32
+}
+
+func (h *countingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *countingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		h.commands.Add(1)
+		return next(ctx, cmd)
+	}
+}
+
+func (h *countingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		h.commands.Add(int32(len(cmds))) //nolint: gosec
+		return next(ctx, cmds)
+	}
+}
+
+func TestPasswordHasherRotation(t *testing.T) {
+	ctx := context.Background()
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("miniredis.Run: %v", err)
+	}
+	defer mr.Close()
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	rl := NewRateLimiter(rdb, 5*time.Minute,
+		Config{Capacity: 100, RefillPerMinute: 0},
+		Config{Capacity: 2, RefillPerMinute: 0},
+		Config{Capacity: 100, RefillPerMinute: 0})
+
+	if _, err := NewPasswordHasher("short", nil, time.Time{}); err == nil {
+		t.Fatal("expected an error for a short key")
+	}
+	oldKey, newKey := "old-secret-0123456789", "new-secret-0123456789"
+	old, _ := NewPasswordHasher(oldKey, nil, time.Time{})
+	rl.SetPasswordHasher(old)
+	for range 2 {
+		if ok, _, err := rl.CheckAll(ctx, "alice", "hunter2", "192.0.2.1"); err != nil || !ok {
+			t.Fatalf("expected the first attempts allowed, got %v %v", ok, err)
+		}
+	}
+	if mr.Exists("bf:pass:"+(*PasswordHasher)(nil).hashes("hunter2")[0]) || !mr.Exists("bf:pass:"+keyedHash([]byte(oldKey), "hunter2")) {
+		t.Fatalf("expected the password bucket named by the HMAC only, got keys %v", mr.Keys())
+	}
+
+	until := time.Now().Add(time.Hour)
+	rotated, _ := NewPasswordHasher(newKey, []string{oldKey}, until)
+	rl.SetPasswordHasher(rotated)
+	ok, details, err := rl.CheckAll(ctx, "bob", "hunter2", "192.0.2.2")
+	if err != nil || ok || details["pass"] {
+		t.Fatalf("expected the drained bucket of the old key to deny during the grace period, got %v %v %v", ok, details, err)
+	}
+	rotated.now = func() time.Time { return until }
+	if ok, _, err := rl.CheckAll(ctx, "carol", "hunter2", "192.0.2.3"); err != nil || !ok {
+		t.Fatalf("expected the old key ignored after the grace period, got %v %v", ok, err)
+	}
+}
+
+func TestRandomPasswordHasher(t *testing.T) {
+	a, b := NewRandomPasswordHasher(), NewRandomPasswordHasher()
+	unkeyed := (*PasswordHasher)(nil).hashes("hunter2")[0]
+	if ha := a.hashes("hunter2"); len(ha) != 1 || ha[0] == unkeyed || ha[0] == b.hashes("hunter2")[0] {
+		t.Fatalf("expected a keyed hash unique to the hasher, got %v", ha)
+	}
+}
@@ -0,0 +1,356 @@
+package bucket
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type Algorithm string
+
+const (
+	TokenBucket   Algorithm = "token_bucket"
+	SlidingLog    Algorithm = "sliding_log"
+	SlidingWindow Algorithm = "sliding_window"
+	FixedWindow   Algorithm = "fixed_window"
+	GCRA          Algorithm = "gcra"
+)
+
+// Limiter is a rate-limiting algorithm evaluated inside Redis. Every
+// registered limiter contributes one Lua function to the script RateLimiter
+// runs, so buckets using different algorithms are still checked atomically
+// in a single round trip.
+type Limiter interface {
+	Algorithm() Algorithm
+	// Script returns a Lua function
+	//
+	//	function(key, now, requested, a, b, ttl_ms, commit) -> allowed, remaining
+	//
+	// where now is the server time in seconds and a, b come from Params. The
+	// function must only consume when commit is true; CheckAll first asks
+	// every bucket without committing and then commits the ones to charge.
+	// A negative requested gives tokens back, never beyond the full limit.
+	Script() string
+	// Params maps cfg to the a and b arguments of the Lua function.
+	Params(cfg Config) (a, b float64)
+	// Validate reports whether cfg can be used with the algorithm.
+	Validate(cfg Config) error
+}
+
+var (
+	limitersMu sync.RWMutex
+	limiters   = map[Algorithm]Limiter{}
+)
+
+// Register makes a limiter available to rate limiters created afterwards.
+func Register(l Limiter) {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+	limiters[l.Algorithm()] = l
+}
+
+func lookupLimiter(a Algorithm) (Limiter, bool) {
+	limitersMu.RLock()
+	defer limitersMu.RUnlock()
+	l, ok := limiters[a]
+	return l, ok
+}
+
+func init() {
+	Register(tokenBucket{})
+	Register(fixedWindow{})
+	Register(slidingLog{})
+	Register(slidingWindow{})
+	Register(gcra{})
+}
+
+func (c Config) algorithm() Algorithm {
+	if c.Algorithm == "" {
+		return TokenBucket
+	}
+	return c.Algorithm
+}
+
+func (c Config) window() time.Duration {
+	if c.Window <= 0 {
+		return time.Minute
+	}
+	return c.Window
+}
+
+// Validate checks cfg against the algorithm it selects.
+func (c Config) Validate() error {
+	l, ok := lookupLimiter(c.algorithm())
+	if !ok {
+		return fmt.Errorf("unknown algorithm %q", c.Algorithm)
+	}
+	if c.Capacity <= 0 {
+		return fmt.Errorf("%s: capacity must be positive, got %d", c.algorithm(), c.Capacity)
+	}
+	if c.RefillPerMinute < 0 {
+		return fmt.Errorf("%s: refill per minute must not be negative, got %d", c.algorithm(), c.RefillPerMinute)
+	}
+	if c.Window < 0 {
+		return fmt.Errorf("%s: window must not be negative, got %s", c.algorithm(), c.Window)
+	}
+	return l.Validate(c)
+}
+
+// scriptPrelude is shared by all limiter functions. ensure_type drops state
+// left by an algorithm that stores a different Redis type under the same key.
+const scriptPrelude = `
+local function fmt(n)
+	return string.format("%.6f", n)
+end
+
+local function ensure_type(key, kind)
+	local current = redis.call("TYPE", key)["ok"]
+	if current ~= "none" and current ~= kind then
+		redis.call("DEL", key)
+	end
+end
+
+local algorithms = {}
+`
+
+// scriptMain expects ARGV to hold the consume mode followed by algorithm,
+// requested, a, b and ttl_ms for each key. Numbers are returned as strings
+// because Redis truncates Lua numbers to integers.
+const scriptMain = `
+local all_or_nothing = ARGV[1] == "all"
+local commit = ARGV[1] ~= "none"
+
+local t = redis.call("TIME")
+local now = tonumber(t[1]) + tonumber(t[2]) / 1e6
+
+local checks, allowed, remaining = {}, {}, {}
+local all = true
+for i, key in ipairs(KEYS) do
+	local base = 1 + (i - 1) * 5
+	local c = {
+		fn = algorithms[ARGV[base + 1]],
+		requested = tonumber(ARGV[base + 2]),
+		a = tonumber(ARGV[base + 3]),
+		b = tonumber(ARGV[base + 4]),
+		ttl = tonumber(ARGV[base + 5]),
+	}
+	if c.fn == nil then
+		return redis.error_reply("unknown algorithm " .. ARGV[base + 1])
+	end
+	checks[i] = c
+	allowed[i], remaining[i] = c.fn(key, now, c.requested, c.a, c.b, c.ttl, false)
+	all = all and allowed[i]
+end
+
+local reply = {}
+for i, key in ipairs(KEYS) do
+	if commit and allowed[i] and (all or not all_or_nothing) then
+		local c = checks[i]
+		allowed[i], remaining[i] = c.fn(key, now, c.requested, c.a, c.b, c.ttl, true)
+	end
+	reply[2 * i - 1] = allowed[i] and 1 or 0
+	reply[2 * i] = fmt(remaining[i])
+end
+return reply
+`
+
+// newScript assembles the dispatcher script from every registered limiter.
+func newScript() *redis.Script {
+	limitersMu.RLock()
+	names := make([]string, 0, len(limiters))
+	for name := range limiters {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString(scriptPrelude)
+	for _, name := range names {
+		fmt.Fprintf(&b, "algorithms[%q] = %s\n", name, limiters[Algorithm(name)].Script())
+	}
+	limitersMu.RUnlock()
+	b.WriteString(scriptMain)
+	return redis.NewScript(b.String())
+}
+
+// tokenBucket refills Capacity tokens at RefillPerMinute.
+type tokenBucket struct{}
+
+func (tokenBucket) Algorithm() Algorithm { return TokenBucket }
+
+func (tokenBucket) Params(cfg Config) (float64, float64) {
+	return float64(cfg.Capacity), float64(cfg.RefillPerMinute) / 60.0
+}
+
+func (tokenBucket) Validate(cfg Config) error {
+	if cfg.RefillPerMinute <= 0 {
+		return fmt.Errorf("%s: refill per minute must be positive, got %d", TokenBucket, cfg.RefillPerMinute)
+	}
+	return nil
+}
+
+func (tokenBucket) Script() string {
+	return `function(key, now, requested, capacity, refill_per_sec, ttl_ms, commit)
+	ensure_type(key, "hash")
+	local state = redis.call("HMGET", key, "tokens", "ts")
+	local tokens = tonumber(state[1]) or capacity
+	local ts = tonumber(state[2]) or now
+	tokens = math.min(capacity, tokens + math.max(0, now - ts) * refill_per_sec)
+	if tokens < requested then
+		return false, tokens
+	end
+	if commit then
+		tokens = math.min(capacity, tokens - requested)
+		redis.call("HSET", key, "tokens", fmt(tokens), "ts", fmt(now))
+		redis.call("PEXPIRE", key, ttl_ms)
+	end
+	return true, tokens
+end`
+}
+
+// fixedWindow allows Capacity requests per Window, counted in windows aligned
+// to the epoch.
+type fixedWindow struct{}
+
+func (fixedWindow) Algorithm() Algorithm { return FixedWindow }
+
+func (fixedWindow) Params(cfg Config) (float64, float64) {
+	return float64(cfg.Capacity), cfg.window().Seconds()
+}
+
+func (fixedWindow) Validate(Config) error { return nil }
+
+func (fixedWindow) Script() string {
+	return `function(key, now, requested, limit, window, ttl_ms, commit)
+	ensure_type(key, "hash")
+	local idx = math.floor(now / window)
+	local state = redis.call("HMGET", key, "fw_idx", "fw_count")
+	local count = 0
+	if tonumber(state[1]) == idx then
+		count = tonumber(state[2]) or 0
+	end
+	if count + requested > limit then
+		return false, limit - count
+	end
+	if commit then
+		count = math.max(0, count + requested)
+		redis.call("HSET", key, "fw_idx", idx, "fw_count", count)
+		redis.call("PEXPIRE", key, ttl_ms)
+	end
+	return true, limit - count
+end`
+}
+
+// slidingLog remembers every accepted request and allows Capacity of them
+// within any trailing Window.
+type slidingLog struct{}
+
+func (slidingLog) Algorithm() Algorithm { return SlidingLog }
+
+func (slidingLog) Params(cfg Config) (float64, float64) {
+	return float64(cfg.Capacity), cfg.window().Seconds()
+}
+
+func (slidingLog) Validate(Config) error { return nil }
+
+func (slidingLog) Script() string {
+	return `function(key, now, requested, limit, window, ttl_ms, commit)
+	ensure_type(key, "zset")
+	redis.call("ZREMRANGEBYSCORE", key, "-inf", fmt(now - window))
+	local count = redis.call("ZCARD", key)
+	if count + requested > limit then
+		return false, limit - count
+	end
+	if commit then
+		if requested < 0 then
+			redis.call("ZPOPMAX", key, -requested)
+		end
+		for i = 1, requested do
+			redis.call("ZADD", key, fmt(now), fmt(now) .. ":" .. (count + i))
+		end
+		count = math.max(0, count + requested)
+		redis.call("PEXPIRE", key, ttl_ms)
+	end
+	return true, limit - count
+end`
+}
+
+// slidingWindow approximates the sliding log with two counters, weighting the
+// previous window by how much of it still overlaps the trailing Window.
+type slidingWindow struct{}
+
+func (slidingWindow) Algorithm() Algorithm { return SlidingWindow }
+
+func (slidingWindow) Params(cfg Config) (float64, float64) {
+	return float64(cfg.Capacity), cfg.window().Seconds()
+}
+
+func (slidingWindow) Validate(Config) error { return nil }
+
+func (slidingWindow) Script() string {
+	return `function(key, now, requested, limit, window, ttl_ms, commit)
+	ensure_type(key, "hash")
+	local idx = math.floor(now / window)
+	local state = redis.call("HMGET", key, "sw_idx", "sw_cur", "sw_prev")
+	local last = tonumber(state[1])
+	local cur, prev = 0, 0
+	if last == idx then
+		cur, prev = tonumber(state[2]) or 0, tonumber(state[3]) or 0
+	elseif last == idx - 1 then
+		prev = tonumber(state[2]) or 0
+	end
+	local elapsed = (now - idx * window) / window
+	local estimated = prev * (1 - elapsed) + cur
+	if estimated + requested > limit then
+		return false, limit - estimated
+	end
+	if commit then
+		local next_cur = math.max(0, cur + requested)
+		estimated = estimated + next_cur - cur
+		cur = next_cur
+		redis.call("HSET", key, "sw_idx", idx, "sw_cur", cur, "sw_prev", prev)
+		redis.call("PEXPIRE", key, ttl_ms)
+	end
+	return true, limit - estimated
+end`
+}
+
+// gcra is the generic cell rate algorithm: requests are spaced at
+// RefillPerMinute with bursts of up to Capacity. It stores only the
+// theoretical arrival time of the next request.
+type gcra struct{}
+
+func (gcra) Algorithm() Algorithm { return GCRA }
+
+func (gcra) Params(cfg Config) (float64, float64) {
+	return float64(cfg.Capacity), float64(cfg.RefillPerMinute) / 60.0
+}
+
+func (gcra) Validate(cfg Config) error {
+	if cfg.RefillPerMinute <= 0 {
+		return fmt.Errorf("%s: refill per minute must be positive, got %d", GCRA, cfg.RefillPerMinute)
+	}
+	return nil
+}
+
+func (gcra) Script() string {
+	return `function(key, now, requested, burst, rate, ttl_ms, commit)
+	ensure_type(key, "hash")
+	local interval = 1 / rate
+	local tolerance = burst * interval
+	local tat = math.max(tonumber(redis.call("HGET", key, "tat")) or now, now)
+	local new_tat = tat + requested * interval
+	if new_tat - now > tolerance then
+		return false, math.max(0, (tolerance - (tat - now)) / interval)
+	end
+	if commit then
+		tat = math.max(new_tat, now)
+		redis.call("HSET", key, "tat", fmt(tat))
+		redis.call("PEXPIRE", key, ttl_ms)
+	end
+	return true, (tolerance - (tat - now)) / interval
+end`
+}
@@ -0,0 +1,153 @@
+package bucket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// epoch is aligned to a minute so that window boundaries are predictable.
+var epoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func newClockRL(t *testing.T) (*RateLimiter, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	mr.SetTime(epoch)
+	return NewRateLimiter(rdb, 5*time.Minute, Config{}, Config{}, Config{}), mr
+}
+
+// expectAllows takes n requests at offset and checks how many were allowed.
+func expectAllows(t *testing.T, rl *RateLimiter, mr *miniredis.Miniredis,
+	cfg Config, offset time.Duration, n, want int,
+) {
+	t.Helper()
+	mr.SetTime(epoch.Add(offset))
+	got := 0
+	for i := 0; i < n; i++ {
+		ok, _, err := rl.Allow(context.Background(), "test:algo", cfg, 1)
+		if err != nil {
+			t.Fatalf("Allow err at %s: %v", offset, err)
+		}
+		if ok {
+			got++
+		}
+	}
+	if got != want {
+		t.Fatalf("at %s: expected %d of %d allowed, got %d", offset, want, n, got)
+	}
+}
+
+func TestFixedWindowBoundary(t *testing.T) {
+	rl, mr := newClockRL(t)
+	cfg := Config{Capacity: 3, Algorithm: FixedWindow, Window: time.Minute}
+	expectAllows(t, rl, mr, cfg, 10*time.Second, 4, 3)
+	expectAllows(t, rl, mr, cfg, 59*time.Second, 1, 0)
+	// A new window starts from zero, so a burst straddling the boundary
+	// gets twice the capacity.
+	expectAllows(t, rl, mr, cfg, 60*time.Second, 4, 3)
+}
+
+func TestSlidingLogBoundary(t *testing.T) {
+	rl, mr := newClockRL(t)
+	cfg := Config{Capacity: 3, Algorithm: SlidingLog, Window: time.Minute}
+	expectAllows(t, rl, mr, cfg, 0, 1, 1)
+	expectAllows(t, rl, mr, cfg, 10*time.Second, 1, 1)
+	expectAllows(t, rl, mr, cfg, 20*time.Second, 2, 1)
+	expectAllows(t, rl, mr, cfg, 59*time.Second, 1, 0)
+	// Exactly one window after the first request it falls out of the log.
+	expectAllows(t, rl, mr, cfg, 60*time.Second, 2, 1)
+	expectAllows(t, rl, mr, cfg, 70*time.Second, 2, 1)
+}
+
+func TestSlidingWindowBoundary(t *testing.T) {
+	rl, mr := newClockRL(t)
+	cfg := Config{Capacity: 10, Algorithm: SlidingWindow, Window: time.Minute}
+	expectAllows(t, rl, mr, cfg, 30*time.Second, 11, 10)
+	// A quarter into the next window the previous one still weighs 7.5.
+	expectAllows(t, rl, mr, cfg, 75*time.Second, 3, 2)
+	// Two windows later nothing of the first one is left.
+	expectAllows(t, rl, mr, cfg, 180*time.Second, 11, 10)
+}
+
+func TestGCRABoundary(t *testing.T) {
+	rl, mr := newClockRL(t)
+	cfg := Config{Capacity: 3, RefillPerMinute: 60, Algorithm: GCRA}
+	expectAllows(t, rl, mr, cfg, 0, 4, 3)
+	expectAllows(t, rl, mr, cfg, 999*time.Millisecond, 1, 0)
+	expectAllows(t, rl, mr, cfg, time.Second, 2, 1)
+	// Requests at exactly the emission interval are never throttled.
+	for i := 2; i < 10; i++ {
+		expectAllows(t, rl, mr, cfg, time.Duration(i)*time.Second, 1, 1)
+	}
+	// After a long pause the burst is restored but not exceeded.
+	expectAllows(t, rl, mr, cfg, time.Hour, 4, 3)
+}
+
+func TestTokenBucketBoundary(t *testing.T) {
+	rl, mr := newClockRL(t)
+	cfg := Config{Capacity: 2, RefillPerMinute: 60}
+	expectAllows(t, rl, mr, cfg, 0, 3, 2)
+	expectAllows(t, rl, mr, cfg, 999*time.Millisecond, 1, 0)
+	expectAllows(t, rl, mr, cfg, time.Second, 2, 1)
+	expectAllows(t, rl, mr, cfg, time.Hour, 3, 2)
+}
+
+func TestAlgorithmSwitchResetsState(t *testing.T) {
+	rl, mr := newClockRL(t)
+	log := Config{Capacity: 1, Algorithm: SlidingLog}
+	bucket := Config{Capacity: 1, RefillPerMinute: 1}
+	expectAllows(t, rl, mr, log, 0, 2, 1)
+	expectAllows(t, rl, mr, bucket, 0, 2, 1)
+	expectAllows(t, rl, mr, log, 0, 1, 1)
+}
+
+func TestCheckAllMixedAlgorithms(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	mr.SetTime(epoch)
+	rl := NewRateLimiter(rdb, 5*time.Minute,
+		Config{Capacity: 2, Algorithm: FixedWindow},
+		Config{Capacity: 5, RefillPerMinute: 5, Algorithm: GCRA},
+		Config{Capacity: 5, Algorithm: SlidingLog})
+	for i := 0; i < 2; i++ {
+		if ok, _, err := rl.CheckAll(ctx, "carol", "pw", "192.0.2.9"); err != nil || !ok {
+			t.Fatalf("expected check %d allowed, got ok=%v err=%v", i+1, ok, err)
+		}
+	}
+	ok, details, err := rl.CheckAll(ctx, "carol", "pw", "192.0.2.9")
+	if err != nil {
+		t.Fatalf("CheckAll err: %v", err)
+	}
+	if ok || details["login"] || !details["pass"] || !details["ip"] {
+		t.Fatalf("expected only login to deny, got ok=%v details=%v", ok, details)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		cfg   Config
+		valid bool
+	}{
+		{Config{Capacity: 10, RefillPerMinute: 10}, true},
+		{Config{Capacity: 10, Algorithm: SlidingLog, Window: time.Second}, true},
+		{Config{Capacity: 0, RefillPerMinute: 10}, false},
+		{Config{Capacity: 10, RefillPerMinute: -1}, false},
+		{Config{Capacity: 10, Algorithm: TokenBucket}, false},
+		{Config{Capacity: 10, Algorithm: FixedWindow}, true},
+		{Config{Capacity: 10, Algorithm: GCRA}, false},
+		{Config{Capacity: 10, Algorithm: FixedWindow, Window: -time.Second}, false},
+		{Config{Capacity: 10, Algorithm: "leaky"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.cfg.Validate(); (err == nil) != tt.valid {
+			t.Errorf("Validate(%+v) = %v, want valid=%v", tt.cfg, err, tt.valid)
+		}
+	}
+}
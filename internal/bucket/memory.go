@@ -0,0 +1,402 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LocalState is the in-process counterpart of a Redis key: Hash mirrors the
+// numeric fields of a hash and Log the scores of a sorted set.
+type LocalState struct {
+	Hash map[string]float64
+	Log  []float64
+}
+
+// LocalLimiter is a Limiter that MemoryRateLimiter can evaluate in process.
+// TakeLocal follows the contract of the Lua function returned by Script, with
+// st in place of the Redis key.
+type LocalLimiter interface {
+	Limiter
+	TakeLocal(st *LocalState, now, requested, a, b float64, commit bool) (bool, float64)
+}
+
+func (tokenBucket) TakeLocal(st *LocalState, now, requested, capacity, refillPerSec float64, commit bool) (bool, float64) {
+	tokens, ok := st.Hash["tokens"]
+	if !ok {
+		tokens = capacity
+	}
+	ts, ok := st.Hash["ts"]
+	if !ok {
+		ts = now
+	}
+	tokens = math.Min(capacity, tokens+math.Max(0, now-ts)*refillPerSec)
+	if tokens < requested {
+		return false, tokens
+	}
+	if commit {
+		tokens = math.Min(capacity, tokens-requested)
+		st.Hash["tokens"], st.Hash["ts"] = tokens, now
+	}
+	return true, tokens
+}
+
+func (fixedWindow) TakeLocal(st *LocalState, now, requested, limit, window float64, commit bool) (bool, float64) {
+	idx := math.Floor(now / window)
+	count := 0.0
+	if last, ok := st.Hash["fw_idx"]; ok && last == idx {
+		count = st.Hash["fw_count"]
+	}
+	if count+requested > limit {
+		return false, limit - count
+	}
+	if commit {
+		count = math.Max(0, count+requested)
+		st.Hash["fw_idx"], st.Hash["fw_count"] = idx, count
+	}
+	return true, limit - count
+}
+
+func (slidingLog) TakeLocal(st *LocalState, now, requested, limit, window float64, commit bool) (bool, float64) {
+	cut := sort.Search(len(st.Log), func(i int) bool { return st.Log[i] > now-window })
+	st.Log = st.Log[cut:]
+	count := float64(len(st.Log))
+	if count+requested > limit {
+		return false, limit - count
+	}
+	if commit {
+		if requested < 0 {
+			st.Log = st.Log[:max(0, len(st.Log)+int(requested))]
+		}
+		for i := 0; i < int(requested); i++ {
+			st.Log = append(st.Log, now)
+		}
+		count = math.Max(0, count+requested)
+	}
+	return true, limit - count
+}
+
+func (slidingWindow) TakeLocal(st *LocalState, now, requested, limit, window float64, commit bool) (bool, float64) {
+	idx := math.Floor(now / window)
+	cur, prev := 0.0, 0.0
+	if last, ok := st.Hash["sw_idx"]; ok {
+		switch last {
+		case idx:
+			cur, prev = st.Hash["sw_cur"], st.Hash["sw_prev"]
+		case idx - 1:
+			prev = st.Hash["sw_cur"]
+		}
+	}
+	elapsed := (now - idx*window) / window
+	estimated := prev*(1-elapsed) + cur
+	if estimated+requested > limit {
+		return false, limit - estimated
+	}
+	if commit {
+		nextCur := math.Max(0, cur+requested)
+		estimated += nextCur - cur
+		cur = nextCur
+		st.Hash["sw_idx"], st.Hash["sw_cur"], st.Hash["sw_prev"] = idx, cur, prev
+	}
+	return true, limit - estimated
+}
+
+func (gcra) TakeLocal(st *LocalState, now, requested, burst, rate float64, commit bool) (bool, float64) {
+	interval := 1 / rate
+	tolerance := burst * interval
+	tat, ok := st.Hash["tat"]
+	if !ok {
+		tat = now
+	}
+	tat = math.Max(tat, now)
+	newTat := tat + requested*interval
+	if newTat-now > tolerance {
+		return false, math.Max(0, (tolerance-(tat-now))/interval)
+	}
+	if commit {
+		tat = math.Max(newTat, now)
+		st.Hash["tat"] = tat
+	}
+	return true, (tolerance - (tat - now)) / interval
+}
+
+const memShards = 64
+
+type memEntry struct {
+	algorithm Algorithm
+	state     LocalState
+	expires   time.Time
+}
+
+type memShard struct {
+	mu    sync.Mutex
+	items map[string]*memEntry
+}
+
+// MemoryRateLimiter keeps buckets in process for single-node deployments
+// and tests. Keys are spread over lock-striped shards, a check locks only
+// the shards of its keys, and entries expire keyTTL after their last write
+// just like the Redis keys do.
+type MemoryRateLimiter struct {
+	dimensions
+	shards [memShards]memShard
+	keyTTL time.Duration
+	now    func() time.Time
+	stop   chan struct{}
+	once   sync.Once
+}
+
+func NewMemoryRateLimiter(keyTTL time.Duration,
+	loginCfg Config,
+	passCfg Config,
+	ipCfg Config,
+) *MemoryRateLimiter {
+	m := &MemoryRateLimiter{
+		keyTTL: keyTTL,
+		now:    time.Now,
+		stop:   make(chan struct{}),
+	}
+	m.init(loginCfg, passCfg, ipCfg)
+	for i := range m.shards {
+		m.shards[i].items = make(map[string]*memEntry)
+	}
+	go m.evictLoop(keyTTL)
+	return m
+}
+
+// Close stops background eviction.
+func (m *MemoryRateLimiter) Close() {
+	m.once.Do(func() { close(m.stop) })
+}
+
+func (m *MemoryRateLimiter) evictLoop(interval time.Duration) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.evictExpired()
+		}
+	}
+}
+
+func (m *MemoryRateLimiter) evictExpired() {
+	now := m.now()
+	for i := range m.shards {
+		sh := &m.shards[i]
+		sh.mu.Lock()
+		for key, e := range sh.items {
+			if !now.Before(e.expires) {
+				delete(sh.items, key)
+			}
+		}
+		sh.mu.Unlock()
+	}
+}
+
+func shardIndex(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % memShards)
+}
+
+// lockShards locks the shards of keys in index order, so that concurrent
+// multi-key checks cannot deadlock, and returns the matching unlock.
+func (m *MemoryRateLimiter) lockShards(keys []string) func() {
+	idx := make([]int, 0, len(keys))
+	seen := make(map[int]bool, len(keys))
+	for _, key := range keys {
+		if i := shardIndex(key); !seen[i] {
+			seen[i] = true
+			idx = append(idx, i)
+		}
+	}
+	sort.Ints(idx)
+	for _, i := range idx {
+		m.shards[i].mu.Lock()
+	}
+	return func() {
+		for j := len(idx) - 1; j >= 0; j-- {
+			m.shards[idx[j]].mu.Unlock()
+		}
+	}
+}
+
+// take evaluates checks under the locks of their shards, with the same
+// peek-then-commit steps as the Redis script.
+func (m *MemoryRateLimiter) take(ctx context.Context, mode ConsumeMode, checks ...bucketCheck) (_ []bucketResult, err error) {
+	_, span := startTake(ctx, "memory", mode, checks)
+	var results []bucketResult
+	defer func() { endTake(span, checks, results, err) }()
+	type pending struct {
+		limiter LocalLimiter
+		entry   *memEntry
+		a, b    float64
+		ttl     time.Duration
+	}
+	keys := make([]string, 0, len(checks))
+	for _, c := range checks {
+		keys = append(keys, c.key)
+	}
+	unlock := m.lockShards(keys)
+	defer unlock()
+
+	now := m.now()
+	nowSec := float64(now.UnixNano()) / 1e9
+	entries := make(map[string]*memEntry, len(checks))
+	pend := make([]pending, len(checks))
+	results = make([]bucketResult, len(checks))
+	all := true
+	for i, c := range checks {
+		l, ok := lookupLimiter(c.cfg.algorithm())
+		if !ok {
+			return nil, fmt.Errorf("rate limiter: unknown algorithm %q", c.cfg.Algorithm)
+		}
+		local, ok := l.(LocalLimiter)
+		if !ok {
+			return nil, fmt.Errorf("rate limiter: algorithm %q cannot run in memory", l.Algorithm())
+		}
+		e, ok := entries[c.key]
+		if !ok {
+			e = m.shards[shardIndex(c.key)].items[c.key]
+			if e == nil || !now.Before(e.expires) || e.algorithm != l.Algorithm() {
+				e = &memEntry{algorithm: l.Algorithm(), state: LocalState{Hash: map[string]float64{}}}
+			}
+			entries[c.key] = e
+		}
+		a, b := l.Params(c.cfg)
+		pend[i] = pending{limiter: local, entry: e, a: a, b: b, ttl: max(m.keyTTL, 2*c.cfg.window())}
+		results[i].allowed, results[i].remaining = local.TakeLocal(&e.state, nowSec, float64(c.requested), a, b, false)
+		all = all && results[i].allowed
+	}
+	for i, c := range checks {
+		if mode == consumeNone || !results[i].allowed || (!all && mode == ConsumeAllOrNothing) {
+			continue
+		}
+		p := pend[i]
+		results[i].allowed, results[i].remaining = p.limiter.TakeLocal(&p.entry.state, nowSec, float64(c.requested), p.a, p.b, true)
+		p.entry.expires = now.Add(p.ttl)
+		m.shards[shardIndex(c.key)].items[c.key] = p.entry
+	}
+	return results, nil
+}
+
+// Allow takes requested tokens from the bucket stored at key.
+func (m *MemoryRateLimiter) Allow(ctx context.Context,
+	key string,
+	cfg Config,
+	requested int,
+) (bool, float64, error) {
+	res, err := m.take(ctx, ConsumeEach, bucketCheck{key: key, cfg: cfg, requested: requested})
+	if err != nil {
+		return false, 0, err
+	}
+	return res[0].allowed, res[0].remaining, nil
+}
+
+// CheckAll checks the login, password and IP buckets atomically.
+func (m *MemoryRateLimiter) CheckAll(ctx context.Context, login, password, ip string) (bool, map[string]bool, error) {
+	l := m.limits.Load()
+	checks := m.checks(l, login, password, ip)
+	results, err := m.take(ctx, l.consumeMode, checks...)
+	if err != nil {
+		return false, nil, err
+	}
+	ok, res := verdict(checks, results)
+	return ok, res, nil
+}
+
+func (m *MemoryRateLimiter) Peek(ctx context.Context, login, password, ip string) (bool, map[string]bool, error) {
+	checks := m.checks(m.limits.Load(), login, password, ip)
+	results, err := m.take(ctx, consumeNone, checks...)
+	if err != nil {
+		return false, nil, err
+	}
+	ok, res := verdict(checks, results)
+	return ok, res, nil
+}
+
+func (m *MemoryRateLimiter) Refund(ctx context.Context, login, password, ip string) error {
+	return refund(ctx, m.take, m.checks(m.limits.Load(), login, password, ip))
+}
+
+func (m *MemoryRateLimiter) Penalize(ctx context.Context, login, password, ip string, cost int) error {
+	return penalize(ctx, m.take, m.checks(m.limits.Load(), login, password, ip), cost)
+}
+
+// ResetIP drops the bucket of ip and those of its subnets.
+func (m *MemoryRateLimiter) ResetIP(ctx context.Context, ip string) error {
+	for _, pattern := range m.ipResets(ip) {
+		if err := m.ResetAll(ctx, pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryRateLimiter) ResetLogin(ctx context.Context, login string) error {
+	return m.ResetAll(ctx, "login:"+login)
+}
+
+// ResetAll drops every bucket whose key matches "bf:"+reset, using the glob
+// syntax of Redis SCAN MATCH.
+func (m *MemoryRateLimiter) ResetAll(_ context.Context, reset string) error {
+	pattern := "bf:" + reset
+	for i := range m.shards {
+		sh := &m.shards[i]
+		sh.mu.Lock()
+		for key := range sh.items {
+			if globMatch(pattern, key) {
+				delete(sh.items, key)
+			}
+		}
+		sh.mu.Unlock()
+	}
+	return nil
+}
+
+// globMatch supports the "*", "?" and "\" escapes of Redis glob patterns.
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+	return s == ""
+}
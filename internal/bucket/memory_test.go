@@ -0,0 +1,374 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var (
+	_ Backend = (*RateLimiter)(nil)
+	_ Backend = (*MemoryRateLimiter)(nil)
+)
+
+func newTestMemoryRL(t *testing.T, loginCfg, passCfg, ipCfg Config) (*MemoryRateLimiter, *time.Time) {
+	t.Helper()
+	now := epoch
+	m := NewMemoryRateLimiter(5*time.Minute, loginCfg, passCfg, ipCfg)
+	m.now = func() time.Time { return now }
+	t.Cleanup(m.Close)
+	return m, &now
+}
+
+func TestMemoryMatchesRedis(t *testing.T) {
+	ctx := context.Background()
+	configs := []Config{
+		{Capacity: 5, RefillPerMinute: 30},
+		{Capacity: 5, Algorithm: FixedWindow, Window: 10 * time.Second},
+		{Capacity: 5, Algorithm: SlidingLog, Window: 10 * time.Second},
+		{Capacity: 5, Algorithm: SlidingWindow, Window: 10 * time.Second},
+		{Capacity: 5, RefillPerMinute: 30, Algorithm: GCRA},
+	}
+	for _, cfg := range configs {
+		t.Run(string(cfg.algorithm()), func(t *testing.T) {
+			rl, mr := newClockRL(t)
+			m, now := newTestMemoryRL(t, Config{}, Config{}, Config{})
+			rnd := rand.New(rand.NewSource(1)) //nolint: gosec
+			offset := time.Duration(0)
+			for i := 0; i < 300; i++ {
+				offset += time.Duration(rnd.Intn(1500)) * time.Millisecond
+				mr.SetTime(epoch.Add(offset))
+				*now = epoch.Add(offset)
+				requested := 1 + rnd.Intn(2)
+				okRedis, _, err := rl.Allow(ctx, "test:parity", cfg, requested)
+				if err != nil {
+					t.Fatalf("redis Allow err: %v", err)
+				}
+				okMem, _, err := m.Allow(ctx, "test:parity", cfg, requested)
+				if err != nil {
+					t.Fatalf("memory Allow err: %v", err)
+				}
+				if okRedis != okMem {
+					t.Fatalf("step %d at %s: redis allowed=%v, memory allowed=%v", i, offset, okRedis, okMem)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryCheckAllConsumeModes(t *testing.T) {
+	ctx := context.Background()
+	for _, mode := range []ConsumeMode{ConsumeEach, ConsumeAllOrNothing} {
+		t.Run(string(mode), func(t *testing.T) {
+			m, _ := newTestMemoryRL(t,
+				Config{Capacity: 2, RefillPerMinute: 0},
+				Config{Capacity: 2, RefillPerMinute: 0},
+				Config{Capacity: 1, RefillPerMinute: 0})
+			m.SetConsumeMode(mode)
+			if ok, _, err := m.CheckAll(ctx, "dave", "pw", "192.0.2.2"); err != nil || !ok {
+				t.Fatalf("expected first check allowed, got ok=%v err=%v", ok, err)
+			}
+			ok, details, err := m.CheckAll(ctx, "dave", "pw", "192.0.2.2")
+			if err != nil || ok || details["ip"] || !details["login"] {
+				t.Fatalf("expected ip denial, got ok=%v details=%v err=%v", ok, details, err)
+			}
+			// The login bucket has a token left only if the denied check did
+			// not take one.
+			ok, _, err = m.Allow(ctx, "bf:login:dave", Config{Capacity: 2, RefillPerMinute: 0}, 1)
+			if err != nil {
+				t.Fatalf("Allow err: %v", err)
+			}
+			if ok != (mode == ConsumeAllOrNothing) {
+				t.Fatalf("unexpected login token for mode %s: allowed=%v", mode, ok)
+			}
+		})
+	}
+}
+
+func TestMemoryEviction(t *testing.T) {
+	ctx := context.Background()
+	m, now := newTestMemoryRL(t, Config{}, Config{}, Config{})
+	cfg := Config{Capacity: 1, RefillPerMinute: 0}
+	if ok, _, _ := m.Allow(ctx, "bf:ip:192.0.2.3", cfg, 1); !ok {
+		t.Fatal("expected first request allowed")
+	}
+	if ok, _, _ := m.Allow(ctx, "bf:ip:192.0.2.3", cfg, 1); ok {
+		t.Fatal("expected bucket to be empty")
+	}
+	*now = now.Add(4 * time.Minute)
+	m.evictExpired()
+	if ok, _, _ := m.Allow(ctx, "bf:ip:192.0.2.3", cfg, 1); ok {
+		t.Fatal("expected bucket to be kept before keyTTL")
+	}
+	*now = now.Add(5 * time.Minute)
+	m.evictExpired()
+	if n := len(m.shards[shardIndex("bf:ip:192.0.2.3")].items); n != 0 {
+		t.Fatalf("expected expired entry to be evicted, %d left", n)
+	}
+	if ok, _, _ := m.Allow(ctx, "bf:ip:192.0.2.3", cfg, 1); !ok {
+		t.Fatal("expected a fresh bucket after eviction")
+	}
+}
+
+func TestMemoryReset(t *testing.T) {
+	ctx := context.Background()
+	cfg := Config{Capacity: 1, RefillPerMinute: 0}
+	m, _ := newTestMemoryRL(t, cfg, cfg, cfg)
+	m.CheckAll(ctx, "erin", "pw", "192.0.2.4")
+	m.CheckAll(ctx, "erin2", "pw2", "192.0.2.5")
+	if err := m.ResetLogin(ctx, "erin"); err != nil {
+		t.Fatalf("ResetLogin err: %v", err)
+	}
+	if ok, _, _ := m.Allow(ctx, "bf:login:erin", cfg, 1); !ok {
+		t.Fatal("expected login bucket reset")
+	}
+	if ok, _, _ := m.Allow(ctx, "bf:login:erin2", cfg, 1); ok {
+		t.Fatal("expected other login bucket untouched")
+	}
+	if err := m.ResetAll(ctx, "*"); err != nil {
+		t.Fatalf("ResetAll err: %v", err)
+	}
+	for i := range m.shards {
+		if n := len(m.shards[i].items); n != 0 {
+			t.Fatalf("expected all buckets reset, shard %d has %d", i, n)
+		}
+	}
+}
+
+func TestMemoryConcurrentCheckAll(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newTestMemoryRL(t,
+		Config{Capacity: 50, RefillPerMinute: 0},
+		Config{Capacity: 1000, RefillPerMinute: 0},
+		Config{Capacity: 1000, RefillPerMinute: 0})
+	m.SetConsumeMode(ConsumeAllOrNothing)
+	var wg sync.WaitGroup
+	var allowed atomic.Int32
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ok, _, err := m.CheckAll(ctx, "frank", "pw", fmt.Sprintf("192.0.2.%d", i%10))
+			if err == nil && ok {
+				allowed.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if allowed.Load() != 50 {
+		t.Fatalf("expected exactly 50 allowed, got %d", allowed.Load())
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		expect     bool
+	}{
+		{"bf:*", "bf:login:a/b", true},
+		{"bf:login:a", "bf:login:a", true},
+		{"bf:login:a", "bf:login:ab", false},
+		{"bf:ip:?.0.0.1", "bf:ip:1.0.0.1", true},
+		{"bf:login:\\*", "bf:login:*", true},
+		{"bf:login:\\*", "bf:login:x", false},
+		{"*:pass:*", "bf:pass:abc", true},
+	}
+	for _, tt := range tests {
+		if got := globMatch(tt.pattern, tt.s); got != tt.expect {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.expect)
+		}
+	}
+}
+
+func TestPeekTakesNothing(t *testing.T) {
+	ctx := context.Background()
+	cfg := Config{Capacity: 1, RefillPerMinute: 1}
+	redisRL, _ := newClockRL(t)
+	if err := redisRL.SetLimits(cfg, cfg, cfg, nil, ConsumeEach); err != nil {
+		t.Fatalf("SetLimits: %v", err)
+	}
+	memRL, _ := newTestMemoryRL(t, cfg, cfg, cfg)
+	for name, b := range map[string]Backend{"redis": redisRL, "memory": memRL} {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 3; i++ {
+				if ok, _, err := b.Peek(ctx, "gina", "pw", "192.0.2.6"); err != nil || !ok {
+					t.Fatalf("peek %d: expected allowed, got ok=%v err=%v", i+1, ok, err)
+				}
+			}
+			if ok, _, err := b.CheckAll(ctx, "gina", "pw", "192.0.2.6"); err != nil || !ok {
+				t.Fatalf("expected the token to still be there, got ok=%v err=%v", ok, err)
+			}
+			ok, details, err := b.Peek(ctx, "gina", "pw", "192.0.2.6")
+			if err != nil || ok || details["login"] {
+				t.Fatalf("expected peek to see the empty bucket, got ok=%v details=%v err=%v", ok, details, err)
+			}
+		})
+	}
+}
+
+func TestRefundAndPenalize(t *testing.T) {
+	ctx := context.Background()
+	allowed := func(t *testing.T, b Backend, n int) int {
+		t.Helper()
+		got := 0
+		for i := 0; i < n; i++ {
+			ok, _, err := b.CheckAll(ctx, "hank", "pw", "192.0.2.7")
+			if err != nil {
+				t.Fatalf("CheckAll err: %v", err)
+			}
+			if ok {
+				got++
+			}
+		}
+		return got
+	}
+	for _, algo := range []Algorithm{TokenBucket, FixedWindow, SlidingLog, SlidingWindow, GCRA} {
+		cfg := Config{Capacity: 3, RefillPerMinute: 1, Algorithm: algo, Window: time.Hour}
+		redisRL, _ := newClockRL(t)
+		if err := redisRL.SetLimits(cfg, cfg, cfg, nil, ConsumeEach); err != nil {
+			t.Fatalf("SetLimits: %v", err)
+		}
+		memRL, _ := newTestMemoryRL(t, cfg, cfg, cfg)
+		for name, b := range map[string]Backend{"redis": redisRL, "memory": memRL} {
+			t.Run(string(algo)+"/"+name, func(t *testing.T) {
+				if got := allowed(t, b, 4); got != 3 {
+					t.Fatalf("expected 3 of 4 allowed, got %d", got)
+				}
+				if err := b.Refund(ctx, "hank", "pw", "192.0.2.7"); err != nil {
+					t.Fatalf("Refund err: %v", err)
+				}
+				if got := allowed(t, b, 2); got != 1 {
+					t.Fatalf("expected the refunded attempt back, got %d allowed", got)
+				}
+				for i := 0; i < 5; i++ {
+					if err := b.Refund(ctx, "hank", "pw", "192.0.2.7"); err != nil {
+						t.Fatalf("Refund err: %v", err)
+					}
+				}
+				if err := b.Penalize(ctx, "hank", "pw", "192.0.2.7", 2); err != nil {
+					t.Fatalf("Penalize err: %v", err)
+				}
+				if got := allowed(t, b, 2); got != 1 {
+					t.Fatalf("expected refunds capped at capacity minus the penalty, got %d allowed", got)
+				}
+				if err := b.Refund(ctx, "hank", "pw", "192.0.2.7"); err != nil {
+					t.Fatalf("Refund err: %v", err)
+				}
+				if err := b.Penalize(ctx, "hank", "pw", "192.0.2.7", 5); err != nil {
+					t.Fatalf("Penalize err: %v", err)
+				}
+				if got := allowed(t, b, 1); got != 0 {
+					t.Fatalf("expected a penalty above what is left to drain the bucket, got %d allowed", got)
+				}
+			})
+		}
+	}
+}
+
+func TestSetLimits(t *testing.T) {
+	ctx := context.Background()
+	small := Config{Capacity: 1, RefillPerMinute: 1}
+	large := Config{Capacity: 100, RefillPerMinute: 1}
+	m, _ := newTestMemoryRL(t, small, large, large)
+	if ok, _, _ := m.CheckAll(ctx, "ivan", "pw", "192.0.2.8"); !ok {
+		t.Fatal("expected the first attempt allowed")
+	}
+	if ok, _, _ := m.CheckAll(ctx, "ivan", "pw", "192.0.2.8"); ok {
+		t.Fatal("expected the second attempt denied with a capacity of 1")
+	}
+	if err := m.SetLimits(Config{Capacity: 0}, large, large, nil, ConsumeEach); err == nil {
+		t.Fatal("expected an invalid login config to be rejected")
+	}
+	if err := m.SetLimits(small, large, large, nil, "some"); err == nil {
+		t.Fatal("expected an unknown consume mode to be rejected")
+	}
+	if m.limits.Load().loginCfg != small {
+		t.Fatal("expected the rejected limits to leave the old ones in place")
+	}
+	// A bucket keeps its tokens across a change, a fresh login sees the new
+	// capacity at once.
+	if err := m.SetLimits(Config{Capacity: 3, RefillPerMinute: 1}, large, large, nil, ConsumeAllOrNothing); err != nil {
+		t.Fatalf("SetLimits: %v", err)
+	}
+	for i := range 3 {
+		if ok, _, _ := m.CheckAll(ctx, "judy", "pw", "192.0.2.9"); !ok {
+			t.Fatalf("attempt %d: expected the new capacity of 3 to allow it", i+1)
+		}
+	}
+	if m.limits.Load().consumeMode != ConsumeAllOrNothing {
+		t.Fatal("expected the consume mode to change with the limits")
+	}
+}
+
+func TestSetConsumeModeKeepsLimits(t *testing.T) {
+	large := Config{Capacity: 100, RefillPerMinute: 1}
+	subnets, err := ParseSubnets("ipv4/24=2")
+	if err != nil {
+		t.Fatalf("ParseSubnets: %v", err)
+	}
+	for i := range 200 {
+		m, _ := newTestMemoryRL(t, large, large, large)
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			m.SetConsumeMode(ConsumeAllOrNothing)
+		}()
+		go func() {
+			defer wg.Done()
+			if err := m.SetLimits(large, large, large, subnets, ConsumeAllOrNothing); err != nil {
+				t.Errorf("SetLimits: %v", err)
+			}
+		}()
+		wg.Wait()
+		if l := m.limits.Load(); len(l.subnets) != 1 || l.consumeMode != ConsumeAllOrNothing {
+			t.Fatalf("round %d: expected both changes kept, got %+v", i+1, l)
+		}
+	}
+}
+
+// fixedOverrides overrides the logins and the exact IPs it holds.
+type fixedOverrides struct {
+	logins, ips map[string]Config
+}
+
+func (f fixedOverrides) Login(login string) (Config, bool) {
+	cfg, ok := f.logins[login]
+	return cfg, ok
+}
+
+func (f fixedOverrides) IP(ip string) (Config, bool) {
+	cfg, ok := f.ips[ip]
+	return cfg, ok
+}
+
+func TestOverrides(t *testing.T) {
+	ctx := context.Background()
+	small := Config{Capacity: 1, RefillPerMinute: 0}
+	large := Config{Capacity: 100, RefillPerMinute: 0}
+	m, _ := newTestMemoryRL(t, small, large, small)
+	m.SetOverrides(fixedOverrides{
+		logins: map[string]Config{"backup": {Capacity: 3, RefillPerMinute: 0}},
+		ips:    map[string]Config{"198.51.100.1": large},
+	})
+	// The office NAT address lifts the IP limit, the service account the
+	// login limit.
+	for i := range 3 {
+		if ok, res, _ := m.CheckAll(ctx, "backup", "pw", "198.51.100.1"); !ok {
+			t.Fatalf("attempt %d: expected both overrides to allow it, got %v", i+1, res)
+		}
+	}
+	if ok, res, _ := m.CheckAll(ctx, "backup", "pw", "198.51.100.1"); ok || res["login"] || !res["ip"] {
+		t.Fatalf("expected the login override to run out after 3, got %v", res)
+	}
+	if ok, _, _ := m.CheckAll(ctx, "kim", "pw", "192.0.2.10"); !ok {
+		t.Fatal("expected the first attempt of a plain login allowed")
+	}
+	if ok, res, _ := m.CheckAll(ctx, "kim", "pw", "192.0.2.10"); ok || res["login"] || res["ip"] {
+		t.Fatalf("expected the defaults for everyone else, got %v", res)
+	}
+}
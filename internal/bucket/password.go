@@ -0,0 +1,73 @@
+package bucket
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// MinPasswordKeyLen is the shortest secret NewPasswordHasher accepts.
+const MinPasswordKeyLen = 16
+
+// PasswordHasher names password buckets after an HMAC-SHA256 of the password
+// under a server secret, so the keys stored in Redis cannot be matched
+// against a dictionary without it.
+//
+// To rotate the secret, the new one becomes current and the old ones are
+// kept as previous until the buckets they name have expired. Until then every
+// attempt is checked against the bucket of each key, so the rotation does
+// not hand out a fresh budget.
+type PasswordHasher struct {
+	current       []byte
+	previous      [][]byte
+	previousUntil time.Time
+	now           func() time.Time
+}
+
+// NewPasswordHasher hashes with current and also checks previous keys until
+// previousUntil, or for as long as they are configured when it is zero.
+func NewPasswordHasher(current string, previous []string, previousUntil time.Time) (*PasswordHasher, error) {
+	if len(current) < MinPasswordKeyLen {
+		return nil, errors.New("password hash key must be at least 16 bytes")
+	}
+	h := &PasswordHasher{current: []byte(current), previousUntil: previousUntil, now: time.Now}
+	for _, key := range previous {
+		if len(key) < MinPasswordKeyLen {
+			return nil, errors.New("previous password hash keys must be at least 16 bytes")
+		}
+		h.previous = append(h.previous, []byte(key))
+	}
+	return h, nil
+}
+
+// NewRandomPasswordHasher hashes with a random key that lives as long as the
+// process. It suits buckets kept in memory, which are lost on restart anyway.
+func NewRandomPasswordHasher() *PasswordHasher {
+	return &PasswordHasher{current: []byte(rand.Text()), now: time.Now}
+}
+
+// hashes returns the hash of pw under the current key first, then under
+// every previous key still in its grace period. A nil PasswordHasher falls
+// back to the unkeyed SHA-256 of older releases.
+func (h *PasswordHasher) hashes(pw string) []string {
+	if h == nil {
+		sum := sha256.Sum256([]byte(pw))
+		return []string{hex.EncodeToString(sum[:])}
+	}
+	out := []string{keyedHash(h.current, pw)}
+	if h.previousUntil.IsZero() || h.now().Before(h.previousUntil) {
+		for _, key := range h.previous {
+			out = append(out, keyedHash(key, pw))
+		}
+	}
+	return out
+}
+
+func keyedHash(key []byte, pw string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(pw))
+	return hex.EncodeToString(mac.Sum(nil))
+}
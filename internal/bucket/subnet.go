@@ -0,0 +1,135 @@
+package bucket
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SubnetPrefix starts the CheckAll verdict keys of subnet buckets, which are
+// followed by the name of the subnet, e.g. "subnet:ipv4/24".
+const SubnetPrefix = "subnet:"
+
+// Subnet aggregates the addresses sharing their first Prefix bits into one
+// bucket, so an attacker rotating through the addresses of a network does
+// not get a fresh budget with each of them. A subnet applies to IPv6
+// addresses when IPv6 is set and to IPv4 addresses otherwise.
+type Subnet struct {
+	IPv6   bool
+	Prefix int
+	Config Config
+}
+
+// Name is how the subnet is configured and reported, e.g. "ipv6/64".
+func (s Subnet) Name() string {
+	if s.IPv6 {
+		return "ipv6/" + strconv.Itoa(s.Prefix)
+	}
+	return "ipv4/" + strconv.Itoa(s.Prefix)
+}
+
+// Validate checks the prefix against the address family and the config
+// against its algorithm.
+func (s Subnet) Validate() error {
+	bits := 32
+	if s.IPv6 {
+		bits = 128
+	}
+	if s.Prefix < 1 || s.Prefix > bits {
+		return fmt.Errorf("%s: prefix must be between 1 and %d", s.Name(), bits)
+	}
+	if err := s.Config.Validate(); err != nil {
+		return fmt.Errorf("%s: %w", s.Name(), err)
+	}
+	return nil
+}
+
+// network returns the network of ip the subnet aggregates, nil when ip is
+// of the other family.
+func (s Subnet) network(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		if s.IPv6 {
+			return nil
+		}
+		mask := net.CIDRMask(s.Prefix, 32)
+		return &net.IPNet{IP: ip4.Mask(mask), Mask: mask}
+	}
+	if !s.IPv6 || ip.To16() == nil {
+		return nil
+	}
+	mask := net.CIDRMask(s.Prefix, 128)
+	return &net.IPNet{IP: ip.To16().Mask(mask), Mask: mask}
+}
+
+// ParseSubnets parses a comma-separated list of subnets, each written
+//
+//	FAMILY/PREFIX=CAPACITY[:REFILL[:ALGORITHM[:WINDOW]]]
+//
+// as in "ipv4/24=100:100,ipv6/64=100:100,ipv6/48=500:500:gcra". REFILL
+// defaults to CAPACITY. An empty string means no subnets.
+func ParseSubnets(s string) ([]Subnet, error) {
+	var subnets []Subnet
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		sn, err := parseSubnet(field)
+		if err != nil {
+			return nil, err
+		}
+		if seen[sn.Name()] {
+			return nil, fmt.Errorf("subnet %s listed twice", sn.Name())
+		}
+		seen[sn.Name()] = true
+		subnets = append(subnets, sn)
+	}
+	return subnets, nil
+}
+
+func parseSubnet(field string) (Subnet, error) {
+	name, spec, ok := strings.Cut(field, "=")
+	if !ok {
+		return Subnet{}, fmt.Errorf("subnet %q is not FAMILY/PREFIX=CAPACITY[:REFILL[:ALGORITHM[:WINDOW]]]", field)
+	}
+	family, prefix, _ := strings.Cut(name, "/")
+	var sn Subnet
+	switch family {
+	case "ipv4":
+	case "ipv6":
+		sn.IPv6 = true
+	default:
+		return Subnet{}, fmt.Errorf("subnet %q: unknown family %q, expected ipv4 or ipv6", field, family)
+	}
+	var err error
+	if sn.Prefix, err = strconv.Atoi(prefix); err != nil {
+		return Subnet{}, fmt.Errorf("subnet %q: invalid prefix %q", field, prefix)
+	}
+	parts := strings.Split(spec, ":")
+	if len(parts) > 4 {
+		return Subnet{}, fmt.Errorf("subnet %q: too many fields", field)
+	}
+	if sn.Config.Capacity, err = strconv.Atoi(parts[0]); err != nil {
+		return Subnet{}, fmt.Errorf("subnet %q: invalid capacity %q", field, parts[0])
+	}
+	sn.Config.RefillPerMinute = sn.Config.Capacity
+	if len(parts) > 1 && parts[1] != "" {
+		if sn.Config.RefillPerMinute, err = strconv.Atoi(parts[1]); err != nil {
+			return Subnet{}, fmt.Errorf("subnet %q: invalid refill %q", field, parts[1])
+		}
+	}
+	if len(parts) > 2 {
+		sn.Config.Algorithm = Algorithm(parts[2])
+	}
+	if len(parts) > 3 && parts[3] != "" {
+		if sn.Config.Window, err = time.ParseDuration(parts[3]); err != nil {
+			return Subnet{}, fmt.Errorf("subnet %q: invalid window %q", field, parts[3])
+		}
+	}
+	if err := sn.Validate(); err != nil {
+		return Subnet{}, err
+	}
+	return sn, nil
+}
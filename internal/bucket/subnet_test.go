@@ -0,0 +1,135 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseSubnets(t *testing.T) {
+	subnets, err := ParseSubnets(" ipv4/24=100:50, ipv6/64=20,ipv6/48=500:500:fixed_window:10m ")
+	if err != nil {
+		t.Fatalf("ParseSubnets: %v", err)
+	}
+	want := []Subnet{
+		{Prefix: 24, Config: Config{Capacity: 100, RefillPerMinute: 50}},
+		{IPv6: true, Prefix: 64, Config: Config{Capacity: 20, RefillPerMinute: 20}},
+		{IPv6: true, Prefix: 48, Config: Config{Capacity: 500, RefillPerMinute: 500, Algorithm: FixedWindow, Window: 10 * time.Minute}},
+	}
+	if len(subnets) != len(want) {
+		t.Fatalf("expected %d subnets, got %+v", len(want), subnets)
+	}
+	for i := range want {
+		if subnets[i] != want[i] {
+			t.Fatalf("subnet %d: expected %+v, got %+v", i, want[i], subnets[i])
+		}
+	}
+	if subnets, err := ParseSubnets(""); err != nil || subnets != nil {
+		t.Fatalf("expected no subnets for an empty string, got %+v, %v", subnets, err)
+	}
+	for _, s := range []string{
+		"ipv4/24",
+		"ipv5/24=1",
+		"ipv4/33=1",
+		"ipv6/0=1",
+		"ipv4/24=0",
+		"ipv4/24=10:x",
+		"ipv4/24=10:1:gcra:1m:extra",
+		"ipv4/24=10:0:gcra",
+		"ipv4/24=10:0",
+		"ipv4/24=1,ipv4/24=2",
+	} {
+		if _, err := ParseSubnets(s); err == nil {
+			t.Fatalf("expected %q to be rejected", s)
+		}
+	}
+}
+
+func TestSubnetBuckets(t *testing.T) {
+	ctx := context.Background()
+	large := Config{Capacity: 100, RefillPerMinute: 1}
+	m, _ := newTestMemoryRL(t, large, large, large)
+	subnets, err := ParseSubnets("ipv4/24=2,ipv6/64=3,ipv6/48=4")
+	if err != nil {
+		t.Fatalf("ParseSubnets: %v", err)
+	}
+	if err := m.SetLimits(large, large, large, subnets, ConsumeEach); err != nil {
+		t.Fatalf("SetLimits: %v", err)
+	}
+
+	// Rotating through the /24 shares one bucket.
+	for _, ip := range []string{"203.0.113.1", "203.0.113.2"} {
+		if ok, res, _ := m.CheckAll(ctx, "u"+ip, "pw"+ip, ip); !ok {
+			t.Fatalf("%s: expected the subnet to allow it, got %v", ip, res)
+		}
+	}
+	ok, res, _ := m.CheckAll(ctx, "u3", "pw3", "203.0.113.3")
+	if ok || res["subnet:ipv4/24"] || !res["ip"] {
+		t.Fatalf("expected the /24 bucket to deny a third address, got %v", res)
+	}
+	if _, found := res["subnet:ipv6/64"]; found {
+		t.Fatalf("expected IPv6 subnets left out for an IPv4 address, got %v", res)
+	}
+	if ok, res, _ := m.CheckAll(ctx, "u4", "pw4", "203.0.114.1"); !ok {
+		t.Fatalf("expected another /24 to have its own bucket, got %v", res)
+	}
+
+	// The /64 runs out after 3, the /48 holding it after 4:
+	// ConsumeEach still charges the buckets that allow.
+	for _, ip := range []string{"2001:db8:0:1::1", "2001:db8:0:1::2", "2001:db8:0:1::3"} {
+		if ok, res, _ := m.CheckAll(ctx, "v", "pw", ip); !ok {
+			t.Fatalf("%s: expected it allowed, got %v", ip, res)
+		}
+	}
+	if _, res, _ := m.CheckAll(ctx, "v", "pw", "2001:db8:0:1::4"); res["subnet:ipv6/64"] || !res["subnet:ipv6/48"] {
+		t.Fatalf("expected only the /64 to deny, got %v", res)
+	}
+	if _, res, _ := m.CheckAll(ctx, "v", "pw", "2001:db8:0:2::1"); !res["subnet:ipv6/64"] || res["subnet:ipv6/48"] {
+		t.Fatalf("expected a fresh /64 inside the exhausted /48, got %v", res)
+	}
+
+	if err := m.ResetIP(ctx, "203.0.113.3"); err != nil {
+		t.Fatalf("ResetIP: %v", err)
+	}
+	if ok, res, _ := m.CheckAll(ctx, "u5", "pw5", "203.0.113.3"); !ok {
+		t.Fatalf("expected ResetIP to reset the subnets of the address too, got %v", res)
+	}
+}
+
+func TestSubnetBucketsSkipOverrides(t *testing.T) {
+	ctx := context.Background()
+	large := Config{Capacity: 100, RefillPerMinute: 1}
+	m, _ := newTestMemoryRL(t, large, large, large)
+	subnets, err := ParseSubnets("ipv4/24=2")
+	if err != nil {
+		t.Fatalf("ParseSubnets: %v", err)
+	}
+	if err := m.SetLimits(large, large, large, subnets, ConsumeEach); err != nil {
+		t.Fatalf("SetLimits: %v", err)
+	}
+	m.SetOverrides(fixedOverrides{ips: map[string]Config{"203.0.113.1": {Capacity: 5, RefillPerMinute: 1}}})
+
+	// The office NAT address is held to its override, not to the /24.
+	for i := range 5 {
+		if ok, res, _ := m.CheckAll(ctx, fmt.Sprint("u", i), "pw", "203.0.113.1"); !ok {
+			t.Fatalf("attempt %d: expected the override to allow it, got %v", i+1, res)
+		}
+	}
+	ok, res, _ := m.CheckAll(ctx, "u5", "pw", "203.0.113.1")
+	if ok || res["ip"] {
+		t.Fatalf("expected the override to run out after 5, got %v", res)
+	}
+	if _, found := res["subnet:ipv4/24"]; found {
+		t.Fatalf("expected the /24 left out for an overridden address, got %v", res)
+	}
+	// Its neighbours still share the untouched /24 bucket.
+	for _, ip := range []string{"203.0.113.2", "203.0.113.3"} {
+		if ok, res, _ := m.CheckAll(ctx, "u"+ip, "pw", ip); !ok {
+			t.Fatalf("%s: expected the subnet to allow it, got %v", ip, res)
+		}
+	}
+	if ok, res, _ := m.CheckAll(ctx, "u4", "pw", "203.0.113.4"); ok || res["subnet:ipv4/24"] {
+		t.Fatalf("expected the /24 bucket to deny a third neighbour, got %v", res)
+	}
+}
@@ -0,0 +1,54 @@
+package bucket
+
+import (
+	"context"
+	"strings"
+
+	"github.com/meladark/special-train/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = tracing.Tracer("internal/bucket")
+
+// startTake opens the span of a take call. All checks of a call run in one
+// atomic step, so each check is an event on the span rather than a span of
+// its own.
+func startTake(ctx context.Context, backend string, mode ConsumeMode, checks []bucketCheck) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "bucket.take", trace.WithAttributes(
+		attribute.String("bucket.backend", backend),
+		attribute.String("bucket.consume_mode", string(mode)),
+		attribute.Int("bucket.checks", len(checks)),
+	))
+}
+
+// endTake records the outcome of every check and ends span. Keys are reduced
+// to their dimension because they hold logins and IPs.
+func endTake(span trace.Span, checks []bucketCheck, results []bucketResult, err error) {
+	defer span.End()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	for i, r := range results {
+		span.AddEvent("bucket.check", trace.WithAttributes(
+			attribute.String("bucket.dimension", dimension(checks[i].key)),
+			attribute.String("bucket.algorithm", string(checks[i].cfg.algorithm())),
+			attribute.Int("bucket.requested", checks[i].requested),
+			attribute.Bool("bucket.allowed", r.allowed),
+			attribute.Float64("bucket.remaining", r.remaining),
+		))
+	}
+}
+
+// dimension returns "login" for "bf:login:alice" and "custom" for keys
+// outside the bucket namespace.
+func dimension(key string) string {
+	parts := strings.SplitN(key, ":", 3)
+	if len(parts) < 3 || parts[0] != "bf" {
+		return "custom"
+	}
+	return parts[1]
+}
@@ -0,0 +1,140 @@
+package gateway
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/meladark/special-train/internal/service"
+)
+
+// Source is where credentials are looked up first.
+type Source string
+
+const (
+	// SourceBody prefers the fields of a form or JSON body, which is what the
+	// upstream reads, and only falls back to the headers for a missing field.
+	SourceBody Source = "body"
+	// SourceHeaders prefers the headers, for proxies that set them from the
+	// credentials themselves and overwrite whatever the client sent.
+	SourceHeaders Source = "headers"
+)
+
+// ParseSource reads a credential source name.
+func ParseSource(s string) (Source, error) {
+	switch Source(s) {
+	case SourceBody, SourceHeaders:
+		return Source(s), nil
+	default:
+		return "", fmt.Errorf("unknown credential source %q, want body or headers", s)
+	}
+}
+
+// Config tells where a proxied login request carries its credentials. Prefer
+// decides whether the body fields or the headers win when both are present,
+// the other one only fills in missing values; the zero value prefers the body.
+//
+// The client IP comes from IPHeader, falling back to the peer address. Every
+// proxy in front appends to list headers such as X-Forwarded-For, so the
+// address is the one TrustedHops entries from the right, the one added by the
+// outermost trusted proxy; entries further left are the client's own.
+// TrustedHops below one counts as one.
+type Config struct {
+	Prefer         Source
+	LoginHeader    string
+	PasswordHeader string
+	IPHeader       string
+	TrustedHops    int
+	LoginField     string
+	PasswordField  string
+}
+
+// Extract builds the authorize request for a proxied login. get returns a
+// request header, body is the raw request body, if the proxy forwards it.
+func (c Config) Extract(get func(string) string, contentType, body, peer string) service.AuthorizeRequest {
+	fields := bodyFields(contentType, body)
+	lookup := func(header, field string) string {
+		fromHeader, fromBody := "", ""
+		if header != "" {
+			fromHeader = get(header)
+		}
+		if field != "" {
+			fromBody = fields(field)
+		}
+		if c.Prefer == SourceHeaders {
+			return cmp.Or(fromHeader, fromBody)
+		}
+		return cmp.Or(fromBody, fromHeader)
+	}
+	return service.AuthorizeRequest{
+		Login:    lookup(c.LoginHeader, c.LoginField),
+		Password: lookup(c.PasswordHeader, c.PasswordField),
+		IP:       c.clientIP(get, peer),
+	}
+}
+
+// bodyFields returns a lookup of the top-level string fields of a form or
+// JSON body. Other bodies have no fields.
+func bodyFields(contentType, body string) func(string) string {
+	none := func(string) string { return "" }
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || body == "" {
+		return none
+	}
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		form, err := url.ParseQuery(body)
+		if err != nil {
+			return none
+		}
+		return form.Get
+	case "application/json":
+		var obj map[string]any
+		if err := json.Unmarshal([]byte(body), &obj); err != nil {
+			return none
+		}
+		return func(field string) string {
+			v, _ := obj[field].(string)
+			return v
+		}
+	default:
+		return none
+	}
+}
+
+func (c Config) clientIP(get func(string) string, peer string) string {
+	if c.IPHeader != "" {
+		if v := get(c.IPHeader); v != "" {
+			hops := strings.Split(v, ",")
+			// A list shorter than the trusted hops was written by proxies
+			// only, its leftmost entry is as good as any.
+			i := max(len(hops)-max(c.TrustedHops, 1), 0)
+			if ip := strings.TrimSpace(hops[i]); ip != "" {
+				return ip
+			}
+		}
+	}
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		return host
+	}
+	return peer
+}
+
+// Status maps an authorize decision to the HTTP status the proxy should
+// answer with: 200 to let the request through, 429 when a bucket ran out and
+// 403 for every other denial.
+func Status(resp service.AuthorizeResponse) int {
+	switch {
+	case resp.Ok:
+		return http.StatusOK
+	case resp.Reason == service.ReasonRateLimited, resp.Reason == service.ReasonSubnetRateLimited:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusForbidden
+	}
+}
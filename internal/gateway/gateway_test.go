@@ -0,0 +1,131 @@
+package gateway
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/meladark/special-train/internal/service"
+)
+
+var testConfig = Config{
+	LoginHeader:    "X-Login",
+	PasswordHeader: "X-Password",
+	IPHeader:       "X-Forwarded-For",
+	LoginField:     "username",
+	PasswordField:  "pass",
+}
+
+func TestExtract(t *testing.T) {
+	const form = "application/x-www-form-urlencoded; charset=utf-8"
+	tests := []struct {
+		name        string
+		headers     map[string]string
+		contentType string
+		body        string
+		peer        string
+		expect      service.AuthorizeRequest
+	}{
+		{
+			name:    "headers",
+			headers: map[string]string{"X-Login": "alice", "X-Password": "pw", "X-Forwarded-For": "192.0.2.1"},
+			peer:    "10.0.0.1:4567",
+			expect:  service.AuthorizeRequest{Login: "alice", Password: "pw", IP: "192.0.2.1"},
+		},
+		{
+			name:        "form body and peer address",
+			contentType: form,
+			body:        "username=bob&pass=s%3Dcret",
+			peer:        "[2001:db8::1]:4567",
+			expect:      service.AuthorizeRequest{Login: "bob", Password: "s=cret", IP: "2001:db8::1"},
+		},
+		{
+			name:        "form wins over header",
+			headers:     map[string]string{"X-Login": "mallory", "X-Password": "pw"},
+			contentType: form,
+			body:        "username=carol",
+			peer:        "192.0.2.2",
+			expect:      service.AuthorizeRequest{Login: "carol", Password: "pw", IP: "192.0.2.2"},
+		},
+		{
+			name:        "json body",
+			contentType: "application/json",
+			body:        `{"username":"dave","pass":"pw","remember":true}`,
+			peer:        "192.0.2.3:80",
+			expect:      service.AuthorizeRequest{Login: "dave", Password: "pw", IP: "192.0.2.3"},
+		},
+		{
+			name:        "other bodies are ignored",
+			contentType: "text/plain",
+			body:        "username=erin",
+			peer:        "192.0.2.4:80",
+			expect:      service.AuthorizeRequest{IP: "192.0.2.4"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.headers {
+				h.Set(k, v)
+			}
+			if got := testConfig.Extract(h.Get, tt.contentType, tt.body, tt.peer); got != tt.expect {
+				t.Fatalf("expected %+v, got %+v", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestExtractPreferHeaders(t *testing.T) {
+	cfg := testConfig
+	cfg.Prefer = SourceHeaders
+	h := http.Header{}
+	h.Set("X-Login", "carol")
+	got := cfg.Extract(h.Get, "application/x-www-form-urlencoded", "username=mallory&pass=pw", "192.0.2.2:80")
+	if expect := (service.AuthorizeRequest{Login: "carol", Password: "pw", IP: "192.0.2.2"}); got != expect {
+		t.Fatalf("expected %+v, got %+v", expect, got)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name   string
+		hops   int
+		header string
+		expect string
+	}{
+		{name: "single hop takes the rightmost entry", hops: 1, header: "203.0.113.9, 192.0.2.1", expect: "192.0.2.1"},
+		{name: "zero hops counts as one", header: "203.0.113.9, 192.0.2.1", expect: "192.0.2.1"},
+		{name: "two hops skip the inner proxy", hops: 2, header: "203.0.113.9, 192.0.2.1, 10.0.0.2", expect: "192.0.2.1"},
+		{name: "short list takes the leftmost entry", hops: 3, header: "192.0.2.1, 10.0.0.2", expect: "192.0.2.1"},
+		{name: "empty entry falls back to the peer", hops: 1, header: "192.0.2.1, ", expect: "10.0.0.1"},
+		{name: "no header falls back to the peer", hops: 1, expect: "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{IPHeader: "X-Forwarded-For", TrustedHops: tt.hops}
+			h := http.Header{}
+			if tt.header != "" {
+				h.Set("X-Forwarded-For", tt.header)
+			}
+			if got := cfg.Extract(h.Get, "", "", "10.0.0.1:4567").IP; got != tt.expect {
+				t.Fatalf("expected %s, got %s", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		resp   service.AuthorizeResponse
+		expect int
+	}{
+		{service.AuthorizeResponse{Ok: true, Mode: service.ModeNormal}, http.StatusOK},
+		{service.AuthorizeResponse{Reason: service.ReasonRateLimited, Mode: service.ModeLocal}, http.StatusTooManyRequests},
+		{service.AuthorizeResponse{Reason: service.ReasonBlacklisted}, http.StatusForbidden},
+		{service.AuthorizeResponse{Reason: service.ReasonUnavailable, Mode: service.ModeFailClosed}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if got := Status(tt.resp); got != tt.expect {
+			t.Errorf("Status(%+v) = %d, want %d", tt.resp, got, tt.expect)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Redacted replaces the value of secret attributes.
+const Redacted = "[REDACTED]"
+
+// secretKeys are attribute keys whose values are never written, whatever
+// group they are in.
+var secretKeys = map[string]bool{
+	"password":      true,
+	"pass":          true,
+	"secret":        true,
+	"token":         true,
+	"api_key":       true,
+	"authorization": true,
+}
+
+// Config selects the output of New. Level is debug, info, warn or error and
+// Format json or text. With RedactLogins, login attributes are replaced by a
+// hash that still lets lines of the same login be matched. When LevelVar is
+// set, New stores Level in it and the logger follows its later changes.
+type Config struct {
+	Level        string
+	Format       string
+	RedactLogins bool
+	LevelVar     *slog.LevelVar
+}
+
+// ParseLevel parses debug, info, warn or error.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+// New builds a logger writing to w that redacts secrets and adds the request
+// ID of the context to every record.
+func New(w io.Writer, cfg Config) (*slog.Logger, error) {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+	var leveler slog.Leveler = level
+	if cfg.LevelVar != nil {
+		cfg.LevelVar.Set(level)
+		leveler = cfg.LevelVar
+	}
+	opts := &slog.HandlerOptions{Level: leveler, ReplaceAttr: redact(cfg.RedactLogins)}
+	var h slog.Handler
+	switch cfg.Format {
+	case "json", "":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected json or text", cfg.Format)
+	}
+	return slog.New(contextHandler{h}), nil
+}
+
+func redact(logins bool) func([]string, slog.Attr) slog.Attr {
+	return func(_ []string, a slog.Attr) slog.Attr {
+		switch key := strings.ToLower(a.Key); {
+		case secretKeys[key]:
+			return slog.String(a.Key, Redacted)
+		case logins && key == "login":
+			return slog.String(a.Key, HashLogin(a.Value.String()))
+		}
+		return a
+	}
+}
+
+// HashLogin returns a short stable stand-in for login.
+func HashLogin(login string) string {
+	h := sha256.Sum256([]byte(login))
+	return "sha256:" + hex.EncodeToString(h[:6])
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context whose log records carry id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID stored in ctx, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a random 16 character hex ID.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ValidRequestID reports whether a client supplied ID is safe to log: short
+// and made of letters, digits, dots, dashes and underscores only.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// contextHandler adds the request ID of the context to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
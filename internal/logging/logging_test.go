@@ -0,0 +1,116 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestLogger(t *testing.T, cfg Config) (*slog.Logger, *bytes.Buffer) {
+	t.Helper()
+	var buf bytes.Buffer
+	logger, err := New(&buf, cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return logger, &buf
+}
+
+func TestRedaction(t *testing.T) {
+	logger, buf := newTestLogger(t, Config{Level: "debug", RedactLogins: true})
+	logger.Debug("attempt",
+		"login", "alice",
+		"Password", "hunter2",
+		slog.Group("request", "password", "hunter2", "ip", "192.0.2.1"),
+	)
+	out := buf.String()
+	if strings.Contains(out, "hunter2") || strings.Contains(out, "alice") {
+		t.Fatalf("expected secrets to be redacted, got %s", out)
+	}
+	var line struct {
+		Login    string            `json:"login"`
+		Password string            `json:"Password"`
+		Request  map[string]string `json:"request"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected a JSON line, got %q: %v", out, err)
+	}
+	if line.Login != HashLogin("alice") || line.Password != Redacted || line.Request["password"] != Redacted {
+		t.Fatalf("unexpected redaction: %+v", line)
+	}
+	if line.Request["ip"] != "192.0.2.1" {
+		t.Fatalf("expected other attributes untouched, got %+v", line.Request)
+	}
+
+	logger, buf = newTestLogger(t, Config{Level: "info"})
+	logger.Info("attempt", "login", "alice")
+	if !strings.Contains(buf.String(), `"login":"alice"`) {
+		t.Fatalf("expected logins in clear by default, got %s", buf.String())
+	}
+}
+
+func TestLevelAndFormat(t *testing.T) {
+	logger, buf := newTestLogger(t, Config{Level: "warn", Format: "text"})
+	logger.Info("hidden")
+	logger.Warn("shown")
+	if out := buf.String(); strings.Contains(out, "hidden") || !strings.Contains(out, "msg=shown") {
+		t.Fatalf("expected only the warning in text format, got %q", out)
+	}
+	if _, err := New(&bytes.Buffer{}, Config{Level: "loud"}); err == nil {
+		t.Fatal("expected an error for an unknown level")
+	}
+	if _, err := New(&bytes.Buffer{}, Config{Level: "info", Format: "xml"}); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+
+	level := new(slog.LevelVar)
+	logger, buf = newTestLogger(t, Config{Level: "warn", LevelVar: level})
+	level.Set(slog.LevelDebug)
+	logger.Debug("after the change")
+	if !strings.Contains(buf.String(), "after the change") {
+		t.Fatalf("expected the logger to follow its level var, got %q", buf.String())
+	}
+}
+
+func TestMiddlewareRequestID(t *testing.T) {
+	logger, buf := newTestLogger(t, Config{Level: "info"})
+	prev := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	var seen string
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r.Context())
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	serve := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/x", nil)
+		if id != "" {
+			req.Header.Set(RequestIDHeader, id)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := serve("abc-123"); seen != "abc-123" || rec.Header().Get(RequestIDHeader) != "abc-123" {
+		t.Fatalf("expected the client ID to be kept, got %q and %q", seen, rec.Header().Get(RequestIDHeader))
+	}
+	if !strings.Contains(buf.String(), `"request_id":"abc-123"`) || !strings.Contains(buf.String(), `"status":418`) {
+		t.Fatalf("expected the request logged with its ID and status, got %s", buf.String())
+	}
+	if serve("bad id\n"); seen == "bad id\n" || !ValidRequestID(seen) {
+		t.Fatalf("expected an unsafe ID to be replaced, got %q", seen)
+	}
+	if serve(""); len(seen) != 16 {
+		t.Fatalf("expected a generated ID, got %q", seen)
+	}
+	logger.InfoContext(context.Background(), "no request")
+	if strings.Contains(buf.String()[strings.LastIndex(buf.String(), "no request"):], "request_id") {
+		t.Fatal("expected no request_id outside a request")
+	}
+}
@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "antibruteforce"
+
+// The collectors below live in the default registry and are served by Handler.
+var (
+	// Decisions counts authorize answers by outcome (allowed, denied or
+	// error), reason and failure mode.
+	Decisions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "authorize_decisions_total",
+		Help:      "Authorize decisions by outcome, reason and mode.",
+	}, []string{"outcome", "reason", "mode"})
+
+	// RateLimited counts denied buckets by dimension: login, password, ip or
+	// the subnet, e.g. subnet:ipv4/24.
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Buckets that denied an attempt, by dimension.",
+	}, []string{"dimension"})
+
+	RedisDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "redis_request_duration_seconds",
+		Help:      "Latency of the rate limiter's Redis calls, by operation.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"op"})
+
+	RedisErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redis_errors_total",
+		Help:      "Failed Redis calls of the rate limiter, by operation.",
+	}, []string{"op"})
+
+	// WatchRetries counts list updates retried because another client
+	// changed a watched key first.
+	WatchRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "storage_watch_retries_total",
+		Help:      "Optimistic transaction retries of the Redis storage, by list.",
+	}, []string{"list"})
+
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests, by route, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+)
+
+var listEntries = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "list_entries"),
+	"Entries in the whitelist and blacklist.",
+	[]string{"list"}, nil,
+)
+
+// Handler serves the default registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveRedis records a Redis call of op that started at start.
+func ObserveRedis(op string, start time.Time, err error) {
+	RedisDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if err != nil {
+		RedisErrors.WithLabelValues(op).Inc()
+	}
+}
+
+// ObserveHTTP records a request to route answered with code.
+func ObserveHTTP(route, method string, code int, start time.Time) {
+	HTTPDuration.WithLabelValues(route, method, strconv.Itoa(code)).Observe(time.Since(start).Seconds())
+}
+
+// listSizes asks for the list sizes at scrape time, so they are never stale
+// even when another instance changed the lists. When the lists cannot be
+// read the sizes are left out of the scrape rather than reported as zero.
+type listSizes func() (whitelist, blacklist int, err error)
+
+func (listSizes) Describe(ch chan<- *prometheus.Desc) {
+	ch <- listEntries
+}
+
+func (f listSizes) Collect(ch chan<- prometheus.Metric) {
+	whitelist, blacklist, err := f()
+	if err != nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(listEntries, prometheus.GaugeValue, float64(whitelist), "whitelist")
+	ch <- prometheus.MustNewConstMetric(listEntries, prometheus.GaugeValue, float64(blacklist), "blacklist")
+}
+
+// RegisterListSizes exports the list sizes reported by sizes.
+func RegisterListSizes(sizes func() (whitelist, blacklist int, err error)) error {
+	return prometheus.Register(listSizes(sizes))
+}
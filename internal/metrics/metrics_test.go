@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestListSizes(t *testing.T) {
+	whitelist, blacklist := 1, 2
+	var err error
+	sizes := listSizes(func() (int, int, error) { return whitelist, blacklist, err })
+	expected := `
+# HELP antibruteforce_list_entries Entries in the whitelist and blacklist.
+# TYPE antibruteforce_list_entries gauge
+antibruteforce_list_entries{list="blacklist"} %d
+antibruteforce_list_entries{list="whitelist"} %d
+`
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(sizes)
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(fmt.Sprintf(expected, 2, 1))); err != nil {
+		t.Fatalf("unexpected metrics: %v", err)
+	}
+	whitelist, blacklist = 0, 5
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(fmt.Sprintf(expected, 5, 0))); err != nil {
+		t.Fatalf("expected sizes read at scrape time: %v", err)
+	}
+	err = errors.New("redis down")
+	if n, gatherErr := testutil.GatherAndCount(reg); gatherErr != nil || n != 0 {
+		t.Fatalf("expected the sizes left out when the lists cannot be read, got %d, %v", n, gatherErr)
+	}
+}
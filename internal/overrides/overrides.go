@@ -0,0 +1,290 @@
+package overrides
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/meladark/special-train/internal/bucket"
+	"github.com/meladark/special-train/pkg/netutils"
+)
+
+// Kind says what an override matches.
+type Kind string
+
+const (
+	// KindLogin overrides the login bucket of one login.
+	KindLogin Kind = "login"
+	// KindCIDR overrides the IP buckets of every address in a network.
+	KindCIDR Kind = "cidr"
+)
+
+// ErrInvalid is wrapped by the errors of overrides that cannot be applied.
+var ErrInvalid = errors.New("invalid override")
+
+// Override gives the login or the addresses it matches a bucket config of
+// their own, used instead of the default one.
+type Override struct {
+	Kind   Kind
+	Match  string
+	Config bucket.Config
+}
+
+// wire is the JSON form of an Override, with the window as a duration string.
+type wire struct {
+	Kind            Kind             `json:"kind"`
+	Match           string           `json:"match"`
+	Algorithm       bucket.Algorithm `json:"algorithm,omitempty"`
+	Capacity        int              `json:"capacity"`
+	RefillPerMinute int              `json:"refill_per_minute"`
+	Window          string           `json:"window,omitempty"`
+}
+
+func (o Override) MarshalJSON() ([]byte, error) {
+	w := wire{
+		Kind: o.Kind, Match: o.Match, Algorithm: o.Config.Algorithm,
+		Capacity: o.Config.Capacity, RefillPerMinute: o.Config.RefillPerMinute,
+	}
+	if o.Config.Window != 0 {
+		w.Window = o.Config.Window.String()
+	}
+	return json.Marshal(w)
+}
+
+func (o *Override) UnmarshalJSON(data []byte) error {
+	var w wire
+	if err := json.Unmarshal(data, &w); err != nil {
+		return err
+	}
+	var window time.Duration
+	if w.Window != "" {
+		var err error
+		if window, err = time.ParseDuration(w.Window); err != nil {
+			return fmt.Errorf("%w: window: %v", ErrInvalid, err)
+		}
+	}
+	*o = Override{Kind: w.Kind, Match: w.Match, Config: bucket.Config{
+		Capacity: w.Capacity, RefillPerMinute: w.RefillPerMinute,
+		Algorithm: w.Algorithm, Window: window,
+	}}
+	return nil
+}
+
+// normalizeMatch checks match for kind and returns the form overrides are
+// stored under: the login as is, the network in CIDR notation.
+func normalizeMatch(kind Kind, match string) (string, error) {
+	switch kind {
+	case KindLogin:
+		if match == "" {
+			return "", fmt.Errorf("%w: empty login", ErrInvalid)
+		}
+		return match, nil
+	case KindCIDR:
+		ipnet, err := netutils.ParseNet(match)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		return ipnet.String(), nil
+	}
+	return "", fmt.Errorf("%w: unknown kind %q, expected %s or %s", ErrInvalid, kind, KindLogin, KindCIDR)
+}
+
+// normalize validates o and returns it in its stored form.
+func (o Override) normalize() (Override, error) {
+	match, err := normalizeMatch(o.Kind, o.Match)
+	if err != nil {
+		return Override{}, err
+	}
+	if err := o.Config.Validate(); err != nil {
+		return Override{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	o.Match = match
+	return o, nil
+}
+
+// key identifies an override in a Store.
+func key(kind Kind, match string) string {
+	return string(kind) + ":" + match
+}
+
+// Store keeps the overrides durably. Put replaces the override of the same
+// kind and match, Delete reports whether there was one.
+type Store interface {
+	List(ctx context.Context) ([]Override, error)
+	Put(ctx context.Context, o Override) error
+	Delete(ctx context.Context, kind Kind, match string) (bool, error)
+}
+
+// snapshot is what lookups read, rebuilt from the store on every change.
+// Networks are sorted from the longest prefix down, so the first match is
+// the most specific one.
+type snapshot struct {
+	all    []Override
+	logins map[string]bucket.Config
+	nets   []network
+}
+
+type network struct {
+	ipnet *net.IPNet
+	cfg   bucket.Config
+}
+
+func newSnapshot(list []Override) *snapshot {
+	s := &snapshot{logins: make(map[string]bucket.Config)}
+	for _, o := range list {
+		o, err := o.normalize()
+		if err != nil {
+			slog.Warn("skipping invalid rate-limit override", "err", err)
+			continue
+		}
+		s.all = append(s.all, o)
+		switch o.Kind {
+		case KindLogin:
+			s.logins[o.Match] = o.Config
+		case KindCIDR:
+			_, ipnet, _ := net.ParseCIDR(o.Match)
+			s.nets = append(s.nets, network{ipnet: ipnet, cfg: o.Config})
+		}
+	}
+	slices.SortFunc(s.all, func(a, b Override) int {
+		return strings.Compare(key(a.Kind, a.Match), key(b.Kind, b.Match))
+	})
+	slices.SortStableFunc(s.nets, func(a, b network) int {
+		ones, _ := a.ipnet.Mask.Size()
+		other, _ := b.ipnet.Mask.Size()
+		return other - ones
+	})
+	return s
+}
+
+// Table serves the overrides of a Store to the rate limiters. Lookups read
+// an in-process copy, which every change made through the Table refreshes
+// and Watch keeps in step with changes made by other replicas. A nil Table
+// overrides nothing.
+type Table struct {
+	store Store
+	snap  atomic.Pointer[snapshot]
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// NewTable returns an empty table for store, Load fills it.
+func NewTable(store Store) *Table {
+	t := &Table{store: store, stop: make(chan struct{})}
+	t.snap.Store(newSnapshot(nil))
+	return t
+}
+
+// Load reads every override from the store again.
+func (t *Table) Load(ctx context.Context) error {
+	list, err := t.store.List(ctx)
+	if err != nil {
+		return fmt.Errorf("overrides: %w", err)
+	}
+	t.snap.Store(newSnapshot(list))
+	return nil
+}
+
+// Watch reloads the table every interval until Close. A failed reload keeps
+// the overrides loaded before.
+func (t *Table) Watch(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+				if err := t.Load(context.Background()); err != nil {
+					slog.Warn("failed to reload rate-limit overrides", "err", err)
+				}
+			}
+		}
+	}()
+}
+
+// Close stops Watch.
+func (t *Table) Close() {
+	t.once.Do(func() { close(t.stop) })
+}
+
+// Put validates o and stores it, replacing the override of the same kind and
+// match.
+func (t *Table) Put(ctx context.Context, o Override) error {
+	if t == nil {
+		return errors.New("overrides: not configured")
+	}
+	o, err := o.normalize()
+	if err != nil {
+		return err
+	}
+	if err := t.store.Put(ctx, o); err != nil {
+		return fmt.Errorf("overrides: %w", err)
+	}
+	return t.Load(ctx)
+}
+
+// Delete removes the override of kind for match and reports whether there
+// was one.
+func (t *Table) Delete(ctx context.Context, kind Kind, match string) (bool, error) {
+	if t == nil {
+		return false, errors.New("overrides: not configured")
+	}
+	match, err := normalizeMatch(kind, match)
+	if err != nil {
+		return false, err
+	}
+	ok, err := t.store.Delete(ctx, kind, match)
+	if err != nil {
+		return false, fmt.Errorf("overrides: %w", err)
+	}
+	return ok, t.Load(ctx)
+}
+
+// List returns the overrides in force, ordered by kind and match.
+func (t *Table) List() []Override {
+	if t == nil {
+		return nil
+	}
+	return slices.Clone(t.snap.Load().all)
+}
+
+// Login returns the config of the login bucket of login, if it has one.
+func (t *Table) Login(login string) (bucket.Config, bool) {
+	if t == nil {
+		return bucket.Config{}, false
+	}
+	cfg, ok := t.snap.Load().logins[login]
+	return cfg, ok
+}
+
+// IP returns the config of the most specific network holding ip, if any.
+func (t *Table) IP(ip string) (bucket.Config, bool) {
+	if t == nil {
+		return bucket.Config{}, false
+	}
+	nets := t.snap.Load().nets
+	if len(nets) == 0 {
+		return bucket.Config{}, false
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return bucket.Config{}, false
+	}
+	// Overrides are few, a scan beats keeping a trie in step.
+	for _, n := range nets {
+		if n.ipnet.Contains(addr) {
+			return n.cfg, true
+		}
+	}
+	return bucket.Config{}, false
+}
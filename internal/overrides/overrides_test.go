@@ -0,0 +1,143 @@
+package overrides
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/meladark/special-train/internal/bucket"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestTable(t *testing.T) {
+	ctx := context.Background()
+	table := NewTable(NewMemoryStore())
+	wide := bucket.Config{Capacity: 50, RefillPerMinute: 10}
+	narrow := bucket.Config{Capacity: 500, RefillPerMinute: 100}
+	service := bucket.Config{Capacity: 20, RefillPerMinute: 5, Algorithm: bucket.SlidingWindow, Window: time.Hour}
+	for _, o := range []Override{
+		{Kind: KindCIDR, Match: "10.0.0.0/8", Config: wide},
+		{Kind: KindCIDR, Match: "10.1.2.3/16", Config: narrow},
+		{Kind: KindLogin, Match: "backup", Config: service},
+	} {
+		if err := table.Put(ctx, o); err != nil {
+			t.Fatalf("Put %s %s: %v", o.Kind, o.Match, err)
+		}
+	}
+	if cfg, ok := table.IP("10.1.200.1"); !ok || cfg != narrow {
+		t.Fatalf("expected the most specific network to win, got %+v, %v", cfg, ok)
+	}
+	if cfg, ok := table.IP("10.9.0.1"); !ok || cfg != wide {
+		t.Fatalf("expected the /8 for an address outside the /16, got %+v, %v", cfg, ok)
+	}
+	if _, ok := table.IP("192.0.2.1"); ok {
+		t.Fatal("expected no override for an address outside every network")
+	}
+	if cfg, ok := table.Login("backup"); !ok || cfg != service {
+		t.Fatalf("expected the login override, got %+v, %v", cfg, ok)
+	}
+	list := table.List()
+	if len(list) != 3 || list[1].Match != "10.1.0.0/16" {
+		t.Fatalf("expected 3 overrides with networks in CIDR form, got %+v", list)
+	}
+
+	for _, o := range []Override{
+		{Kind: "user", Match: "x", Config: wide},
+		{Kind: KindLogin, Match: "", Config: wide},
+		{Kind: KindCIDR, Match: "10.0.0.0/33", Config: wide},
+		{Kind: KindLogin, Match: "x", Config: bucket.Config{Capacity: 0}},
+		{Kind: KindLogin, Match: "x", Config: bucket.Config{Capacity: 1, Algorithm: bucket.GCRA}},
+	} {
+		if err := table.Put(ctx, o); !errors.Is(err, ErrInvalid) {
+			t.Fatalf("expected %+v to be invalid, got %v", o, err)
+		}
+	}
+
+	if ok, err := table.Delete(ctx, KindCIDR, "10.1.9.9/16"); err != nil || !ok {
+		t.Fatalf("expected the /16 to be removed by any address in it, got %v, %v", ok, err)
+	}
+	if ok, _ := table.Delete(ctx, KindCIDR, "10.1.0.0/16"); ok {
+		t.Fatal("expected a second delete to find nothing")
+	}
+	if cfg, _ := table.IP("10.1.200.1"); cfg != wide {
+		t.Fatalf("expected the /8 once the /16 is gone, got %+v", cfg)
+	}
+}
+
+func TestOverrideJSON(t *testing.T) {
+	var o Override
+	err := json.Unmarshal([]byte(`{"kind":"login","match":"a","capacity":5,"algorithm":"fixed_window","window":"10m"}`), &o)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if o.Config.Window != 10*time.Minute || o.Config.Capacity != 5 {
+		t.Fatalf("expected the window parsed as a duration, got %+v", o)
+	}
+	data, _ := json.Marshal(o)
+	if want := `{"kind":"login","match":"a","algorithm":"fixed_window","capacity":5,"refill_per_minute":0,"window":"10m0s"}`; string(data) != want {
+		t.Fatalf("expected %s, got %s", want, data)
+	}
+	if err := json.Unmarshal([]byte(`{"window":"soon"}`), &o); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected an invalid window to be rejected, got %v", err)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "overrides.json")
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	table := NewTable(store)
+	cfg := bucket.Config{Capacity: 9, RefillPerMinute: 1}
+	if err := table.Put(ctx, Override{Kind: KindCIDR, Match: "198.51.100.7", Config: cfg}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	table = NewTable(reopened)
+	if err := table.Load(ctx); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got, ok := table.IP("198.51.100.7"); !ok || got != cfg {
+		t.Fatalf("expected the override to survive a restart, got %+v, %v", got, ok)
+	}
+}
+
+func TestRedisStoreSharedByReplicas(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	one, other := NewTable(NewRedisStore(rdb)), NewTable(NewRedisStore(rdb))
+	cfg := bucket.Config{Capacity: 40, RefillPerMinute: 40}
+	if err := one.Put(ctx, Override{Kind: KindLogin, Match: "svc:sync", Config: cfg}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, ok := other.Login("svc:sync"); ok {
+		t.Fatal("expected the other replica to see the override only after a reload")
+	}
+	if err := other.Load(ctx); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got, ok := other.Login("svc:sync"); !ok || got != cfg {
+		t.Fatalf("expected the override from Redis, got %+v, %v", got, ok)
+	}
+	if ok, err := other.Delete(ctx, KindLogin, "svc:sync"); err != nil || !ok {
+		t.Fatalf("Delete: %v, %v", ok, err)
+	}
+	if err := one.Load(ctx); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(one.List()) != 0 {
+		t.Fatalf("expected the delete to reach every replica, got %+v", one.List())
+	}
+}
@@ -0,0 +1,156 @@
+package overrides
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// MemoryStore keeps overrides in process and, when it has a file, writes
+// them to it on every change so they survive a restart.
+type MemoryStore struct {
+	mu    sync.Mutex
+	path  string
+	items map[string]Override
+}
+
+// NewMemoryStore keeps overrides for the life of the process only.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{items: make(map[string]Override)}
+}
+
+// NewFileStore loads the overrides saved in path, a JSON array, and saves
+// them there on every change. A missing file holds no overrides.
+func NewFileStore(path string) (*MemoryStore, error) {
+	s := NewMemoryStore()
+	s.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("overrides: %w", err)
+	}
+	var list []Override
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("overrides: %s: %w", path, err)
+	}
+	for _, o := range list {
+		s.items[key(o.Kind, o.Match)] = o
+	}
+	return s, nil
+}
+
+func (s *MemoryStore) List(context.Context) ([]Override, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Collect(maps.Values(s.items)), nil
+}
+
+func (s *MemoryStore) Put(_ context.Context, o Override) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := key(o.Kind, o.Match)
+	old, had := s.items[k]
+	s.items[k] = o
+	if err := s.save(); err != nil {
+		if had {
+			s.items[k] = old
+		} else {
+			delete(s.items, k)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, kind Kind, match string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := key(kind, match)
+	old, ok := s.items[k]
+	if !ok {
+		return false, nil
+	}
+	delete(s.items, k)
+	if err := s.save(); err != nil {
+		s.items[k] = old
+		return false, err
+	}
+	return true, nil
+}
+
+// save writes the overrides to a temporary file renamed over the old one, so
+// a crash never leaves half a file behind.
+func (s *MemoryStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(slices.Collect(maps.Values(s.items)), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// overridesKey is the Redis hash holding every override as JSON, by kind and
+// match. It lives outside the "bf:" namespace so bucket resets never touch it.
+const overridesKey = "overrides"
+
+// RedisStore keeps the overrides in Redis, shared by every replica.
+type RedisStore struct {
+	rdb *redis.Client
+}
+
+func NewRedisStore(rdb *redis.Client) *RedisStore {
+	return &RedisStore{rdb: rdb}
+}
+
+func (s *RedisStore) List(ctx context.Context) ([]Override, error) {
+	raw, err := s.rdb.HGetAll(ctx, overridesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Override, 0, len(raw))
+	for field, data := range raw {
+		var o Override
+		if err := json.Unmarshal([]byte(data), &o); err != nil {
+			return nil, fmt.Errorf("override %s: %w", field, err)
+		}
+		list = append(list, o)
+	}
+	return list, nil
+}
+
+func (s *RedisStore) Put(ctx context.Context, o Override) error {
+	data, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	return s.rdb.HSet(ctx, overridesKey, key(o.Kind, o.Match), data).Err()
+}
+
+func (s *RedisStore) Delete(ctx context.Context, kind Kind, match string) (bool, error) {
+	n, err := s.rdb.HDel(ctx, overridesKey, key(kind, match)).Result()
+	return n > 0, err
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/meladark/special-train/internal/gateway"
+	"github.com/meladark/special-train/internal/logging"
+	"github.com/meladark/special-train/internal/service"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// maxLoginBody bounds the login bodies the proxy reads before forwarding.
+const maxLoginBody = 1 << 20
+
+// Proxy forwards everything to the upstream and guards POSTs to the login
+// path. Those take their tokens up front, in the same atomic check that
+// decides on them, so a burst of parallel attempts cannot all slip past the
+// limits. Rejected attempts never reach the upstream. The tokens of an
+// attempt the upstream answers with a success, a 2xx or 3xx status not
+// listed among the failures, are given back, so successful logins never burn
+// tokens. Any other answer, an error or the proxy's own 502 included, keeps
+// them.
+type Proxy struct {
+	svc       *service.Service
+	gw        gateway.Config
+	loginPath string
+	failures  []int
+	upstream  *httputil.ReverseProxy
+}
+
+func New(svc *service.Service, upstream *url.URL, loginPath string, failures []int, gw gateway.Config) *Proxy {
+	rp := httputil.NewSingleHostReverseProxy(upstream)
+	// Forwarded requests carry the trace context on to the upstream.
+	rp.Transport = otelhttp.NewTransport(http.DefaultTransport)
+	return &Proxy{
+		svc:       svc,
+		gw:        gw,
+		loginPath: loginPath,
+		failures:  failures,
+		upstream:  rp,
+	}
+}
+
+// ParseStatuses parses a comma-separated list of HTTP status codes.
+func ParseStatuses(s string) ([]int, error) {
+	var statuses []int
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		code, err := strconv.Atoi(field)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid HTTP status %q", field)
+		}
+		statuses = append(statuses, code)
+	}
+	return statuses, nil
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != p.loginPath {
+		p.upstream.ServeHTTP(w, r)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxLoginBody+1))
+	if err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxLoginBody {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	req := p.gw.Extract(r.Header.Get, r.Header.Get("Content-Type"), string(body), r.RemoteAddr)
+	resp, refund, err := p.svc.Reserve(r.Context(), req)
+	switch {
+	case errors.Is(err, service.ErrInvalidIP):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, "service error: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	case !resp.Ok:
+		http.Error(w, resp.Reason, gateway.Status(resp))
+		return
+	}
+	rec := logging.NewStatusRecorder(w)
+	p.upstream.ServeHTTP(rec, r)
+	if refund == nil || !p.succeeded(rec.Status) {
+		return
+	}
+	// Refund the successful attempt even if the client has gone away meanwhile.
+	if err := refund(context.WithoutCancel(r.Context())); err != nil {
+		slog.ErrorContext(r.Context(), "proxy: failed to refund successful login", "login", req.Login, "err", err)
+	}
+}
+
+// succeeded reports whether the upstream accepted the login with status.
+func (p *Proxy) succeeded(status int) bool {
+	return status >= 200 && status < 400 && !slices.Contains(p.failures, status)
+}
@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/meladark/special-train/internal/bucket"
+	"github.com/meladark/special-train/internal/gateway"
+	"github.com/meladark/special-train/internal/service"
+	"github.com/meladark/special-train/internal/storage"
+)
+
+func newTestProxy(t *testing.T) (*Proxy, *atomic.Int64) {
+	t.Helper()
+	upstreamCalls := new(atomic.Int64)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("upstream got an unreadable body: %v", err)
+		}
+		switch r.PostForm.Get("password") {
+		case "right":
+		case "crash":
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(upstream.Close)
+	target, _ := url.Parse(upstream.URL)
+
+	rl := bucket.NewMemoryRateLimiter(5*time.Minute,
+		bucket.Config{Capacity: 2, RefillPerMinute: 0},
+		bucket.Config{Capacity: 100, RefillPerMinute: 0},
+		bucket.Config{Capacity: 100, RefillPerMinute: 0})
+	t.Cleanup(rl.Close)
+	store := storage.NewInMemoryStorage()
+	t.Cleanup(store.Close)
+	gw := gateway.Config{LoginField: "login", PasswordField: "password"}
+	return New(service.New(store, rl), target, "/login", []int{http.StatusUnauthorized}, gw), upstreamCalls
+}
+
+func TestProxyChargesErrors(t *testing.T) {
+	p, _ := newTestProxy(t)
+	if code := login(p, "crash"); code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 from upstream, got %d", code)
+	}
+	// With the upstream gone the proxy answers 502 itself.
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	p.upstream = httputil.NewSingleHostReverseProxy(mustURL(t, down.URL))
+	p.upstream.ErrorLog = log.New(io.Discard, "", 0)
+	if code := login(p, "right"); code != http.StatusBadGateway {
+		t.Fatalf("expected 502 with the upstream down, got %d", code)
+	}
+	if code := login(p, "right"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected the 500 and the 502 to be charged, got %d", code)
+	}
+}
+
+func mustURL(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func login(p *Proxy, password string) int {
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader("login=alice&password="+password))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = "192.0.2.1:5555"
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestProxyChargesOnlyFailedLogins(t *testing.T) {
+	p, upstreamCalls := newTestProxy(t)
+	for i := 0; i < 5; i++ {
+		if code := login(p, "right"); code != http.StatusOK {
+			t.Fatalf("successful login %d: expected 200, got %d", i+1, code)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if code := login(p, "wrong"); code != http.StatusUnauthorized {
+			t.Fatalf("failed login %d: expected 401 from upstream, got %d", i+1, code)
+		}
+	}
+	calls := upstreamCalls.Load()
+	if code := login(p, "right"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 once failures used up the bucket, got %d", code)
+	}
+	if upstreamCalls.Load() != calls {
+		t.Fatal("expected a rejected login not to reach the upstream")
+	}
+}
+
+func TestProxyLimitsParallelFailures(t *testing.T) {
+	p, upstreamCalls := newTestProxy(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			login(p, "wrong")
+		}()
+	}
+	wg.Wait()
+	if n := upstreamCalls.Load(); n != 2 {
+		t.Fatalf("expected only the bucket capacity of attempts to reach the upstream, got %d", n)
+	}
+}
+
+func TestProxyPassesOtherRequests(t *testing.T) {
+	p, upstreamCalls := newTestProxy(t)
+	for i := 0; i < 2; i++ {
+		login(p, "wrong")
+	}
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login", nil))
+	if rec.Code != http.StatusUnauthorized || upstreamCalls.Load() != 3 {
+		t.Fatalf("expected GET to be forwarded unchecked, got %d after %d calls", rec.Code, upstreamCalls.Load())
+	}
+}
+
+func TestParseStatuses(t *testing.T) {
+	got, err := ParseStatuses(" 401, 403 ,")
+	if err != nil || len(got) != 2 || got[0] != 401 || got[1] != 403 {
+		t.Fatalf("unexpected result %v, %v", got, err)
+	}
+	if _, err := ParseStatuses("401,abc"); err == nil {
+		t.Fatal("expected an error for a non-numeric status")
+	}
+}
@@ -0,0 +1,451 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/meladark/special-train/internal/autoban"
+	"github.com/meladark/special-train/internal/bucket"
+	"github.com/meladark/special-train/internal/metrics"
+	"github.com/meladark/special-train/internal/overrides"
+	"github.com/meladark/special-train/internal/tracing"
+	"github.com/meladark/special-train/pkg/netutils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The methods in this file hold the logic behind every transport; the HTTP
+// handlers and the gRPC server only translate requests and errors.
+
+var (
+	ErrInvalidIP  = errors.New("invalid ip")
+	ErrInvalidTTL = errors.New("invalid ttl")
+	// ErrUnavailable is returned when neither the rate limiter nor its
+	// fallback could check an attempt.
+	ErrUnavailable = errors.New("rate limiter unavailable")
+	// ErrListsUnavailable is returned when the lists could not be read.
+	ErrListsUnavailable = errors.New("lists unavailable")
+)
+
+// checkFunc is the bucket check behind a decision, such as Backend.CheckAll.
+type checkFunc func(b bucket.Backend, ctx context.Context, login, password, ip string) (bool, map[string]bool, error)
+
+// Authorize decides whether a login attempt may go ahead and takes tokens for it.
+func (s *Service) Authorize(ctx context.Context, req AuthorizeRequest) (AuthorizeResponse, error) {
+	return s.decide(ctx, req, bucket.Backend.CheckAll)
+}
+
+// RefundFunc gives back the tokens an attempt took.
+type RefundFunc func(ctx context.Context) error
+
+// Reserve decides like Authorize, taking the tokens in the same atomic check,
+// for callers that learn the outcome of the attempt later. refund gives the
+// tokens back once the attempt turns out to have succeeded; it is nil when
+// the attempt took none, because it was denied, whitelisted or let through
+// by the fail-open policy.
+func (s *Service) Reserve(
+	ctx context.Context,
+	req AuthorizeRequest,
+) (resp AuthorizeResponse, refund RefundFunc, err error) {
+	resp, stat, err := s.authorize(ctx, req, bucket.Backend.CheckAll)
+	observe(resp, stat, err)
+	if err != nil || !resp.Ok || stat == nil {
+		return resp, nil, err
+	}
+	backend := s.rl
+	if resp.Mode == ModeLocal {
+		backend = s.fallback
+	}
+	ip := net.ParseIP(req.IP).String()
+	return resp, func(ctx context.Context) error {
+		return backend.Refund(ctx, req.Login, req.Password, ip)
+	}, nil
+}
+
+// decide is authorize for callers asking for a decision, which it records in
+// the metrics.
+func (s *Service) decide(ctx context.Context, req AuthorizeRequest, check checkFunc) (AuthorizeResponse, error) {
+	resp, stat, err := s.authorize(ctx, req, check)
+	observe(resp, stat, err)
+	return resp, err
+}
+
+var tracer = tracing.Tracer("internal/service")
+
+// inList runs a storage lookup in a span of its own.
+func inList(
+	ctx context.Context,
+	name string,
+	lookup func(context.Context, net.IP) (bool, error),
+	ip net.IP,
+) (bool, error) {
+	ctx, span := tracer.Start(ctx, "storage."+name)
+	defer span.End()
+	found, err := lookup(ctx, ip)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return false, err
+	}
+	span.SetAttributes(attribute.Bool("storage.found", found))
+	return found, nil
+}
+
+// authorize also returns the verdict of each bucket, which is nil when no
+// bucket was asked.
+func (s *Service) authorize(
+	ctx context.Context,
+	req AuthorizeRequest,
+	check checkFunc,
+) (resp AuthorizeResponse, stat map[string]bool, err error) {
+	ctx, span := tracer.Start(ctx, "service.authorize", trace.WithAttributes(attribute.String("client.address", req.IP)))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		} else {
+			span.SetAttributes(
+				attribute.Bool("authorize.ok", resp.Ok),
+				attribute.String("authorize.reason", resp.Reason),
+				attribute.String("authorize.mode", resp.Mode),
+			)
+		}
+		span.End()
+	}()
+	ip := net.ParseIP(req.IP)
+	if ip == nil {
+		return AuthorizeResponse{}, nil, ErrInvalidIP
+	}
+	// An unreadable blacklist is left to the failure policy like an
+	// unreachable rate limiter, an unreadable whitelist only means the
+	// limits apply.
+	blacklisted, err := inList(ctx, "InBlacklist", s.store.InBlacklist, ip)
+	if blacklisted {
+		return AuthorizeResponse{Ok: false, Reason: ReasonBlacklisted, Mode: ModeNormal}, nil, nil
+	}
+	var allow bool
+	mode := ModeNormal
+	if err == nil {
+		whitelisted, wlErr := inList(ctx, "InWhitelist", s.store.InWhitelist, ip)
+		if wlErr != nil {
+			slog.WarnContext(ctx, "whitelist lookup failed", "err", wlErr)
+		}
+		if whitelisted {
+			return AuthorizeResponse{Ok: true, Mode: ModeNormal}, nil, nil
+		}
+		allow, stat, err = check(s.rl, ctx, req.Login, req.Password, ip.String())
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "rate limiter or blacklist failed", "policy", s.policy, "err", err)
+		mode, allow, stat, err = s.degrade(ctx, check, req.Login, req.Password, ip.String())
+	}
+	slog.DebugContext(ctx, "buckets checked",
+		"login", req.Login, "ip", req.IP, "mode", mode,
+		"login_ok", stat["login"], "pass_ok", stat["pass"], "ip_ok", stat["ip"],
+	)
+	if err != nil {
+		return AuthorizeResponse{}, nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	switch {
+	case mode == ModeFailClosed:
+		return AuthorizeResponse{Ok: false, Reason: ReasonUnavailable, Mode: mode}, stat, nil
+	case !allow:
+		// The ban must land even when the client hangs up.
+		s.autoban.Observe(context.WithoutCancel(ctx), ip)
+		if subnets := deniedSubnets(stat); len(subnets) > 0 {
+			return AuthorizeResponse{
+				Ok: false, Reason: ReasonSubnetRateLimited, Mode: mode, Subnet: strings.Join(subnets, ","),
+			}, stat, nil
+		}
+		return AuthorizeResponse{Ok: false, Reason: ReasonRateLimited, Mode: mode}, stat, nil
+	default:
+		return AuthorizeResponse{Ok: true, Mode: mode}, stat, nil
+	}
+}
+
+// deniedSubnets returns the names of the subnet buckets stat denies, sorted.
+func deniedSubnets(stat map[string]bool) []string {
+	var subnets []string
+	for key, allowed := range stat {
+		if name, ok := strings.CutPrefix(key, bucket.SubnetPrefix); ok && !allowed {
+			subnets = append(subnets, name)
+		}
+	}
+	slices.Sort(subnets)
+	return subnets
+}
+
+// dimensions maps the keys of a bucket verdict to metric labels. Subnet keys
+// are their own labels.
+var dimensions = map[string]string{"login": "login", "pass": "password", "ip": "ip"}
+
+func observe(resp AuthorizeResponse, stat map[string]bool, err error) {
+	var outcome, reason string
+	switch {
+	case errors.Is(err, ErrInvalidIP):
+		outcome, reason = "error", "invalid_ip"
+	case err != nil:
+		outcome, reason = "error", "unavailable"
+	case resp.Reason == ReasonBlacklisted:
+		outcome, reason = "denied", "blacklist"
+	case resp.Reason == ReasonRateLimited, resp.Reason == ReasonSubnetRateLimited:
+		outcome, reason = "denied", "rate_limit"
+	case resp.Reason == ReasonUnavailable:
+		outcome, reason = "denied", "unavailable"
+	case resp.Mode == ModeNormal && stat == nil:
+		outcome, reason = "allowed", "whitelist"
+	default:
+		outcome, reason = "allowed", "within_limits"
+	}
+	metrics.Decisions.WithLabelValues(outcome, reason, resp.Mode).Inc()
+	if reason != "rate_limit" {
+		return
+	}
+	for key, allowed := range stat {
+		if allowed {
+			continue
+		}
+		label, ok := dimensions[key]
+		if !ok {
+			label = key
+		}
+		metrics.RateLimited.WithLabelValues(label).Inc()
+	}
+}
+
+// degrade answers a check the rate limiter failed on according to s.policy.
+func (s *Service) degrade(
+	ctx context.Context,
+	check checkFunc,
+	login, password, ip string,
+) (mode string, allow bool, stat map[string]bool, err error) {
+	switch s.policy {
+	case FailOpen:
+		return ModeFailOpen, true, nil, nil
+	case FailLocal:
+		if s.fallback != nil {
+			allow, stat, err = check(s.fallback, ctx, login, password, ip)
+			return ModeLocal, allow, stat, err
+		}
+	case FailClosed:
+	}
+	return ModeFailClosed, false, nil, nil
+}
+
+// Report settles the buckets of an attempt once its outcome is known: a
+// success is refunded or resets the login bucket, a failure costs the
+// configured penalty on top. Listed IPs were never charged and are left alone.
+// Like a decision, a report falls back to the local limiter under FailLocal,
+// so that it settles the buckets the attempt was charged to.
+func (s *Service) Report(ctx context.Context, req ReportRequest) error {
+	ip := net.ParseIP(req.IP)
+	if ip == nil {
+		return ErrInvalidIP
+	}
+	var err error
+	for _, lookup := range []func(context.Context, net.IP) (bool, error){s.store.InBlacklist, s.store.InWhitelist} {
+		var listed bool
+		if listed, err = lookup(ctx, ip); err != nil {
+			break
+		}
+		if listed {
+			return nil
+		}
+	}
+	if err == nil {
+		err = s.settle(ctx, s.rl, req, ip)
+	}
+	if err != nil && s.policy == FailLocal && s.fallback != nil {
+		slog.WarnContext(ctx, "rate limiter or lists failed, reporting to the local limiter", "err", err)
+		err = s.settle(ctx, s.fallback, req, ip)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return nil
+}
+
+// settle applies the outcome of the attempt in req to the buckets of b.
+func (s *Service) settle(ctx context.Context, b bucket.Backend, req ReportRequest, ip net.IP) error {
+	switch {
+	case req.Success && s.onSuccess == SuccessReset:
+		return b.ResetLogin(ctx, req.Login)
+	case req.Success:
+		return b.Refund(ctx, req.Login, req.Password, ip.String())
+	default:
+		return b.Penalize(ctx, req.Login, req.Password, ip.String(), s.penalty)
+	}
+}
+
+func (s *Service) ResetAll(ctx context.Context) error {
+	return s.rl.ResetAll(ctx, "*")
+}
+
+func (s *Service) ResetIP(ctx context.Context, ip string) error {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ErrInvalidIP
+	}
+	return s.rl.ResetIP(ctx, parsed.String())
+}
+
+func (s *Service) ResetLogin(ctx context.Context, login string) error {
+	return s.rl.ResetLogin(ctx, login)
+}
+
+func (s *Service) AddToWhitelist(
+	ctx context.Context,
+	network string,
+	force bool,
+	ttl time.Duration,
+) (ListReponse, error) {
+	return addToList(ctx, s.store.AddToWhitelist, network, force, ttl)
+}
+
+func (s *Service) AddToBlacklist(
+	ctx context.Context,
+	network string,
+	force bool,
+	ttl time.Duration,
+) (ListReponse, error) {
+	return addToList(ctx, s.store.AddToBlacklist, network, force, ttl)
+}
+
+// addToList adds network with add. Only invalid input is returned as an
+// error, refusals of the storage are reported in the response.
+func addToList(
+	ctx context.Context,
+	add func(context.Context, net.IPNet, bool, time.Duration) (bool, error),
+	network string,
+	force bool,
+	ttl time.Duration,
+) (ListReponse, error) {
+	ipnet, err := netutils.ParseNet(network)
+	if err != nil {
+		return ListReponse{}, fmt.Errorf("%w: %v", ErrInvalidIP, err)
+	}
+	if ttl < 0 {
+		return ListReponse{}, ErrInvalidTTL
+	}
+	ok, err := add(ctx, *ipnet, force, ttl)
+	if err != nil {
+		return ListReponse{Ok: ok, Reason: err.Error()}, nil
+	}
+	return ListReponse{Ok: ok}, nil
+}
+
+func (s *Service) RemoveFromWhitelist(ctx context.Context, network string) (ListReponse, error) {
+	return removeFromList(ctx, s.store.RemoveFromWhitelist, network)
+}
+
+func (s *Service) RemoveFromBlacklist(ctx context.Context, network string) (ListReponse, error) {
+	return removeFromList(ctx, s.store.RemoveFromBlacklist, network)
+}
+
+func removeFromList(
+	ctx context.Context,
+	remove func(context.Context, net.IPNet) (bool, error),
+	network string,
+) (ListReponse, error) {
+	ipnet, err := netutils.ParseNet(network)
+	if err != nil {
+		return ListReponse{}, fmt.Errorf("%w: %v", ErrInvalidIP, err)
+	}
+	if ok, err := remove(ctx, *ipnet); !ok {
+		return ListReponse{Ok: ok, Reason: err.Error()}, nil
+	}
+	return ListReponse{Ok: true}, nil
+}
+
+// Lists returns both lists, with automatic blacklist entries kept apart from
+// manual ones.
+func (s *Service) Lists(ctx context.Context) (IPList, error) {
+	whitelist, blacklist, err := s.store.BlackWhiteLists(ctx)
+	if err != nil {
+		return IPList{}, fmt.Errorf("%w: %v", ErrListsUnavailable, err)
+	}
+	entries, err := s.AutoBanEntries(ctx)
+	if err != nil {
+		return IPList{}, err
+	}
+	whiteExpiry, blackExpiry, err := s.store.Expirations(ctx)
+	if err != nil {
+		return IPList{}, fmt.Errorf("%w: %v", ErrListsUnavailable, err)
+	}
+	ips := IPList{
+		Ok:            true,
+		Whitelist:     make([]string, 0, len(whitelist)),
+		Blacklist:     make([]string, 0, len(blacklist)),
+		AutoBlacklist: make([]string, 0),
+	}
+	for _, ipnet := range whitelist {
+		ips.Whitelist = append(ips.Whitelist, ipnet.String())
+	}
+	auto := make(map[string]bool)
+	for _, entry := range entries {
+		auto[entry.Network] = true
+	}
+	for _, ipnet := range blacklist {
+		if auto[ipnet.String()] {
+			ips.AutoBlacklist = append(ips.AutoBlacklist, ipnet.String())
+			continue
+		}
+		ips.Blacklist = append(ips.Blacklist, ipnet.String())
+	}
+	ips.WhitelistExpiresIn, ips.BlacklistExpiresIn = expiresIn(whiteExpiry), expiresIn(blackExpiry)
+	return ips, nil
+}
+
+// AutoBanEntries returns the blacklist entries added by the autoban engine.
+func (s *Service) AutoBanEntries(ctx context.Context) ([]autoban.Entry, error) {
+	entries, err := s.autoban.Entries(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrListsUnavailable, err)
+	}
+	return entries, nil
+}
+
+// expiresIn turns expiry times into the whole seconds left, rounded up.
+func expiresIn(expiry map[string]time.Time) map[string]int64 {
+	left := make(map[string]int64, len(expiry))
+	for key, at := range expiry {
+		left[key] = int64(math.Ceil(time.Until(at).Seconds()))
+	}
+	return left
+}
+
+// PutOverride adds o, or replaces the override of the same kind and match.
+// Invalid overrides are reported with errors wrapping overrides.ErrInvalid.
+func (s *Service) PutOverride(ctx context.Context, o overrides.Override) error {
+	return s.overrides.Put(ctx, o)
+}
+
+// DeleteOverride removes the override of kind for match. A missing one is
+// reported in the response, like a missing list entry.
+func (s *Service) DeleteOverride(ctx context.Context, kind overrides.Kind, match string) (ListReponse, error) {
+	ok, err := s.overrides.Delete(ctx, kind, match)
+	if err != nil {
+		return ListReponse{}, err
+	}
+	if !ok {
+		return ListReponse{Ok: false, Reason: fmt.Sprintf("no %s override for %s", kind, match)}, nil
+	}
+	return ListReponse{Ok: true}, nil
+}
+
+// Overrides returns the overrides in force.
+func (s *Service) Overrides() []overrides.Override {
+	list := s.overrides.List()
+	if list == nil {
+		list = make([]overrides.Override, 0)
+	}
+	return list
+}
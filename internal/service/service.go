@@ -3,28 +3,143 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
-	"log"
-	"net"
+	"log/slog"
 	"net/http"
+	"time"
 
+	"github.com/meladark/special-train/internal/autoban"
 	"github.com/meladark/special-train/internal/bucket"
+	"github.com/meladark/special-train/internal/overrides"
 	"github.com/meladark/special-train/internal/storage"
 )
 
 type Service struct {
-	store storage.Storage
-	rl    *bucket.RateLimiter
+	store     storage.Storage
+	rl        bucket.Backend
+	policy    FailurePolicy
+	fallback  bucket.Backend
+	autoban   *autoban.Engine
+	onSuccess SuccessPolicy
+	penalty   int
+	overrides *overrides.Table
 }
 
+// FailurePolicy decides how authorize answers when the rate limiter fails.
+type FailurePolicy string
+
+const (
+	FailOpen   FailurePolicy = "fail-open"
+	FailClosed FailurePolicy = "fail-closed"
+	// FailLocal asks a process-local rate limiter instead.
+	FailLocal FailurePolicy = "local"
+)
+
+func ParseFailurePolicy(s string) (FailurePolicy, error) {
+	switch p := FailurePolicy(s); p {
+	case FailOpen, FailClosed, FailLocal:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown failure policy %q, expected %q, %q or %q", s, FailOpen, FailClosed, FailLocal)
+	}
+}
+
+// SuccessPolicy decides what Report does with the buckets of a login that
+// succeeded.
+type SuccessPolicy string
+
+const (
+	// SuccessRefund gives back the tokens the attempt took.
+	SuccessRefund SuccessPolicy = "refund"
+	// SuccessReset empties the login bucket.
+	SuccessReset SuccessPolicy = "reset"
+)
+
+func ParseSuccessPolicy(s string) (SuccessPolicy, error) {
+	switch p := SuccessPolicy(s); p {
+	case SuccessRefund, SuccessReset:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown success policy %q, expected %q or %q", s, SuccessRefund, SuccessReset)
+	}
+}
+
+// Denial reasons reported in AuthorizeResponse.Reason.
+const (
+	ReasonBlacklisted = "ip in blacklist"
+	ReasonRateLimited = "rate limit exceeded"
+	// ReasonSubnetRateLimited is given when the bucket of a whole subnet ran
+	// out, which AuthorizeResponse.Subnet names.
+	ReasonSubnetRateLimited = "subnet rate limit exceeded"
+	ReasonUnavailable       = "rate limiter unavailable"
+)
+
+// Decision modes reported in AuthorizeResponse.Mode.
+const (
+	ModeNormal     = "normal"
+	ModeFailOpen   = "fail-open"
+	ModeFailClosed = "fail-closed"
+	ModeLocal      = "local"
+)
+
 type IPList struct {
-	Ok        bool     `json:"ok"`
-	Whitelist []string `json:"whitelist"`
-	Blacklist []string `json:"blacklist"`
+	Ok            bool     `json:"ok"`
+	Whitelist     []string `json:"whitelist"`
+	Blacklist     []string `json:"blacklist"`
+	AutoBlacklist []string `json:"auto_blacklist"`
+	// WhitelistExpiresIn and BlacklistExpiresIn hold the seconds left for the
+	// entries of each list that expire, by network. The same network may be
+	// in both lists when added with force.
+	WhitelistExpiresIn map[string]int64 `json:"whitelist_expires_in"`
+	BlacklistExpiresIn map[string]int64 `json:"blacklist_expires_in"`
+}
+
+type AutoBanList struct {
+	Ok      bool            `json:"ok"`
+	Entries []autoban.Entry `json:"entries"`
+}
+
+type OverrideList struct {
+	Ok        bool                 `json:"ok"`
+	Overrides []overrides.Override `json:"overrides"`
+}
+
+// OverrideDelRequest names the override to remove.
+type OverrideDelRequest struct {
+	Kind  overrides.Kind `json:"kind"`
+	Match string         `json:"match"`
+}
+
+func New(store storage.Storage, bucket bucket.Backend) *Service {
+	return &Service{store: store, rl: bucket, policy: FailClosed, onSuccess: SuccessRefund}
+}
+
+// SetAutoBan makes authorize report rate-limit denials to engine, which
+// blacklists repeat offenders.
+func (s *Service) SetAutoBan(engine *autoban.Engine) {
+	s.autoban = engine
+}
+
+// SetFailurePolicy chooses what authorize does when the rate limiter fails.
+// fallback is only used with FailLocal.
+func (s *Service) SetFailurePolicy(policy FailurePolicy, fallback bucket.Backend) {
+	s.policy = policy
+	s.fallback = fallback
+}
+
+// SetOverrides lets the admin endpoints manage the overrides of table, which
+// the rate limiters must have been given too.
+func (s *Service) SetOverrides(table *overrides.Table) {
+	s.overrides = table
 }
 
-func New(store storage.Storage, bucket *bucket.RateLimiter) *Service {
-	return &Service{store: store, rl: bucket}
+// SetReportPolicy chooses what Report does: onSuccess for logins that
+// succeeded, and penalty extra tokens taken for ones that failed.
+func (s *Service) SetReportPolicy(onSuccess SuccessPolicy, penalty int) {
+	s.onSuccess = onSuccess
+	s.penalty = penalty
 }
 
 type AuthorizeRequest struct {
@@ -33,14 +148,29 @@ type AuthorizeRequest struct {
 	IP       string `json:"ip"`
 }
 
+// ReportRequest tells the outcome of a login attempt that was authorized.
+type ReportRequest struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+	IP       string `json:"ip"`
+	Success  bool   `json:"success"`
+}
+
 type AuthorizeResponse struct {
 	Ok     bool   `json:"ok"`
 	Reason string `json:"reason,omitempty"`
+	Mode   string `json:"mode,omitempty"`
+	// Subnet names the subnet buckets that denied the attempt, e.g.
+	// "ipv4/24", comma separated when there are several.
+	Subnet string `json:"subnet,omitempty"`
 }
 
 type ListRequest struct {
 	IP    string `json:"ip"`
 	Force bool   `json:"force"`
+	// TTL is a positive duration such as "30m" after which the entry
+	// expires, empty keeps it until removed.
+	TTL string `json:"ttl,omitempty"`
 }
 
 type ListReponse struct {
@@ -51,7 +181,7 @@ type ListReponse struct {
 func writeJSON(w http.ResponseWriter, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(v); err != nil {
-		log.Printf("failed to write JSON response: %v", err)
+		slog.Error("failed to write JSON response", "err", err)
 	}
 }
 
@@ -65,32 +195,36 @@ func (s *Service) AuthorizeHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid json", http.StatusBadRequest)
 		return
 	}
-	log.Print("\tLogin: ", req.Login, "\n\t\t\tPassword: ", req.Password, "\n\t\t\tIP: ", req.IP)
-	ip := net.ParseIP(req.IP)
-	if ip == nil {
-		http.Error(w, "invalid ip", http.StatusBadRequest)
-		return
-	}
-	if s.store.InBlacklist(ip) {
-		writeJSON(w, AuthorizeResponse{Ok: false, Reason: "ip in blacklist"})
-		return
+	resp, err := s.Authorize(r.Context(), req)
+	switch {
+	case errors.Is(err, ErrInvalidIP):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, "service error: "+err.Error(), http.StatusServiceUnavailable)
+	default:
+		writeJSON(w, resp)
 	}
-	if s.store.InWhitelist(ip) {
-		writeJSON(w, AuthorizeResponse{Ok: true})
+}
+
+func (s *Service) ReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	ctx := context.Background()
-	allow, stat, err := s.rl.CheckAll(ctx, req.Login, req.Password, req.IP)
-	log.Print("\tLogin: ", stat["login"], "\n\t\t\tPassword: ", stat["pass"], "\n\t\t\tIP: ", stat["ip"])
-	if err != nil {
-		http.Error(w, "service error: "+err.Error(), http.StatusMethodNotAllowed)
+	var req ReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
 		return
 	}
-	if !allow {
-		writeJSON(w, AuthorizeResponse{Ok: false, Reason: "rate limit exceeded"})
-		return
+	err := s.Report(r.Context(), req)
+	switch {
+	case errors.Is(err, ErrInvalidIP):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, "service error: "+err.Error(), http.StatusServiceUnavailable)
+	default:
+		writeJSON(w, AuthorizeResponse{Ok: true})
 	}
-	writeJSON(w, AuthorizeResponse{Ok: true})
 }
 
 func (s *Service) ResetBucketHandler(w http.ResponseWriter, r *http.Request) {
@@ -98,10 +232,9 @@ func (s *Service) ResetBucketHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	ctx := context.Background()
-	err := s.rl.ResetAll(ctx, "*")
-	if err != nil {
+	if err := s.ResetAll(r.Context()); err != nil {
 		http.Error(w, "service error: "+err.Error(), http.StatusMethodNotAllowed)
+		return
 	}
 	writeJSON(w, AuthorizeResponse{Ok: true})
 }
@@ -118,16 +251,16 @@ func (s *Service) ResetBucketIPHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	log.Print(req.IP)
-	if ip := net.ParseIP(req.IP); ip != nil {
-		if err := s.rl.ResetIP(context.Background(), req.IP); err != nil {
-			http.Error(w, "service error: "+err.Error(), http.StatusMethodNotAllowed)
-			return
-		}
+	slog.InfoContext(r.Context(), "reset ip bucket", "ip", req.IP)
+	err := s.ResetIP(r.Context(), req.IP)
+	switch {
+	case errors.Is(err, ErrInvalidIP):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, "service error: "+err.Error(), http.StatusMethodNotAllowed)
+	default:
 		writeJSON(w, AuthorizeResponse{Ok: true})
-		return
 	}
-	http.Error(w, "invalid ip", http.StatusBadRequest)
 }
 
 func (s *Service) ResetBucketLoginHandler(w http.ResponseWriter, r *http.Request) {
@@ -142,8 +275,8 @@ func (s *Service) ResetBucketLoginHandler(w http.ResponseWriter, r *http.Request
 			return
 		}
 	}
-	log.Print(req.Login)
-	if err := s.rl.ResetLogin(context.Background(), req.Login); err != nil {
+	slog.InfoContext(r.Context(), "reset login bucket", "login", req.Login)
+	if err := s.ResetLogin(r.Context(), req.Login); err != nil {
 		http.Error(w, "service error: "+err.Error(), http.StatusMethodNotAllowed)
 		return
 	}
@@ -151,17 +284,17 @@ func (s *Service) ResetBucketLoginHandler(w http.ResponseWriter, r *http.Request
 }
 
 func (s *Service) WhitelistHandler(w http.ResponseWriter, r *http.Request) {
-	s.handleListOperation(w, r, s.store.AddToWhitelist)
+	s.handleListOperation(w, r, s.AddToWhitelist)
 }
 
 func (s *Service) BlacklistHandler(w http.ResponseWriter, r *http.Request) {
-	s.handleListOperation(w, r, s.store.AddToBlacklist)
+	s.handleListOperation(w, r, s.AddToBlacklist)
 }
 
 func (s *Service) handleListOperation(
 	w http.ResponseWriter,
 	r *http.Request,
-	addFunc func(net.IPNet, bool) (bool, error),
+	addFunc func(context.Context, string, bool, time.Duration) (ListReponse, error),
 ) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -174,44 +307,45 @@ func (s *Service) handleListOperation(
 			return
 		}
 	}
-	log.Print(req.IP, ", ", req.Force)
-	_, ipnet, err := net.ParseCIDR(req.IP)
-	if err != nil {
-		if ip := net.ParseIP(req.IP); ip != nil {
-			ipnet = &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}
-		} else {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+	slog.InfoContext(r.Context(), "list operation", "path", r.URL.Path, "network", req.IP, "force", req.Force, "ttl", req.TTL)
+	var ttl time.Duration
+	if req.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl <= 0 {
+			http.Error(w, ErrInvalidTTL.Error(), http.StatusBadRequest)
 			return
 		}
 	}
-	res, err := addFunc(*ipnet, req.Force)
+	resp, err := addFunc(r.Context(), req.IP, req.Force, ttl)
 	if err != nil {
-		writeJSON(w, ListReponse{Ok: res, Reason: err.Error()})
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	writeJSON(w, ListReponse{Ok: res})
+	writeJSON(w, resp)
 }
 
-func (s *Service) ViewListsHandler(w http.ResponseWriter, _ *http.Request) {
-	whitelist, blacklist := s.store.BlackWhiteLists()
-	ips := IPList{
-		Ok:        true,
-		Whitelist: make([]string, 0, len(whitelist)),
-		Blacklist: make([]string, 0, len(blacklist)),
-	}
-	for _, ipnet := range whitelist {
-		ips.Whitelist = append(ips.Whitelist, ipnet.String())
+func (s *Service) ViewListsHandler(w http.ResponseWriter, r *http.Request) {
+	lists, err := s.Lists(r.Context())
+	if err != nil {
+		http.Error(w, "service error: "+err.Error(), http.StatusServiceUnavailable)
+		return
 	}
-	for _, ipnet := range blacklist {
-		ips.Blacklist = append(ips.Blacklist, ipnet.String())
+	writeJSON(w, lists)
+}
+
+func (s *Service) ViewAutoBanHandler(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.AutoBanEntries(r.Context())
+	if err != nil {
+		http.Error(w, "service error: "+err.Error(), http.StatusServiceUnavailable)
+		return
 	}
-	writeJSON(w, ips)
+	writeJSON(w, AutoBanList{Ok: true, Entries: entries})
 }
 
 func (s *Service) handleRemoveOperation(
 	w http.ResponseWriter,
 	r *http.Request,
-	addFunc func(net.IPNet) (bool, error),
+	removeFunc func(context.Context, string) (ListReponse, error),
 ) {
 	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -224,26 +358,76 @@ func (s *Service) handleRemoveOperation(
 			return
 		}
 	}
-	_, ipnet, err := net.ParseCIDR(req.IP)
+	resp, err := removeFunc(r.Context(), req.IP)
 	if err != nil {
-		if ip := net.ParseIP(req.IP); ip != nil {
-			ipnet = &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}
-		} else {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-	}
-	if ok, err := addFunc(*ipnet); !ok {
-		writeJSON(w, ListReponse{Ok: ok, Reason: err.Error()})
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	writeJSON(w, ListReponse{Ok: true})
+	writeJSON(w, resp)
 }
 
 func (s *Service) RemoveFromWhitelistHandler(w http.ResponseWriter, r *http.Request) {
-	s.handleRemoveOperation(w, r, s.store.RemoveFromWhitelist)
+	s.handleRemoveOperation(w, r, s.RemoveFromWhitelist)
 }
 
 func (s *Service) RemoveFromBlacklistHandler(w http.ResponseWriter, r *http.Request) {
-	s.handleRemoveOperation(w, r, s.store.RemoveFromBlacklist)
+	s.handleRemoveOperation(w, r, s.RemoveFromBlacklist)
+}
+
+// overrideMatch logs the match of an override, a login under the login key
+// so that LOG_REDACT_LOGINS covers it.
+func overrideMatch(kind overrides.Kind, match string) slog.Attr {
+	if kind == overrides.KindLogin {
+		return slog.String("login", match)
+	}
+	return slog.String("match", match)
+}
+
+func (s *Service) OverrideAddHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var o overrides.Override
+	if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+		http.Error(w, "invalid override: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	slog.InfoContext(r.Context(), "add override", "kind", o.Kind, overrideMatch(o.Kind, o.Match),
+		"capacity", o.Config.Capacity)
+	err := s.PutOverride(r.Context(), o)
+	switch {
+	case errors.Is(err, overrides.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, "service error: "+err.Error(), http.StatusServiceUnavailable)
+	default:
+		writeJSON(w, ListReponse{Ok: true})
+	}
+}
+
+func (s *Service) OverrideDelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req OverrideDelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	slog.InfoContext(r.Context(), "remove override", "kind", req.Kind, overrideMatch(req.Kind, req.Match))
+	resp, err := s.DeleteOverride(r.Context(), req.Kind, req.Match)
+	switch {
+	case errors.Is(err, overrides.ErrInvalid):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, "service error: "+err.Error(), http.StatusServiceUnavailable)
+	default:
+		writeJSON(w, resp)
+	}
+}
+
+func (s *Service) ViewOverridesHandler(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, OverrideList{Ok: true, Overrides: s.Overrides()})
 }
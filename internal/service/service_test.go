@@ -0,0 +1,557 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/meladark/special-train/internal/autoban"
+	"github.com/meladark/special-train/internal/bucket"
+	"github.com/meladark/special-train/internal/logging"
+	"github.com/meladark/special-train/internal/metrics"
+	"github.com/meladark/special-train/internal/overrides"
+	"github.com/meladark/special-train/internal/storage"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	rl := bucket.NewMemoryRateLimiter(5*time.Minute,
+		bucket.Config{Capacity: 3, RefillPerMinute: 0},
+		bucket.Config{Capacity: 100, RefillPerMinute: 0},
+		bucket.Config{Capacity: 100, RefillPerMinute: 0})
+	t.Cleanup(rl.Close)
+	store := storage.NewInMemoryStorage()
+	t.Cleanup(store.Close)
+	return New(store, rl)
+}
+
+func call(t *testing.T, h http.HandlerFunc, body any, out any) int {
+	t.Helper()
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(b))
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	if out != nil && rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("invalid JSON response %q: %v", rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func authorize(t *testing.T, s *Service, login, ip string) AuthorizeResponse {
+	t.Helper()
+	var resp AuthorizeResponse
+	req := AuthorizeRequest{Login: login, Password: "secret", IP: ip}
+	if code := call(t, s.AuthorizeHandler, req, &resp); code != http.StatusOK {
+		t.Fatalf("authorize returned %d", code)
+	}
+	return resp
+}
+
+func TestAuthorizeRateLimit(t *testing.T) {
+	s := newTestService(t)
+	for i := 0; i < 3; i++ {
+		if resp := authorize(t, s, "alice", "192.0.2.1"); !resp.Ok {
+			t.Fatalf("expected attempt %d allowed, got %+v", i+1, resp)
+		}
+	}
+	if resp := authorize(t, s, "alice", "192.0.2.1"); resp.Ok {
+		t.Fatalf("expected login to be rate limited, got %+v", resp)
+	}
+	var reset AuthorizeResponse
+	if code := call(t, s.ResetBucketLoginHandler, AuthorizeRequest{Login: "alice"}, &reset); code != http.StatusOK {
+		t.Fatalf("reset returned %d", code)
+	}
+	if resp := authorize(t, s, "alice", "192.0.2.1"); !resp.Ok {
+		t.Fatalf("expected allowed after reset, got %+v", resp)
+	}
+}
+
+func TestAuthorizeLists(t *testing.T) {
+	s := newTestService(t)
+	var resp ListReponse
+	call(t, s.BlacklistHandler, ListRequest{IP: "2001:db8::/64"}, &resp)
+	if !resp.Ok {
+		t.Fatalf("expected blacklist add, got %+v", resp)
+	}
+	call(t, s.WhitelistHandler, ListRequest{IP: "198.51.100.7"}, &resp)
+	if !resp.Ok {
+		t.Fatalf("expected whitelist add, got %+v", resp)
+	}
+	if got := authorize(t, s, "bob", "2001:db8::1"); got.Ok || got.Reason != "ip in blacklist" {
+		t.Fatalf("expected blacklist denial, got %+v", got)
+	}
+	for i := 0; i < 10; i++ {
+		if got := authorize(t, s, "bob", "198.51.100.7"); !got.Ok {
+			t.Fatalf("expected whitelisted IP to bypass limits, got %+v", got)
+		}
+	}
+	call(t, s.RemoveFromBlacklistHandler, ListRequest{IP: "2001:db8::/64"}, &resp)
+	if !resp.Ok {
+		t.Fatalf("expected blacklist removal, got %+v", resp)
+	}
+	if got := authorize(t, s, "bob", "2001:db8::1"); !got.Ok {
+		t.Fatalf("expected allowed after removal, got %+v", got)
+	}
+}
+
+func TestAuthorizeInvalidInput(t *testing.T) {
+	s := newTestService(t)
+	if code := call(t, s.AuthorizeHandler, AuthorizeRequest{Login: "x", IP: "not-an-ip"}, nil); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid ip, got %d", code)
+	}
+	rec := httptest.NewRecorder()
+	s.AuthorizeHandler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 for GET, got %d", rec.Code)
+	}
+}
+
+type failingBackend struct {
+	bucket.Backend
+}
+
+func (failingBackend) CheckAll(context.Context, string, string, string) (bool, map[string]bool, error) {
+	return false, nil, bucket.ErrCircuitOpen
+}
+
+func (failingBackend) Refund(context.Context, string, string, string) error {
+	return bucket.ErrCircuitOpen
+}
+
+func (failingBackend) Penalize(context.Context, string, string, string, int) error {
+	return bucket.ErrCircuitOpen
+}
+
+func TestAuthorizeFailurePolicies(t *testing.T) {
+	local := bucket.NewMemoryRateLimiter(5*time.Minute,
+		bucket.Config{Capacity: 1, RefillPerMinute: 0},
+		bucket.Config{Capacity: 1, RefillPerMinute: 0},
+		bucket.Config{Capacity: 1, RefillPerMinute: 0})
+	t.Cleanup(local.Close)
+	tests := []struct {
+		policy FailurePolicy
+		expect []AuthorizeResponse
+	}{
+		{FailOpen, []AuthorizeResponse{{Ok: true, Mode: ModeFailOpen}, {Ok: true, Mode: ModeFailOpen}}},
+		{FailClosed, []AuthorizeResponse{
+			{Ok: false, Reason: "rate limiter unavailable", Mode: ModeFailClosed},
+			{Ok: false, Reason: "rate limiter unavailable", Mode: ModeFailClosed},
+		}},
+		{FailLocal, []AuthorizeResponse{
+			{Ok: true, Mode: ModeLocal},
+			{Ok: false, Reason: "rate limit exceeded", Mode: ModeLocal},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			s := New(storage.NewInMemoryStorage(), failingBackend{})
+			s.SetFailurePolicy(tt.policy, local)
+			for i, want := range tt.expect {
+				if got := authorize(t, s, "grace", "192.0.2.8"); got != want {
+					t.Fatalf("attempt %d: expected %+v, got %+v", i+1, want, got)
+				}
+			}
+		})
+	}
+}
+
+// failingStore is a storage whose lists cannot be read.
+type failingStore struct {
+	storage.Storage
+}
+
+func (failingStore) InBlacklist(context.Context, net.IP) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func (failingStore) InWhitelist(context.Context, net.IP) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func (failingStore) BlackWhiteLists(context.Context) (map[string]*net.IPNet, map[string]*net.IPNet, error) {
+	return nil, nil, errors.New("connection refused")
+}
+
+func (failingStore) Expirations(context.Context) (map[string]time.Time, map[string]time.Time, error) {
+	return nil, nil, errors.New("connection refused")
+}
+
+func (failingStore) BlacklistTags(context.Context) (map[string]string, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestAuthorizeListFailure(t *testing.T) {
+	rl := bucket.NewMemoryRateLimiter(5*time.Minute,
+		bucket.Config{Capacity: 1, RefillPerMinute: 0},
+		bucket.Config{Capacity: 1, RefillPerMinute: 0},
+		bucket.Config{Capacity: 1, RefillPerMinute: 0})
+	t.Cleanup(rl.Close)
+	tests := []struct {
+		policy FailurePolicy
+		expect AuthorizeResponse
+	}{
+		{FailOpen, AuthorizeResponse{Ok: true, Mode: ModeFailOpen}},
+		{FailClosed, AuthorizeResponse{Ok: false, Reason: "rate limiter unavailable", Mode: ModeFailClosed}},
+		{FailLocal, AuthorizeResponse{Ok: true, Mode: ModeLocal}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			s := New(failingStore{}, rl)
+			s.SetFailurePolicy(tt.policy, rl)
+			if got := authorize(t, s, "oscar", "192.0.2.12"); got != tt.expect {
+				t.Fatalf("expected %+v, got %+v", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestAutoBanViewLists(t *testing.T) {
+	s := newTestService(t)
+	s.SetAutoBan(autoban.New(autoban.Config{Threshold: 2, Window: time.Minute, BanTTL: time.Hour}, s.store))
+	var resp ListReponse
+	call(t, s.BlacklistHandler, ListRequest{IP: "203.0.113.0/24"}, &resp)
+	for i := 0; i < 5; i++ {
+		authorize(t, s, "heidi", "192.0.2.9")
+	}
+	if got := authorize(t, s, "ivan", "192.0.2.9"); got.Reason != "ip in blacklist" {
+		t.Fatalf("expected repeat offender to be blacklisted, got %+v", got)
+	}
+	var lists IPList
+	rec := httptest.NewRecorder()
+	s.ViewListsHandler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), &lists); err != nil {
+		t.Fatalf("invalid JSON response: %v", err)
+	}
+	if len(lists.Blacklist) != 1 || lists.Blacklist[0] != "203.0.113.0/24" {
+		t.Fatalf("expected only the manual entry in blacklist, got %v", lists.Blacklist)
+	}
+	if len(lists.AutoBlacklist) != 1 || lists.AutoBlacklist[0] != "192.0.2.9/32" {
+		t.Fatalf("expected the offender in auto_blacklist, got %v", lists.AutoBlacklist)
+	}
+}
+
+func TestListTTL(t *testing.T) {
+	s := newTestService(t)
+	if code := call(t, s.BlacklistHandler, ListRequest{IP: "192.0.2.0/24", TTL: "soon"}, nil); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid ttl, got %d", code)
+	}
+	zero := ListRequest{IP: "192.0.2.0/24", TTL: "0s"}
+	if code := call(t, s.BlacklistHandler, zero, nil); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a zero ttl, got %d", code)
+	}
+	var resp ListReponse
+	call(t, s.BlacklistHandler, ListRequest{IP: "192.0.2.0/24", TTL: "1m"}, &resp)
+	if !resp.Ok {
+		t.Fatalf("expected blacklist add, got %+v", resp)
+	}
+	call(t, s.WhitelistHandler, ListRequest{IP: "198.51.100.0/24"}, &resp)
+	call(t, s.WhitelistHandler, ListRequest{IP: "192.0.2.0/24", Force: true, TTL: "10m"}, &resp)
+	if !resp.Ok {
+		t.Fatalf("expected forced whitelist add, got %+v", resp)
+	}
+	var lists IPList
+	rec := httptest.NewRecorder()
+	s.ViewListsHandler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), &lists); err != nil {
+		t.Fatalf("invalid JSON response: %v", err)
+	}
+	if left := lists.BlacklistExpiresIn["192.0.2.0/24"]; left <= 0 || left > 60 {
+		t.Fatalf("expected up to 60s left in the blacklist, got %d", left)
+	}
+	if left := lists.WhitelistExpiresIn["192.0.2.0/24"]; left <= 540 || left > 600 {
+		t.Fatalf("expected up to 10m left in the whitelist, got %d", left)
+	}
+	if _, ok := lists.WhitelistExpiresIn["198.51.100.0/24"]; ok {
+		t.Fatal("expected no expiry for a permanent entry")
+	}
+}
+
+func TestReport(t *testing.T) {
+	report := func(t *testing.T, s *Service, success bool) {
+		t.Helper()
+		req := ReportRequest{Login: "judy", Password: "secret", IP: "192.0.2.10", Success: success}
+		if code := call(t, s.ReportHandler, req, nil); code != http.StatusOK {
+			t.Fatalf("report returned %d", code)
+		}
+	}
+	t.Run("refund", func(t *testing.T) {
+		s := newTestService(t)
+		for i := 0; i < 10; i++ {
+			if got := authorize(t, s, "judy", "192.0.2.10"); !got.Ok {
+				t.Fatalf("expected refunded attempt %d allowed, got %+v", i+1, got)
+			}
+			report(t, s, true)
+		}
+	})
+	t.Run("reset", func(t *testing.T) {
+		s := newTestService(t)
+		s.SetReportPolicy(SuccessReset, 0)
+		for i := 0; i < 3; i++ {
+			authorize(t, s, "judy", "192.0.2.10")
+		}
+		report(t, s, true)
+		if got := authorize(t, s, "judy", "192.0.2.10"); !got.Ok {
+			t.Fatalf("expected allowed after a successful login, got %+v", got)
+		}
+	})
+	t.Run("penalty", func(t *testing.T) {
+		s := newTestService(t)
+		s.SetReportPolicy(SuccessRefund, 2)
+		authorize(t, s, "judy", "192.0.2.10")
+		report(t, s, false)
+		if got := authorize(t, s, "judy", "192.0.2.10"); got.Ok {
+			t.Fatalf("expected the penalty to use up the login bucket, got %+v", got)
+		}
+	})
+	t.Run("fail local", func(t *testing.T) {
+		local := bucket.NewMemoryRateLimiter(5*time.Minute,
+			bucket.Config{Capacity: 1, RefillPerMinute: 0},
+			bucket.Config{Capacity: 100, RefillPerMinute: 0},
+			bucket.Config{Capacity: 100, RefillPerMinute: 0})
+		t.Cleanup(local.Close)
+		s := New(storage.NewInMemoryStorage(), failingBackend{})
+		s.SetFailurePolicy(FailLocal, local)
+		// The local limiter charged the attempts, so it gets the reports.
+		for i := 0; i < 3; i++ {
+			if got := authorize(t, s, "judy", "192.0.2.10"); !got.Ok || got.Mode != ModeLocal {
+				t.Fatalf("expected refunded local attempt %d allowed, got %+v", i+1, got)
+			}
+			report(t, s, true)
+		}
+		s.SetReportPolicy(SuccessRefund, 1)
+		report(t, s, false)
+		if got := authorize(t, s, "judy", "192.0.2.10"); got.Ok {
+			t.Fatalf("expected the local penalty to use up the login bucket, got %+v", got)
+		}
+	})
+	t.Run("invalid ip", func(t *testing.T) {
+		s := newTestService(t)
+		if code := call(t, s.ReportHandler, ReportRequest{Login: "judy", IP: "nope"}, nil); code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for invalid ip, got %d", code)
+		}
+	})
+}
+
+func TestReserve(t *testing.T) {
+	s := newTestService(t)
+	req := AuthorizeRequest{Login: "kate", Password: "secret", IP: "192.0.2.11"}
+	for i := 0; i < 10; i++ {
+		resp, refund, err := s.Reserve(t.Context(), req)
+		if err != nil || !resp.Ok || refund == nil {
+			t.Fatalf("attempt %d: expected a refundable reservation, got %+v, %v", i+1, resp, err)
+		}
+		if err := refund(t.Context()); err != nil {
+			t.Fatalf("refund: %v", err)
+		}
+	}
+	if _, err := s.AddToWhitelist(t.Context(), "198.51.100.0/24", false, 0); err != nil {
+		t.Fatalf("AddToWhitelist: %v", err)
+	}
+	req.IP = "198.51.100.1"
+	if resp, refund, err := s.Reserve(t.Context(), req); err != nil || !resp.Ok || refund != nil {
+		t.Fatalf("expected nothing to refund for a whitelisted IP, got %+v, refund=%v, %v", resp, refund != nil, err)
+	}
+}
+
+func TestDecisionMetrics(t *testing.T) {
+	s := newTestService(t)
+	counter := func(outcome, reason string) float64 {
+		return testutil.ToFloat64(metrics.Decisions.WithLabelValues(outcome, reason, ModeNormal))
+	}
+	allowed, limited := counter("allowed", "within_limits"), counter("denied", "rate_limit")
+	whitelisted := counter("allowed", "whitelist")
+	loginDenials := testutil.ToFloat64(metrics.RateLimited.WithLabelValues("login"))
+	ipDenials := testutil.ToFloat64(metrics.RateLimited.WithLabelValues("ip"))
+	for i := 0; i < 4; i++ {
+		authorize(t, s, "kate", "192.0.2.11")
+	}
+	var resp ListReponse
+	call(t, s.WhitelistHandler, ListRequest{IP: "192.0.2.12"}, &resp)
+	authorize(t, s, "kate", "192.0.2.12")
+	if got := counter("allowed", "within_limits") - allowed; got != 3 {
+		t.Fatalf("expected 3 allowed decisions, got %v", got)
+	}
+	if got := counter("denied", "rate_limit") - limited; got != 1 {
+		t.Fatalf("expected 1 rate limit denial, got %v", got)
+	}
+	if got := counter("allowed", "whitelist") - whitelisted; got != 1 {
+		t.Fatalf("expected 1 whitelisted decision, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.RateLimited.WithLabelValues("login")) - loginDenials; got != 1 {
+		t.Fatalf("expected the login bucket to be counted, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.RateLimited.WithLabelValues("ip")) - ipDenials; got != 0 {
+		t.Fatalf("expected the ip bucket not to be counted, got %v", got)
+	}
+}
+
+func TestAuthorizeSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	s := newTestService(t)
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	if _, err := s.Authorize(ctx, AuthorizeRequest{Login: "liam", Password: "pw", IP: "192.0.2.13"}); err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	parent.End()
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	authorizeSpan, ok := spans["service.authorize"]
+	if !ok || authorizeSpan.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Fatalf("expected service.authorize under the request span, got %v", spans)
+	}
+	for _, name := range []string{"storage.InBlacklist", "storage.InWhitelist", "bucket.take"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("expected a %s span", name)
+		}
+		if span.Parent().SpanID() != authorizeSpan.SpanContext().SpanID() {
+			t.Fatalf("expected %s under service.authorize", name)
+		}
+	}
+	if events := spans["bucket.take"].Events(); len(events) != 3 {
+		t.Fatalf("expected one event per bucket check, got %d", len(events))
+	}
+}
+
+func TestAuthorizeNeverLogsPassword(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, logging.Config{Level: "debug"})
+	if err != nil {
+		t.Fatalf("logging.New: %v", err)
+	}
+	prev := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	s := newTestService(t)
+	for i := 0; i < 4; i++ {
+		if _, err := s.Authorize(context.Background(), AuthorizeRequest{Login: "mia", Password: "hunter2", IP: "192.0.2.14"}); err != nil {
+			t.Fatalf("Authorize: %v", err)
+		}
+	}
+	if buf.Len() == 0 {
+		t.Fatal("expected debug output from authorize")
+	}
+	if strings.Contains(buf.String(), "hunter2") {
+		t.Fatalf("expected the password never to be logged, got %s", buf.String())
+	}
+}
+
+func TestViewListsFailure(t *testing.T) {
+	rl := bucket.NewMemoryRateLimiter(5*time.Minute,
+		bucket.Config{Capacity: 1, RefillPerMinute: 0},
+		bucket.Config{Capacity: 1, RefillPerMinute: 0},
+		bucket.Config{Capacity: 1, RefillPerMinute: 0})
+	t.Cleanup(rl.Close)
+	s := New(failingStore{}, rl)
+	s.SetAutoBan(autoban.New(autoban.Config{Threshold: 1, Window: time.Minute}, failingStore{}))
+	if code := call(t, s.ViewListsHandler, nil, nil); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 when the lists cannot be read, got %d", code)
+	}
+	if code := call(t, s.ViewAutoBanHandler, nil, nil); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 when the autoban entries cannot be read, got %d", code)
+	}
+}
+
+func TestOverrideHandlers(t *testing.T) {
+	rl := bucket.NewMemoryRateLimiter(5*time.Minute,
+		bucket.Config{Capacity: 1, RefillPerMinute: 0},
+		bucket.Config{Capacity: 100, RefillPerMinute: 0},
+		bucket.Config{Capacity: 100, RefillPerMinute: 0})
+	t.Cleanup(rl.Close)
+	store := storage.NewInMemoryStorage()
+	t.Cleanup(store.Close)
+	table := overrides.NewTable(overrides.NewMemoryStore())
+	rl.SetOverrides(table)
+	s := New(store, rl)
+	s.SetOverrides(table)
+
+	add := map[string]any{"kind": "login", "match": "backup", "capacity": 3, "refill_per_minute": 1}
+	var resp ListReponse
+	if code := call(t, s.OverrideAddHandler, add, &resp); code != http.StatusOK || !resp.Ok {
+		t.Fatalf("expected the override added, got %d %+v", code, resp)
+	}
+	for i := range 3 {
+		if got := authorize(t, s, "backup", "192.0.2.20"); !got.Ok {
+			t.Fatalf("attempt %d: expected the override to allow it, got %+v", i+1, got)
+		}
+	}
+	if code := call(t, s.OverrideAddHandler, map[string]any{"kind": "cidr", "match": "nope", "capacity": 3}, nil); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid network, got %d", code)
+	}
+
+	var list OverrideList
+	if code := call(t, s.ViewOverridesHandler, nil, &list); code != http.StatusOK || len(list.Overrides) != 1 {
+		t.Fatalf("expected the one override listed, got %d %+v", code, list)
+	}
+	del := map[string]string{"kind": "login", "match": "backup"}
+	if code := call(t, s.OverrideDelHandler, del, &resp); code != http.StatusOK || !resp.Ok {
+		t.Fatalf("expected the override removed, got %d %+v", code, resp)
+	}
+	if call(t, s.OverrideDelHandler, del, &resp); resp.Ok || resp.Reason == "" {
+		t.Fatalf("expected a second removal to report the missing override, got %+v", resp)
+	}
+}
+
+func TestOverrideHandlersRedactLogins(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, logging.Config{Level: "info", RedactLogins: true})
+	if err != nil {
+		t.Fatalf("logging.New: %v", err)
+	}
+	prev := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	s := newTestService(t)
+	s.SetOverrides(overrides.NewTable(overrides.NewMemoryStore()))
+	call(t, s.OverrideAddHandler, map[string]any{"kind": "login", "match": "backup", "capacity": 3}, nil)
+	call(t, s.OverrideDelHandler, map[string]string{"kind": "login", "match": "backup"}, nil)
+	call(t, s.OverrideAddHandler, map[string]any{"kind": "cidr", "match": "192.0.2.0/24", "capacity": 3}, nil)
+	if strings.Contains(buf.String(), "backup") || !strings.Contains(buf.String(), logging.HashLogin("backup")) {
+		t.Fatalf("expected the override login redacted, got %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "192.0.2.0/24") {
+		t.Fatalf("expected the override network logged, got %s", buf.String())
+	}
+}
+
+func TestAuthorizeSubnetReason(t *testing.T) {
+	large := bucket.Config{Capacity: 100, RefillPerMinute: 1}
+	rl := bucket.NewMemoryRateLimiter(5*time.Minute, large, large, large)
+	t.Cleanup(rl.Close)
+	subnets, err := bucket.ParseSubnets("ipv4/24=2")
+	if err != nil {
+		t.Fatalf("ParseSubnets: %v", err)
+	}
+	if err := rl.SetLimits(large, large, large, subnets, bucket.ConsumeEach); err != nil {
+		t.Fatalf("SetLimits: %v", err)
+	}
+	store := storage.NewInMemoryStorage()
+	t.Cleanup(store.Close)
+	s := New(store, rl)
+	authorize(t, s, "a", "198.51.100.1")
+	authorize(t, s, "b", "198.51.100.2")
+	want := AuthorizeResponse{Ok: false, Reason: ReasonSubnetRateLimited, Mode: ModeNormal, Subnet: "ipv4/24"}
+	if got := authorize(t, s, "c", "198.51.100.3"); got != want {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// reapInterval is how often expired entries are removed from the lists.
+const reapInterval = time.Second
+
+// expiryTime turns a TTL into the time an entry added at now expires, zero
+// meaning never.
+func expiryTime(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+// reaper runs the periodic removal of expired entries and tells the
+// registered hooks about them. Entries removed elsewhere, while adding to a
+// list, are queued and reported by the next reap so that hooks never run
+// inside a storage call.
+type reaper struct {
+	mu      sync.Mutex
+	hooks   []ExpireFunc
+	pending map[string][]*net.IPNet
+	stop    chan struct{}
+	once    sync.Once
+}
+
+func newReaper(reap func()) *reaper {
+	r := &reaper{pending: make(map[string][]*net.IPNet), stop: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(reapInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				reap()
+			}
+		}
+	}()
+	return r
+}
+
+func (r *reaper) OnExpire(fn ExpireFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks = append(r.hooks, fn)
+}
+
+// queue holds expired entries of list back for the next notify.
+func (r *reaper) queue(list string, nets []*net.IPNet) {
+	if len(nets) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending[list] = append(r.pending[list], nets...)
+}
+
+// notify calls the hooks for nets and anything queued for list. Callers must
+// not hold any lock the hooks could need.
+func (r *reaper) notify(list string, nets []*net.IPNet) {
+	r.mu.Lock()
+	hooks := r.hooks
+	nets = append(r.pending[list], nets...)
+	delete(r.pending, list)
+	r.mu.Unlock()
+	for _, n := range nets {
+		for _, fn := range hooks {
+			fn(list, *n)
+		}
+	}
+}
+
+func (r *reaper) Close() {
+	r.once.Do(func() { close(r.stop) })
+}
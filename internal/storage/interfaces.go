@@ -1,13 +1,37 @@
 package storage
 
-import "net"
+import (
+	"context"
+	"net"
+	"time"
+)
 
+// ExpireFunc is called with the list name ("whitelist" or "blacklist") and
+// the network of every entry removed because its TTL ran out.
+type ExpireFunc func(list string, ip net.IPNet)
+
+// Storage keeps the whitelist and the blacklist. Entries added with a
+// positive ttl expire after it, a zero ttl keeps them until removed. Lookups
+// and reads return an error when the lists could not be read; callers must
+// not take that for a miss or for empty lists.
 type Storage interface {
-	InWhitelist(ip net.IP) bool
-	InBlacklist(ip net.IP) bool
-	AddToWhitelist(ip net.IPNet, force bool) (bool, error)
-	AddToBlacklist(ip net.IPNet, force bool) (bool, error)
-	BlackWhiteLists() (whitelist map[string]*net.IPNet, blacklist map[string]*net.IPNet)
-	RemoveFromWhitelist(ip net.IPNet) (bool, error)
-	RemoveFromBlacklist(ip net.IPNet) (bool, error)
+	InWhitelist(ctx context.Context, ip net.IP) (bool, error)
+	InBlacklist(ctx context.Context, ip net.IP) (bool, error)
+	AddToWhitelist(ctx context.Context, ip net.IPNet, force bool, ttl time.Duration) (bool, error)
+	AddToBlacklist(ctx context.Context, ip net.IPNet, force bool, ttl time.Duration) (bool, error)
+	// AddTaggedToBlacklist is AddToBlacklist storing tag with the entry. The
+	// tag goes with the entry when it is removed, replaced or expires.
+	AddTaggedToBlacklist(ctx context.Context, ip net.IPNet, force bool, ttl time.Duration, tag string) (bool, error)
+	// BlacklistTags returns the tags of the blacklist entries that have one,
+	// by key.
+	BlacklistTags(ctx context.Context) (map[string]string, error)
+	BlackWhiteLists(ctx context.Context) (whitelist map[string]*net.IPNet, blacklist map[string]*net.IPNet, err error)
+	// Expirations returns when the entries that have a TTL expire, by key.
+	Expirations(ctx context.Context) (whitelist map[string]time.Time, blacklist map[string]time.Time, err error)
+	RemoveFromWhitelist(ctx context.Context, ip net.IPNet) (bool, error)
+	RemoveFromBlacklist(ctx context.Context, ip net.IPNet) (bool, error)
+	// OnExpire registers fn to be called for expired entries.
+	OnExpire(fn ExpireFunc)
+	// Close stops background reaping of expired entries.
+	Close()
 }
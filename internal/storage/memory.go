@@ -2,108 +2,215 @@ package storage
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"maps"
 	"net"
 	"sync"
-
-	"github.com/meladark/special-train/pkg/netutils"
+	"time"
 )
 
 type InMemoryStorage struct {
-	whitelist map[string]*net.IPNet
-	blacklist map[string]*net.IPNet
+	*reaper
+	whitelist *ipList
+	blacklist *ipList
 	mu        sync.RWMutex
+	now       func() time.Time
 }
 
 func NewInMemoryStorage() *InMemoryStorage {
-	return &InMemoryStorage{
-		whitelist: make(map[string]*net.IPNet),
-		blacklist: make(map[string]*net.IPNet),
+	s := &InMemoryStorage{
+		whitelist: newIPList(),
+		blacklist: newIPList(),
+		now:       time.Now,
 	}
+	s.reaper = newReaper(s.reap)
+	return s
 }
 
-func (s *InMemoryStorage) InWhitelist(ip net.IP) bool {
+func (s *InMemoryStorage) InWhitelist(_ context.Context, ip net.IP) (bool, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	for _, n := range s.whitelist {
-		if n.Contains(ip) {
-			return true
-		}
-	}
-	return false
+	return s.whitelist.containsAt(ip, s.now()), nil
 }
 
-func (s *InMemoryStorage) InBlacklist(ip net.IP) bool {
+func (s *InMemoryStorage) InBlacklist(_ context.Context, ip net.IP) (bool, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	for _, n := range s.blacklist {
-		if n.Contains(ip) {
-			return true
-		}
+	return s.blacklist.containsAt(ip, s.now()), nil
+}
+
+// dropExpired removes the entries of l expired by now and returns them.
+func dropExpired(l *ipList, now time.Time) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, key := range l.expiredKeys(now) {
+		nets = append(nets, l.nets[key])
+		l.remove(key)
 	}
-	return false
+	return nets
+}
+
+func (s *InMemoryStorage) reap() {
+	s.mu.Lock()
+	now := s.now()
+	white, black := dropExpired(s.whitelist, now), dropExpired(s.blacklist, now)
+	s.mu.Unlock()
+	s.notify("whitelist", white)
+	s.notify("blacklist", black)
 }
 
 func IPNetEqual(a, b *net.IPNet) bool {
 	return a.IP.Equal(b.IP) && bytes.Equal(a.Mask, b.Mask)
 }
 
+// listChange describes how adding an entry modifies its target list:
+// the keys to drop (if any) and the network to store under key.
+type listChange struct {
+	remove []string
+	key    string
+	add    *net.IPNet
+}
+
+// planAdd applies the overlap rules shared by every Storage implementation.
+// An entry already covered by an entry of the target list that lasts at
+// least as long is rejected. An entry covering narrower ones replaces those
+// it outlives; the others keep their own expiry, and a tagged entry never
+// replaces untagged ones, so an automatic ban does not lift a manual entry.
+// An entry overlapping the other list is only accepted with force. It
+// reports the change to make to target, the new entry expiring at expires,
+// without modifying it.
+func planAdd(
+	target *ipList,
+	other *ipList,
+	ip net.IPNet,
+	force bool,
+	expires time.Time,
+	tag string,
+	listName string,
+	otherListName string,
+) (change listChange, ok bool, err error) {
+	for _, key := range target.coveringKeys(&ip) {
+		if !outlives(expires, target.expires[key]) {
+			return listChange{}, false, fmt.Errorf("IP already in %s: %s", listName, target.nets[key].String())
+		}
+	}
+	if n := other.overlapping(&ip); n != nil && !force {
+		return listChange{}, false, fmt.Errorf("IP overlap in %s: %s", otherListName, n.String())
+	}
+	change = listChange{key: ip.String(), add: &ip}
+	for _, key := range target.covered(&ip) {
+		if outlives(target.expires[key], expires) || (tag != "" && target.tags[key] == "") {
+			continue
+		}
+		if len(change.remove) == 0 {
+			err = fmt.Errorf("IP overlaps in %s: %s", listName, target.nets[key].String())
+		}
+		change.remove = append(change.remove, key)
+	}
+	return change, true, err
+}
+
+// outlives reports whether an entry expiring at a, zero for never, lasts
+// strictly longer than one expiring at b.
+func outlives(a, b time.Time) bool {
+	return !b.IsZero() && (a.IsZero() || a.After(b))
+}
+
 func (s *InMemoryStorage) addIP(
-	targetMap map[string]*net.IPNet,
-	otherMap map[string]*net.IPNet,
+	target *ipList,
+	other *ipList,
 	ip net.IPNet,
 	force bool,
+	ttl time.Duration,
+	tag string,
 	listName string,
 	otherListName string,
 ) (ok bool, err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	for oldKey, n := range targetMap {
-		if res, ovlp := netutils.Overlaps(n, &ip); res {
-			if IPNetEqual(ovlp, n) {
-				return false, fmt.Errorf("IP already in %s: %s", listName, n.String())
-			}
-			delete(targetMap, oldKey)
-			targetMap[ip.String()] = ovlp
-			return true, fmt.Errorf("IP overlaps in %s: %s", listName, n.String())
-		}
+	// Expired entries must not block the new one. The hooks hear about them
+	// from the reaper, not from within this call.
+	now := s.now()
+	s.queue("whitelist", dropExpired(s.whitelist, now))
+	s.queue("blacklist", dropExpired(s.blacklist, now))
+	expires := expiryTime(now, ttl)
+	change, ok, err := planAdd(target, other, ip, force, expires, tag, listName, otherListName)
+	for _, key := range change.remove {
+		target.remove(key)
 	}
-	for _, n := range otherMap {
-		if res, _ := netutils.Overlaps(n, &ip); res {
-			if !force {
-				return false, fmt.Errorf("IP overlap in %s: %s", otherListName, n.String())
-			}
-		}
+	if change.add != nil {
+		target.put(change.key, change.add)
+		target.setExpiry(change.key, expires)
+		target.setTag(change.key, tag)
 	}
-	targetMap[ip.String()] = &ip
-	return true, nil
+	return ok, err
 }
 
-func (s *InMemoryStorage) AddToWhitelist(ip net.IPNet, force bool) (bool, error) {
-	return s.addIP(s.whitelist, s.blacklist, ip, force, "whitelist", "blacklist")
+func (s *InMemoryStorage) AddToWhitelist(_ context.Context, ip net.IPNet, force bool, ttl time.Duration) (bool, error) {
+	return s.addIP(s.whitelist, s.blacklist, ip, force, ttl, "", "whitelist", "blacklist")
 }
 
-func (s *InMemoryStorage) AddToBlacklist(ip net.IPNet, force bool) (bool, error) {
-	return s.addIP(s.blacklist, s.whitelist, ip, force, "blacklist", "whitelist")
+func (s *InMemoryStorage) AddToBlacklist(_ context.Context, ip net.IPNet, force bool, ttl time.Duration) (bool, error) {
+	return s.addIP(s.blacklist, s.whitelist, ip, force, ttl, "", "blacklist", "whitelist")
 }
 
-func (s *InMemoryStorage) BlackWhiteLists() (whitelist map[string]*net.IPNet, blacklist map[string]*net.IPNet) {
+func (s *InMemoryStorage) AddTaggedToBlacklist(
+	_ context.Context,
+	ip net.IPNet,
+	force bool,
+	ttl time.Duration,
+	tag string,
+) (bool, error) {
+	return s.addIP(s.blacklist, s.whitelist, ip, force, ttl, tag, "blacklist", "whitelist")
+}
+
+func (s *InMemoryStorage) BlacklistTags(context.Context) (map[string]string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.whitelist, s.blacklist
+	return s.blacklist.liveTags(s.now()), nil
 }
 
-func (s *InMemoryStorage) RemoveFromWhitelist(ip net.IPNet) (bool, error) {
-	if _, ok := s.whitelist[ip.String()]; ok {
-		delete(s.whitelist, ip.String())
+func (s *InMemoryStorage) BlackWhiteLists(context.Context) (
+	whitelist map[string]*net.IPNet,
+	blacklist map[string]*net.IPNet,
+	err error,
+) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := s.now()
+	whitelist, blacklist = maps.Clone(s.whitelist.nets), maps.Clone(s.blacklist.nets)
+	maps.DeleteFunc(whitelist, func(key string, _ *net.IPNet) bool { return s.whitelist.expired(key, now) })
+	maps.DeleteFunc(blacklist, func(key string, _ *net.IPNet) bool { return s.blacklist.expired(key, now) })
+	return whitelist, blacklist, nil
+}
+
+func (s *InMemoryStorage) Expirations(context.Context) (
+	whitelist map[string]time.Time,
+	blacklist map[string]time.Time,
+	err error,
+) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := s.now()
+	whitelist, blacklist = maps.Clone(s.whitelist.expires), maps.Clone(s.blacklist.expires)
+	maps.DeleteFunc(whitelist, func(_ string, t time.Time) bool { return !now.Before(t) })
+	maps.DeleteFunc(blacklist, func(_ string, t time.Time) bool { return !now.Before(t) })
+	return whitelist, blacklist, nil
+}
+
+func (s *InMemoryStorage) RemoveFromWhitelist(_ context.Context, ip net.IPNet) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.whitelist.remove(ip.String()) {
 		return true, nil
 	}
 	return false, fmt.Errorf("IP not in whitelist: %s", ip.String())
 }
 
-func (s *InMemoryStorage) RemoveFromBlacklist(ip net.IPNet) (bool, error) {
-	if _, ok := s.blacklist[ip.String()]; ok {
-		delete(s.blacklist, ip.String())
+func (s *InMemoryStorage) RemoveFromBlacklist(_ context.Context, ip net.IPNet) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.blacklist.remove(ip.String()) {
 		return true, nil
 	}
 	return false, fmt.Errorf("IP not in blacklist: %s", ip.String())
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"testing"
+)
+
+const benchEntries = 100000
+
+// benchStorage fills a blacklist with benchEntries disjoint networks, half of
+// them IPv4 /24s and half IPv6 /64s.
+func benchStorage(b *testing.B) *InMemoryStorage {
+	b.Helper()
+	s := NewInMemoryStorage()
+	for i := 0; i < benchEntries/2; i++ {
+		ip4 := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip4, uint32(i)<<8|10<<24)
+		if ok, err := s.AddToBlacklist(b.Context(), net.IPNet{IP: ip4, Mask: net.CIDRMask(24, 32)}, false, 0); !ok {
+			b.Fatalf("failed to add %s: %v", ip4, err)
+		}
+		ip6 := make(net.IP, net.IPv6len)
+		ip6[0], ip6[1], ip6[2], ip6[3] = 0x20, 0x01, 0x0d, 0xb8
+		binary.BigEndian.PutUint32(ip6[4:], uint32(i))
+		if ok, err := s.AddToBlacklist(b.Context(), net.IPNet{IP: ip6, Mask: net.CIDRMask(64, 128)}, false, 0); !ok {
+			b.Fatalf("failed to add %s: %v", ip6, err)
+		}
+	}
+	return s
+}
+
+func benchLookups(b *testing.B, s *InMemoryStorage, ips []net.IP) {
+	b.Helper()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = s.InBlacklist(b.Context(), ips[i%len(ips)])
+	}
+}
+
+func BenchmarkInBlacklist100kIPv4(b *testing.B) {
+	s := benchStorage(b)
+	rnd := rand.New(rand.NewSource(1)) //nolint: gosec
+	ips := make([]net.IP, 1024)
+	for i := range ips {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, rnd.Uint32())
+		ips[i] = ip
+	}
+	benchLookups(b, s, ips)
+}
+
+func BenchmarkInBlacklist100kIPv6(b *testing.B) {
+	s := benchStorage(b)
+	rnd := rand.New(rand.NewSource(1)) //nolint: gosec
+	ips := make([]net.IP, 1024)
+	for i := range ips {
+		ip := make(net.IP, net.IPv6len)
+		ip[0], ip[1], ip[2], ip[3] = 0x20, 0x01, 0x0d, 0xb8
+		binary.BigEndian.PutUint32(ip[4:], uint32(rnd.Intn(benchEntries)))
+		binary.BigEndian.PutUint64(ip[8:], rnd.Uint64())
+		ips[i] = ip
+	}
+	benchLookups(b, s, ips)
+}
+
+func BenchmarkInBlacklist100kHit(b *testing.B) {
+	s := benchStorage(b)
+	benchLookups(b, s, []net.IP{mustIP("10.0.0.1"), mustIP("2001:db8::1")})
+}
+
+func BenchmarkAddToBlacklist100k(b *testing.B) {
+	s := benchStorage(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, uint32(i)|172<<24)
+		n := net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}
+		s.AddToBlacklist(b.Context(), n, false, 0)
+		s.RemoveFromBlacklist(b.Context(), n)
+	}
+}
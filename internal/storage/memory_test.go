@@ -1,9 +1,11 @@
 package storage
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"testing"
+	"time"
 )
 
 func mustCIDR(s string) net.IPNet {
@@ -21,127 +23,311 @@ func mustIP(s string) net.IP {
 	panic(fmt.Sprintf("invalid IP: %s", s))
 }
 
+// listed reports the result of a list lookup that must not fail.
+func listed(t *testing.T, lookup func(context.Context, net.IP) (bool, error), ip net.IP) bool {
+	t.Helper()
+	found, err := lookup(t.Context(), ip)
+	if err != nil {
+		t.Fatalf("lookup %s: %v", ip, err)
+	}
+	return found
+}
+
+func lists(t *testing.T, s Storage) (whitelist, blacklist map[string]*net.IPNet) {
+	t.Helper()
+	whitelist, blacklist, err := s.BlackWhiteLists(t.Context())
+	if err != nil {
+		t.Fatalf("BlackWhiteLists: %v", err)
+	}
+	return whitelist, blacklist
+}
+
+func expirations(t *testing.T, s Storage) (whitelist, blacklist map[string]time.Time) {
+	t.Helper()
+	whitelist, blacklist, err := s.Expirations(t.Context())
+	if err != nil {
+		t.Fatalf("Expirations: %v", err)
+	}
+	return whitelist, blacklist
+}
+
+func blacklistTags(t *testing.T, s Storage) map[string]string {
+	t.Helper()
+	tags, err := s.BlacklistTags(t.Context())
+	if err != nil {
+		t.Fatalf("BlacklistTags: %v", err)
+	}
+	return tags
+}
+
 func TestAddToWhitelist(t *testing.T) { //nolint: dupl
 	s := NewInMemoryStorage()
 	ip := mustCIDR("192.168.1.0/24")
-	ok, err := s.AddToWhitelist(ip, false)
+	ok, err := s.AddToWhitelist(t.Context(), ip, false, 0)
 	if !ok || err != nil {
 		t.Fatalf("expected success, got ok=%v err=%v", ok, err)
 	}
-	_, err = s.AddToWhitelist(ip, false)
+	_, err = s.AddToWhitelist(t.Context(), ip, false, 0)
 	if err == nil || err.Error() != fmt.Sprintf("IP already in whitelist: %s", ip.String()) {
 		t.Errorf("expected full overlap error, got %v", err)
 	}
 	ip2 := mustCIDR("192.168.1.128/25")
-	ok, err = s.AddToWhitelist(ip2, false)
+	ok, err = s.AddToWhitelist(t.Context(), ip2, false, 0)
 	if ok || err == nil || err.Error() != fmt.Sprintf("IP already in whitelist: %s", ip.String()) {
 		t.Errorf("expected full overlap (subset), got ok=%v err=%v", ok, err)
 	}
-	if _, found := s.whitelist[ip2.String()]; found {
+	if _, found := s.whitelist.nets[ip2.String()]; found {
 		t.Errorf("ip2 should NOT be added to whitelist")
 	}
 	ip3 := mustCIDR("10.0.0.0/8")
-	s.blacklist[ip3.String()] = &ip3
-	_, err = s.AddToWhitelist(ip3, false)
+	s.blacklist.put(ip3.String(), &ip3)
+	_, err = s.AddToWhitelist(t.Context(), ip3, false, 0)
 	if err == nil || err.Error() != fmt.Sprintf("IP overlap in blacklist: %s", ip3.String()) {
 		t.Errorf("expected blacklist overlap error, got %v", err)
 	}
-	ok, err = s.AddToWhitelist(ip3, true)
+	ok, err = s.AddToWhitelist(t.Context(), ip3, true, 0)
 	if !ok || err != nil {
 		t.Errorf("expected force insert success, got ok=%v err=%v", ok, err)
 	}
 	ip4 := mustCIDR("8.8.8.1/32")
 	ip5 := mustCIDR("8.8.8.2/10")
-	s.AddToWhitelist(ip4, true)
-	s.AddToWhitelist(ip5, true)
-	s.BlackWhiteLists()
-	s.RemoveFromWhitelist(ip5)
-	s.RemoveFromWhitelist(ip5)
+	s.AddToWhitelist(t.Context(), ip4, true, 0)
+	s.AddToWhitelist(t.Context(), ip5, true, 0)
+	lists(t, s)
+	s.RemoveFromWhitelist(t.Context(), ip5)
+	s.RemoveFromWhitelist(t.Context(), ip5)
 }
 
 func TestAddToBlacklist(t *testing.T) { //nolint: dupl
 	s := NewInMemoryStorage()
 	ip := mustCIDR("172.16.0.0/16")
-	ok, err := s.AddToBlacklist(ip, false)
+	ok, err := s.AddToBlacklist(t.Context(), ip, false, 0)
 	if !ok || err != nil {
 		t.Fatalf("expected success, got ok=%v err=%v", ok, err)
 	}
-	_, err = s.AddToBlacklist(ip, false)
+	_, err = s.AddToBlacklist(t.Context(), ip, false, 0)
 	if err == nil || err.Error() != fmt.Sprintf("IP already in blacklist: %s", ip.String()) {
 		t.Errorf("expected full overlap error, got %v", err)
 	}
 	ip2 := mustCIDR("172.16.128.0/17")
-	ok, err = s.AddToBlacklist(ip2, false)
+	ok, err = s.AddToBlacklist(t.Context(), ip2, false, 0)
 	if ok || err == nil || err.Error() != fmt.Sprintf("IP already in blacklist: %s", ip.String()) {
 		t.Errorf("expected full overlap (subset), got ok=%v err=%v", ok, err)
 	}
-	if _, found := s.blacklist[ip2.String()]; found {
+	if _, found := s.blacklist.nets[ip2.String()]; found {
 		t.Errorf("ip2 should NOT be added to blacklist")
 	}
 	ip3 := mustCIDR("10.10.0.0/16")
-	s.whitelist[ip3.String()] = &ip3
-	_, err = s.AddToBlacklist(ip3, false)
+	s.whitelist.put(ip3.String(), &ip3)
+	_, err = s.AddToBlacklist(t.Context(), ip3, false, 0)
 	if err == nil || err.Error() != fmt.Sprintf("IP overlap in whitelist: %s", ip3.String()) {
 		t.Errorf("expected whitelist overlap error, got %v", err)
 	}
-	ok, err = s.AddToBlacklist(ip3, true)
+	ok, err = s.AddToBlacklist(t.Context(), ip3, true, 0)
 	if !ok || err != nil {
 		t.Errorf("expected force insert success, got ok=%v err=%v", ok, err)
 	}
 	ip4 := mustCIDR("8.8.8.1/32")
 	ip5 := mustCIDR("8.8.8.2/10")
-	s.AddToBlacklist(ip4, true)
-	s.AddToBlacklist(ip5, true)
-	s.BlackWhiteLists()
-	s.RemoveFromBlacklist(ip4)
-	s.RemoveFromBlacklist(ip4)
+	s.AddToBlacklist(t.Context(), ip4, true, 0)
+	s.AddToBlacklist(t.Context(), ip5, true, 0)
+	lists(t, s)
+	s.RemoveFromBlacklist(t.Context(), ip4)
+	s.RemoveFromBlacklist(t.Context(), ip4)
 }
 
 func TestPartialOverlap(t *testing.T) {
 	w := NewInMemoryStorage()
 	ip1 := mustCIDR("192.168.1.0/25")
-	ok, err := w.AddToWhitelist(ip1, false)
+	ok, err := w.AddToWhitelist(t.Context(), ip1, false, 0)
 	if !ok || err != nil {
 		t.Fatalf("expected success adding first subnet, got ok=%v err=%v", ok, err)
 	}
 	ip2 := mustCIDR("192.168.1.64/25")
-	ok, err = w.AddToWhitelist(ip2, false)
+	ok, err = w.AddToWhitelist(t.Context(), ip2, false, 0)
 	if ok || err == nil {
 		t.Errorf("expected partial overlap warning, got ok=%v err=%v", ok, err)
 	}
-	if _, found := w.whitelist[ip2.String()]; !found {
+	if _, found := w.whitelist.nets[ip2.String()]; !found {
 		t.Errorf("partial overlapping subnet should be added to whitelist")
 	}
-	if !w.InWhitelist(mustIP("192.168.1.65")) {
+	if !listed(t, w.InWhitelist, mustIP("192.168.1.65")) {
 		t.Error("expected IP to be in whitelist")
 	}
-	if w.InWhitelist(mustIP("192.168.2.128")) {
+	if listed(t, w.InWhitelist, mustIP("192.168.2.128")) {
 		t.Error(
 			"expected IP to NOT be in whitelist",
 		)
 	}
 	b := NewInMemoryStorage()
 	ip3 := mustCIDR("10.0.0.0/25")
-	ok, err = b.AddToBlacklist(ip3, false)
+	ok, err = b.AddToBlacklist(t.Context(), ip3, false, 0)
 	if !ok || err != nil {
 		t.Fatalf("expected success adding first subnet, got ok=%v err=%v", ok, err)
 	}
 	ip4 := mustCIDR("10.0.0.64/25")
-	ok, err = b.AddToBlacklist(ip4, false)
+	ok, err = b.AddToBlacklist(t.Context(), ip4, false, 0)
 	if ok || err == nil {
 		t.Errorf("expected partial overlap warning, got ok=%v err=%v", ok, err)
 	}
-	if _, found := b.blacklist[ip4.String()]; !found {
+	if _, found := b.blacklist.nets[ip4.String()]; !found {
 		t.Errorf("partial overlapping subnet should be added to blacklist")
 	}
-	if !b.InBlacklist(mustIP("10.0.0.65")) {
+	if !listed(t, b.InBlacklist, mustIP("10.0.0.65")) {
 		t.Error(
 			"expected IP to be in blacklist",
 		)
 	}
-	if b.InBlacklist(mustIP("10.0.1.128")) {
+	if listed(t, b.InBlacklist, mustIP("10.0.1.128")) {
 		t.Error(
 			"expected IP to NOT be in blacklist",
 		)
 	}
 }
+
+func TestIPv6Lists(t *testing.T) {
+	s := NewInMemoryStorage()
+	ok, err := s.AddToBlacklist(t.Context(), mustCIDR("2001:db8::/64"), false, 0)
+	if !ok || err != nil {
+		t.Fatalf("expected success, got ok=%v err=%v", ok, err)
+	}
+	ok, err = s.AddToWhitelist(t.Context(), mustCIDR("2001:db8::1/128"), false, 0)
+	if ok || err == nil || err.Error() != "IP overlap in blacklist: 2001:db8::/64" {
+		t.Errorf("expected blacklist overlap error, got ok=%v err=%v", ok, err)
+	}
+	ok, err = s.AddToWhitelist(t.Context(), mustCIDR("10.0.0.0/8"), false, 0)
+	if !ok || err != nil {
+		t.Fatalf("IPv4 network must not overlap IPv6 one, got ok=%v err=%v", ok, err)
+	}
+	if !listed(t, s.InBlacklist, mustIP("2001:db8::abcd")) {
+		t.Error("expected IP to be in blacklist")
+	}
+	if listed(t, s.InBlacklist, mustIP("2001:db8:0:1::1")) {
+		t.Error("expected IP to NOT be in blacklist")
+	}
+	if listed(t, s.InWhitelist, mustIP("2001:db8::abcd")) {
+		t.Error("expected IPv6 address to NOT match IPv4 whitelist")
+	}
+}
+
+func TestListEntryTTL(t *testing.T) {
+	s := NewInMemoryStorage()
+	// The test reaps by hand, on its own clock.
+	s.Close()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	var expired []string
+	s.OnExpire(func(list string, ip net.IPNet) {
+		expired = append(expired, list+":"+ip.String())
+	})
+	if ok, err := s.AddToBlacklist(t.Context(), mustCIDR("192.0.2.0/24"), false, time.Minute); !ok {
+		t.Fatalf("expected success, got %v", err)
+	}
+	if ok, err := s.AddToBlacklist(t.Context(), mustCIDR("198.51.100.0/24"), false, 0); !ok {
+		t.Fatalf("expected success, got %v", err)
+	}
+	_, blacklist := expirations(t, s)
+	if len(blacklist) != 1 || !blacklist["192.0.2.0/24"].Equal(now.Add(time.Minute)) {
+		t.Fatalf("unexpected expirations: %v", blacklist)
+	}
+
+	now = now.Add(time.Minute)
+	if listed(t, s.InBlacklist, mustIP("192.0.2.1")) {
+		t.Error("expected expired entry to be ignored before reaping")
+	}
+	if _, blacklist := lists(t, s); len(blacklist) != 1 {
+		t.Errorf("expected only the permanent entry listed, got %v", blacklist)
+	}
+	s.reap()
+	if len(expired) != 1 || expired[0] != "blacklist:192.0.2.0/24" {
+		t.Fatalf("expected one expiry event, got %v", expired)
+	}
+	if !listed(t, s.InBlacklist, mustIP("198.51.100.1")) {
+		t.Error("expected permanent entry to stay")
+	}
+	// A TTL entry covering an expired one replaces it without complaint.
+	if ok, err := s.AddToWhitelist(t.Context(), mustCIDR("192.0.2.0/25"), false, time.Minute); !ok || err != nil {
+		t.Fatalf("expected success after expiry, got ok=%v err=%v", ok, err)
+	}
+}
+
+// testCoveredEntries adds wider entries with a TTL over narrower ones and
+// checks that the entries they do not outlive are back on their own once
+// the TTL is over. now is the clock of s, reap reaps s by hand.
+func testCoveredEntries(t *testing.T, s Storage, now *time.Time, reap func()) {
+	t.Helper()
+	add := func(ok bool, err error) {
+		t.Helper()
+		if !ok {
+			t.Fatalf("expected success, got %v", err)
+		}
+	}
+	add(s.AddToBlacklist(t.Context(), mustCIDR("192.0.2.0/24"), false, 0))
+	add(s.AddToBlacklist(t.Context(), mustCIDR("192.0.5.0/24"), false, 30*time.Second))
+	add(s.AddToBlacklist(t.Context(), mustCIDR("192.0.0.0/16"), false, time.Minute))
+	// A permanent entry nests inside the wider one that expires.
+	add(s.AddToBlacklist(t.Context(), mustCIDR("192.0.7.0/24"), false, 0))
+	if ok, _ := s.AddToBlacklist(t.Context(), mustCIDR("192.0.8.0/24"), false, 30*time.Second); ok {
+		t.Fatal("expected an entry outlived by the covering one to be refused")
+	}
+	// A tagged entry keeps the untagged ones it covers, whatever their TTL.
+	add(s.AddToBlacklist(t.Context(), mustCIDR("198.51.100.0/24"), false, 2*time.Minute))
+	add(s.AddTaggedToBlacklist(t.Context(), mustCIDR("198.51.0.0/16"), false, time.Minute, "auto"))
+	_, blacklist := lists(t, s)
+	for _, key := range []string{"192.0.2.0/24", "192.0.0.0/16", "192.0.7.0/24", "198.51.100.0/24", "198.51.0.0/16"} {
+		if _, ok := blacklist[key]; !ok {
+			t.Fatalf("expected %s listed, got %v", key, blacklist)
+		}
+	}
+	if _, ok := blacklist["192.0.5.0/24"]; ok {
+		t.Fatalf("expected the outlived entry replaced, got %v", blacklist)
+	}
+
+	*now = now.Add(time.Minute)
+	reap()
+	for ip, want := range map[string]bool{
+		"192.0.2.1": true, "192.0.7.1": true, "198.51.100.1": true,
+		"192.0.3.1": false, "192.0.5.1": false, "198.51.1.1": false,
+	} {
+		if listed(t, s.InBlacklist, mustIP(ip)) != want {
+			t.Errorf("expected %s blacklisted=%v after the wider entries expired", ip, want)
+		}
+	}
+}
+
+func TestCoveredEntries(t *testing.T) {
+	s := NewInMemoryStorage()
+	s.Close()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	testCoveredEntries(t, s, &now, s.reap)
+}
+
+func testBlacklistTags(t *testing.T, s Storage) {
+	t.Helper()
+	if ok, err := s.AddTaggedToBlacklist(t.Context(), mustCIDR("192.0.2.0/25"), false, 0, "auto"); !ok {
+		t.Fatalf("expected success, got %v", err)
+	}
+	if ok, err := s.AddTaggedToBlacklist(t.Context(), mustCIDR("198.51.100.0/24"), false, 0, "auto"); !ok {
+		t.Fatalf("expected success, got %v", err)
+	}
+	if tags := blacklistTags(t, s); len(tags) != 2 || tags["192.0.2.0/25"] != "auto" {
+		t.Fatalf("unexpected tags: %v", tags)
+	}
+	// A wider manual entry replaces the tagged one and its tag.
+	if ok, _ := s.AddToBlacklist(t.Context(), mustCIDR("192.0.2.0/24"), false, 0); !ok {
+		t.Fatal("expected the wider entry to replace the narrower one")
+	}
+	if ok, err := s.RemoveFromBlacklist(t.Context(), mustCIDR("198.51.100.0/24")); !ok {
+		t.Fatalf("expected removal, got %v", err)
+	}
+	if tags := blacklistTags(t, s); len(tags) != 0 {
+		t.Fatalf("expected tags to go with their entries, got %v", tags)
+	}
+}
+
+func TestBlacklistTags(t *testing.T) {
+	s := NewInMemoryStorage()
+	t.Cleanup(s.Close)
+	testBlacklistTags(t, s)
+}
@@ -0,0 +1,350 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/meladark/special-train/internal/metrics"
+	"github.com/redis/go-redis/v9"
+)
+
+// Lists live outside the "bf:" namespace so that bucket resets never touch them.
+// Each list hash has a sorted set next to it holding the expiry time, in unix
+// milliseconds, of the entries that have a TTL, a hash of the tags of tagged
+// entries and a counter bumped on every change so that instances know when
+// their cached copy is stale.
+const (
+	whitelistKey = "lists:whitelist"
+	blacklistKey = "lists:blacklist"
+)
+
+func expiryKey(key string) string {
+	return key + ":expiry"
+}
+
+func tagKey(key string) string {
+	return key + ":tag"
+}
+
+func versionKey(key string) string {
+	return key + ":version"
+}
+
+// reapScript removes the entries of the list hash KEYS[1] whose expiry in
+// KEYS[2] is at or before ARGV[1] along with their tags in KEYS[4], bumps the
+// version KEYS[3] if any went and returns their networks. Running it as a script lets only one of several
+// instances report each expired entry.
+var reapScript = redis.NewScript(`
+local removed = {}
+for _, key in ipairs(redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', ARGV[1])) do
+  redis.call('ZREM', KEYS[2], key)
+  local cidr = redis.call('HGET', KEYS[1], key)
+  if cidr then
+    redis.call('HDEL', KEYS[1], key)
+    redis.call('HDEL', KEYS[4], key)
+    table.insert(removed, cidr)
+  end
+end
+if #removed > 0 then
+  redis.call('INCR', KEYS[3])
+end
+return removed
+`)
+
+// cachedList is a decoded list together with the version it was read at.
+type cachedList struct {
+	version int64
+	list    *ipList
+}
+
+type RedisStorage struct {
+	*reaper
+	rdb        *redis.Client
+	maxRetries int
+	now        func() time.Time
+	mu         sync.Mutex
+	cache      map[string]cachedList
+}
+
+func NewRedisStorage(rdb *redis.Client) *RedisStorage {
+	s := &RedisStorage{
+		rdb:        rdb,
+		maxRetries: 5,
+		now:        time.Now,
+		cache:      make(map[string]cachedList),
+	}
+	s.reaper = newReaper(s.reap)
+	return s
+}
+
+// decodeList builds a list from the raw hash and expiry set, leaving out the
+// entries expired by now that are yet to be reaped.
+func decodeList(raw map[string]string, expires []redis.Z, now time.Time) *ipList {
+	list := newIPList()
+	for key, cidr := range raw {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			slog.Warn("skipping malformed list entry", "entry", cidr, "err", err)
+			continue
+		}
+		list.put(key, ipnet)
+	}
+	for _, z := range expires {
+		key, _ := z.Member.(string)
+		if _, ok := list.nets[key]; !ok {
+			continue
+		}
+		list.setExpiry(key, time.UnixMilli(int64(z.Score)))
+		if list.expired(key, now) {
+			list.remove(key)
+		}
+	}
+	return list
+}
+
+func (s *RedisStorage) load(ctx context.Context, c redis.Cmdable, key string) (*ipList, error) {
+	raw, err := c.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	expires, err := c.ZRangeWithScores(ctx, expiryKey(key), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodeList(raw, expires, s.now()), nil
+}
+
+func (s *RedisStorage) reap() {
+	ctx := context.Background()
+	now := strconv.FormatInt(s.now().UnixMilli(), 10)
+	for _, list := range []struct{ key, name string }{
+		{whitelistKey, "whitelist"},
+		{blacklistKey, "blacklist"},
+	} {
+		keys := []string{list.key, expiryKey(list.key), versionKey(list.key), tagKey(list.key)}
+		removed, err := reapScript.Run(ctx, s.rdb, keys, now).StringSlice()
+		if err != nil {
+			slog.Error("failed to reap list", "list", list.name, "err", err)
+			continue
+		}
+		nets := make([]*net.IPNet, 0, len(removed))
+		for _, cidr := range removed {
+			if _, ipnet, err := net.ParseCIDR(cidr); err == nil {
+				nets = append(nets, ipnet)
+			}
+		}
+		s.notify(list.name, nets)
+	}
+}
+
+// cached returns the list under key, reading it again only when its version
+// moved since the last read. The version is read first, so a change racing
+// with the read at worst causes one more read later.
+func (s *RedisStorage) cached(ctx context.Context, key string) (*ipList, error) {
+	version, err := s.rdb.Get(ctx, versionKey(key)).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	s.mu.Lock()
+	c, ok := s.cache[key]
+	s.mu.Unlock()
+	if ok && c.version == version {
+		return c.list, nil
+	}
+	list, err := s.load(ctx, s.rdb, key)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.cache[key] = cachedList{version: version, list: list}
+	s.mu.Unlock()
+	return list, nil
+}
+
+// contains looks ip up in the list under key. Cached lists are never
+// modified, only replaced, so they are safe to read without the lock.
+func (s *RedisStorage) contains(ctx context.Context, key string, ip net.IP) (bool, error) {
+	list, err := s.cached(ctx, key)
+	if err != nil {
+		return false, fmt.Errorf("storage: read %s: %w", key, err)
+	}
+	return list.containsAt(ip, s.now()), nil
+}
+
+func (s *RedisStorage) InWhitelist(ctx context.Context, ip net.IP) (bool, error) {
+	return s.contains(ctx, whitelistKey, ip)
+}
+
+func (s *RedisStorage) InBlacklist(ctx context.Context, ip net.IP) (bool, error) {
+	return s.contains(ctx, blacklistKey, ip)
+}
+
+func (s *RedisStorage) addIP(
+	ctx context.Context,
+	targetKey string,
+	otherKey string,
+	ip net.IPNet,
+	force bool,
+	ttl time.Duration,
+	tag string,
+	listName string,
+	otherListName string,
+) (bool, error) {
+	for attempts := 0; attempts < s.maxRetries; attempts++ {
+		var ok bool
+		var planErr error
+		expires := expiryTime(s.now(), ttl)
+		err := s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			target, err := s.load(ctx, tx, targetKey)
+			if err != nil {
+				return err
+			}
+			if target.tags, err = tx.HGetAll(ctx, tagKey(targetKey)).Result(); err != nil {
+				return err
+			}
+			other, err := s.load(ctx, tx, otherKey)
+			if err != nil {
+				return err
+			}
+			var change listChange
+			change, ok, planErr = planAdd(target, other, ip, force, expires, tag, listName, otherListName)
+			if change.add == nil {
+				return nil
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				if len(change.remove) > 0 {
+					pipe.HDel(ctx, targetKey, change.remove...)
+					pipe.ZRem(ctx, expiryKey(targetKey), change.remove)
+					pipe.HDel(ctx, tagKey(targetKey), change.remove...)
+				}
+				pipe.HSet(ctx, targetKey, change.key, change.add.String())
+				if !expires.IsZero() {
+					pipe.ZAdd(ctx, expiryKey(targetKey), redis.Z{Score: float64(expires.UnixMilli()), Member: change.key})
+				} else {
+					pipe.ZRem(ctx, expiryKey(targetKey), change.key)
+				}
+				if tag != "" {
+					pipe.HSet(ctx, tagKey(targetKey), change.key, tag)
+				} else {
+					pipe.HDel(ctx, tagKey(targetKey), change.key)
+				}
+				pipe.Incr(ctx, versionKey(targetKey))
+				return nil
+			})
+			return err
+		}, targetKey, otherKey, expiryKey(targetKey), expiryKey(otherKey), tagKey(targetKey))
+		if err == nil {
+			return ok, planErr
+		}
+		if errors.Is(err, redis.TxFailedErr) {
+			metrics.WatchRetries.WithLabelValues(listName).Inc()
+			continue
+		}
+		return false, err
+	}
+	return false, fmt.Errorf("storage: max retries reached")
+}
+
+func (s *RedisStorage) AddToWhitelist(ctx context.Context, ip net.IPNet, force bool, ttl time.Duration) (bool, error) {
+	return s.addIP(ctx, whitelistKey, blacklistKey, ip, force, ttl, "", "whitelist", "blacklist")
+}
+
+func (s *RedisStorage) AddToBlacklist(ctx context.Context, ip net.IPNet, force bool, ttl time.Duration) (bool, error) {
+	return s.addIP(ctx, blacklistKey, whitelistKey, ip, force, ttl, "", "blacklist", "whitelist")
+}
+
+func (s *RedisStorage) AddTaggedToBlacklist(
+	ctx context.Context,
+	ip net.IPNet,
+	force bool,
+	ttl time.Duration,
+	tag string,
+) (bool, error) {
+	return s.addIP(ctx, blacklistKey, whitelistKey, ip, force, ttl, tag, "blacklist", "whitelist")
+}
+
+// BlacklistTags leaves out the tags of entries expired but not yet reaped.
+func (s *RedisStorage) BlacklistTags(ctx context.Context) (map[string]string, error) {
+	list, err := s.cached(ctx, blacklistKey)
+	if err != nil {
+		return nil, fmt.Errorf("storage: read %s: %w", blacklistKey, err)
+	}
+	tags, err := s.rdb.HGetAll(ctx, tagKey(blacklistKey)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("storage: read %s: %w", tagKey(blacklistKey), err)
+	}
+	now := s.now()
+	maps.DeleteFunc(tags, func(key string, _ string) bool {
+		_, ok := list.nets[key]
+		return !ok || list.expired(key, now)
+	})
+	return tags, nil
+}
+
+// loadLists reads both lists.
+func (s *RedisStorage) loadLists(ctx context.Context) (white, black *ipList, err error) {
+	if white, err = s.load(ctx, s.rdb, whitelistKey); err != nil {
+		return nil, nil, fmt.Errorf("storage: read %s: %w", whitelistKey, err)
+	}
+	if black, err = s.load(ctx, s.rdb, blacklistKey); err != nil {
+		return nil, nil, fmt.Errorf("storage: read %s: %w", blacklistKey, err)
+	}
+	return white, black, nil
+}
+
+func (s *RedisStorage) BlackWhiteLists(ctx context.Context) (
+	whitelist map[string]*net.IPNet,
+	blacklist map[string]*net.IPNet,
+	err error,
+) {
+	white, black, err := s.loadLists(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return white.nets, black.nets, nil
+}
+
+func (s *RedisStorage) Expirations(ctx context.Context) (
+	whitelist map[string]time.Time,
+	blacklist map[string]time.Time,
+	err error,
+) {
+	white, black, err := s.loadLists(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return white.expires, black.expires, nil
+}
+
+func (s *RedisStorage) removeIP(ctx context.Context, key string, ip net.IPNet, listName string) (bool, error) {
+	var del *redis.IntCmd
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		del = pipe.HDel(ctx, key, ip.String())
+		pipe.ZRem(ctx, expiryKey(key), ip.String())
+		pipe.HDel(ctx, tagKey(key), ip.String())
+		pipe.Incr(ctx, versionKey(key))
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if n := del.Val(); n == 0 {
+		return false, fmt.Errorf("IP not in %s: %s", listName, ip.String())
+	}
+	return true, nil
+}
+
+func (s *RedisStorage) RemoveFromWhitelist(ctx context.Context, ip net.IPNet) (bool, error) {
+	return s.removeIP(ctx, whitelistKey, ip, "whitelist")
+}
+
+func (s *RedisStorage) RemoveFromBlacklist(ctx context.Context, ip net.IPNet) (bool, error) {
+	return s.removeIP(ctx, blacklistKey, ip, "blacklist")
+}
@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedisStorage(t *testing.T) (*RedisStorage, *redis.Client) {
+	t.Helper()
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("miniredis.Run: %v", err)
+	}
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	t.Cleanup(func() {
+		_ = rdb.Close()
+		mr.Close()
+	})
+	return NewRedisStorage(rdb), rdb
+}
+
+func TestRedisAddToWhitelist(t *testing.T) {
+	s, _ := newTestRedisStorage(t)
+	ip := mustCIDR("192.168.1.0/24")
+	ok, err := s.AddToWhitelist(t.Context(), ip, false, 0)
+	if !ok || err != nil {
+		t.Fatalf("expected success, got ok=%v err=%v", ok, err)
+	}
+	_, err = s.AddToWhitelist(t.Context(), ip, false, 0)
+	if err == nil || err.Error() != fmt.Sprintf("IP already in whitelist: %s", ip.String()) {
+		t.Errorf("expected full overlap error, got %v", err)
+	}
+	ip2 := mustCIDR("192.168.1.128/25")
+	ok, err = s.AddToWhitelist(t.Context(), ip2, false, 0)
+	if ok || err == nil || err.Error() != fmt.Sprintf("IP already in whitelist: %s", ip.String()) {
+		t.Errorf("expected full overlap (subset), got ok=%v err=%v", ok, err)
+	}
+	ip3 := mustCIDR("10.0.0.0/8")
+	if ok, err := s.AddToBlacklist(t.Context(), ip3, false, 0); !ok || err != nil {
+		t.Fatalf("expected blacklist success, got ok=%v err=%v", ok, err)
+	}
+	_, err = s.AddToWhitelist(t.Context(), ip3, false, 0)
+	if err == nil || err.Error() != fmt.Sprintf("IP overlap in blacklist: %s", ip3.String()) {
+		t.Errorf("expected blacklist overlap error, got %v", err)
+	}
+	ok, err = s.AddToWhitelist(t.Context(), ip3, true, 0)
+	if !ok || err != nil {
+		t.Errorf("expected force insert success, got ok=%v err=%v", ok, err)
+	}
+	whitelist, blacklist := lists(t, s)
+	if len(whitelist) != 2 || len(blacklist) != 1 {
+		t.Errorf("unexpected list sizes: whitelist=%d blacklist=%d", len(whitelist), len(blacklist))
+	}
+}
+
+func TestRedisOverlapReplacesSmallerNetwork(t *testing.T) {
+	s, _ := newTestRedisStorage(t)
+	small := mustCIDR("172.16.128.0/17")
+	big := mustCIDR("172.16.0.0/16")
+	if ok, err := s.AddToBlacklist(t.Context(), small, false, 0); !ok || err != nil {
+		t.Fatalf("expected success, got ok=%v err=%v", ok, err)
+	}
+	ok, err := s.AddToBlacklist(t.Context(), big, false, 0)
+	if !ok || err == nil || err.Error() != fmt.Sprintf("IP overlaps in blacklist: %s", small.String()) {
+		t.Errorf("expected overlap replacement, got ok=%v err=%v", ok, err)
+	}
+	_, blacklist := lists(t, s)
+	if _, found := blacklist[small.String()]; found {
+		t.Errorf("smaller network should be replaced")
+	}
+	if _, found := blacklist[big.String()]; !found {
+		t.Errorf("bigger network should be stored")
+	}
+	if !listed(t, s.InBlacklist, mustIP("172.16.1.1")) {
+		t.Error("expected IP to be in blacklist")
+	}
+}
+
+func TestRedisListsSurviveNewInstance(t *testing.T) {
+	s, rdb := newTestRedisStorage(t)
+	if ok, err := s.AddToWhitelist(t.Context(), mustCIDR("198.51.100.0/24"), false, 0); !ok || err != nil {
+		t.Fatalf("expected success, got ok=%v err=%v", ok, err)
+	}
+	if ok, err := s.AddToBlacklist(t.Context(), mustCIDR("203.0.113.0/24"), false, 0); !ok || err != nil {
+		t.Fatalf("expected success, got ok=%v err=%v", ok, err)
+	}
+	replica := NewRedisStorage(rdb)
+	if !listed(t, replica.InWhitelist, mustIP("198.51.100.7")) {
+		t.Error("expected IP to be in whitelist")
+	}
+	if !listed(t, replica.InBlacklist, mustIP("203.0.113.7")) {
+		t.Error("expected IP to be in blacklist")
+	}
+	if listed(t, replica.InBlacklist, mustIP("198.51.100.7")) {
+		t.Error("expected IP to NOT be in blacklist")
+	}
+	ok, err := replica.RemoveFromWhitelist(t.Context(), mustCIDR("198.51.100.0/24"))
+	if !ok || err != nil {
+		t.Fatalf("expected remove success, got ok=%v err=%v", ok, err)
+	}
+	ok, err = s.RemoveFromWhitelist(t.Context(), mustCIDR("198.51.100.0/24"))
+	if ok || err == nil {
+		t.Errorf("expected second remove to fail, got ok=%v err=%v", ok, err)
+	}
+	if listed(t, s.InWhitelist, mustIP("198.51.100.7")) {
+		t.Error("expected IP to NOT be in whitelist after removal")
+	}
+}
+
+func TestRedisListEntryTTL(t *testing.T) {
+	s, rdb := newTestRedisStorage(t)
+	// The test reaps by hand, on its own clock.
+	s.Close()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	var expired []string
+	s.OnExpire(func(list string, ip net.IPNet) {
+		expired = append(expired, list+":"+ip.String())
+	})
+	if ok, err := s.AddToWhitelist(t.Context(), mustCIDR("192.0.2.0/24"), false, time.Minute); !ok || err != nil {
+		t.Fatalf("expected success, got ok=%v err=%v", ok, err)
+	}
+	whitelist, _ := expirations(t, s)
+	if !whitelist["192.0.2.0/24"].Equal(now.Add(time.Minute)) {
+		t.Fatalf("unexpected expirations: %v", whitelist)
+	}
+	// Re-adding without a TTL must clear the old expiry.
+	if ok, err := s.AddToWhitelist(t.Context(), mustCIDR("192.0.2.0/23"), false, 0); !ok {
+		t.Fatalf("expected success, got %v", err)
+	}
+	if ok, err := s.AddToBlacklist(t.Context(), mustCIDR("203.0.113.0/24"), false, time.Minute); !ok || err != nil {
+		t.Fatalf("expected success, got ok=%v err=%v", ok, err)
+	}
+
+	now = now.Add(time.Minute)
+	replica := NewRedisStorage(rdb)
+	replica.Close()
+	replica.now = s.now
+	if listed(t, replica.InBlacklist, mustIP("203.0.113.1")) {
+		t.Error("expected expired entry to be ignored before reaping")
+	}
+	s.reap()
+	replica.reap()
+	if len(expired) != 1 || expired[0] != "blacklist:203.0.113.0/24" {
+		t.Fatalf("expected one expiry event, got %v", expired)
+	}
+	if n, _ := rdb.HLen(context.Background(), blacklistKey).Result(); n != 0 {
+		t.Errorf("expected expired entry to be deleted, %d left", n)
+	}
+	if !listed(t, s.InWhitelist, mustIP("192.0.3.1")) {
+		t.Error("expected permanent entry to stay")
+	}
+}
+
+func TestRedisCoveredEntries(t *testing.T) {
+	s, _ := newTestRedisStorage(t)
+	s.Close()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	testCoveredEntries(t, s, &now, s.reap)
+}
+
+func TestRedisLookupCache(t *testing.T) {
+	s, rdb := newTestRedisStorage(t)
+	replica := NewRedisStorage(rdb)
+	t.Cleanup(replica.Close)
+	if listed(t, s.InBlacklist, mustIP("203.0.113.7")) {
+		t.Fatal("expected empty blacklist")
+	}
+	if ok, err := replica.AddToBlacklist(t.Context(), mustCIDR("203.0.113.0/24"), false, 0); !ok || err != nil {
+		t.Fatalf("expected success, got ok=%v err=%v", ok, err)
+	}
+	if !listed(t, s.InBlacklist, mustIP("203.0.113.7")) {
+		t.Fatal("expected the cached list to pick up the replica's change")
+	}
+	// An unchanged version must be served from the cache.
+	if err := rdb.HDel(t.Context(), blacklistKey, "203.0.113.0/24").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !listed(t, s.InBlacklist, mustIP("203.0.113.7")) {
+		t.Fatal("expected the lookup to be served from the cache")
+	}
+	if ok, err := replica.RemoveFromBlacklist(t.Context(), mustCIDR("203.0.113.0/24")); ok || err == nil {
+		t.Fatalf("expected remove of a missing entry to fail, got ok=%v err=%v", ok, err)
+	}
+	if listed(t, s.InBlacklist, mustIP("203.0.113.7")) {
+		t.Fatal("expected the cache to be dropped after a change")
+	}
+}
+
+func TestRedisLookupError(t *testing.T) {
+	s, rdb := newTestRedisStorage(t)
+	_ = rdb.Close()
+	if found, err := s.InBlacklist(t.Context(), mustIP("203.0.113.7")); err == nil || found {
+		t.Fatalf("expected a read error, got found=%v err=%v", found, err)
+	}
+	if _, _, err := s.BlackWhiteLists(t.Context()); err == nil {
+		t.Fatal("expected BlackWhiteLists to report the read error, not empty lists")
+	}
+	if _, _, err := s.Expirations(t.Context()); err == nil {
+		t.Fatal("expected Expirations to report the read error")
+	}
+	if _, err := s.BlacklistTags(t.Context()); err == nil {
+		t.Fatal("expected BlacklistTags to report the read error")
+	}
+}
+
+func TestRedisBlacklistTags(t *testing.T) {
+	s, _ := newTestRedisStorage(t)
+	testBlacklistTags(t, s)
+}
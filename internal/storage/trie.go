@@ -0,0 +1,289 @@
+package storage
+
+import (
+	"net"
+	"time"
+)
+
+// trieNode is a node of a binary prefix trie. A node at depth d stands for
+// the d-bit prefix spelled by the path from the root; net is set when a list
+// entry ends there.
+type trieNode struct {
+	child [2]*trieNode
+	key   string
+	net   *net.IPNet
+}
+
+// ipList keeps the entries of one list by key together with a prefix trie
+// per address family, so that lookups cost O(address bits) instead of a scan.
+// Entries with a TTL also have their expiry time in expires, tagged entries
+// their tag in tags.
+type ipList struct {
+	nets    map[string]*net.IPNet
+	expires map[string]time.Time
+	tags    map[string]string
+	v4      *trieNode
+	v6      *trieNode
+}
+
+func newIPList() *ipList {
+	return &ipList{
+		nets:    make(map[string]*net.IPNet),
+		expires: make(map[string]time.Time),
+		tags:    make(map[string]string),
+		v4:      &trieNode{},
+		v6:      &trieNode{},
+	}
+}
+
+func bit(addr []byte, i int) int {
+	return int(addr[i/8]>>(7-uint(i%8))) & 1
+}
+
+// prefix returns the root for n's family, its address bytes and prefix length.
+func (l *ipList) prefix(n *net.IPNet) (*trieNode, []byte, int) {
+	ones, bits := n.Mask.Size()
+	if ip4 := n.IP.To4(); ip4 != nil {
+		if bits == 8*net.IPv6len {
+			ones -= 8 * (net.IPv6len - net.IPv4len)
+		}
+		return l.v4, ip4, ones
+	}
+	return l.v6, n.IP.To16(), ones
+}
+
+func (l *ipList) addr(ip net.IP) (*trieNode, []byte) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return l.v4, ip4
+	}
+	return l.v6, ip.To16()
+}
+
+func (l *ipList) put(key string, n *net.IPNet) {
+	node, addr, ones := l.prefix(n)
+	if addr == nil || ones < 0 {
+		return
+	}
+	for i := 0; i < ones; i++ {
+		b := bit(addr, i)
+		if node.child[b] == nil {
+			node.child[b] = &trieNode{}
+		}
+		node = node.child[b]
+	}
+	if node.net != nil && node.key != key {
+		delete(l.nets, node.key)
+		delete(l.expires, node.key)
+		delete(l.tags, node.key)
+	}
+	node.key = key
+	node.net = n
+	l.nets[key] = n
+}
+
+func (l *ipList) remove(key string) bool {
+	n, ok := l.nets[key]
+	if !ok {
+		return false
+	}
+	delete(l.nets, key)
+	delete(l.expires, key)
+	delete(l.tags, key)
+	root, addr, ones := l.prefix(n)
+	if addr == nil || ones < 0 {
+		return true
+	}
+	path := make([]*trieNode, 0, ones+1)
+	node := root
+	for i := 0; i < ones && node != nil; i++ {
+		path = append(path, node)
+		node = node.child[bit(addr, i)]
+	}
+	if node == nil || node.key != key {
+		return true
+	}
+	node.key, node.net = "", nil
+	// Prune the branch that no longer leads to any entry.
+	for i := len(path) - 1; i >= 0; i-- {
+		if node.net != nil || node.child[0] != nil || node.child[1] != nil {
+			break
+		}
+		path[i].child[bit(addr, i)] = nil
+		node = path[i]
+	}
+	return true
+}
+
+// setExpiry makes key expire at t, or never if t is zero.
+func (l *ipList) setExpiry(key string, t time.Time) {
+	if t.IsZero() {
+		delete(l.expires, key)
+		return
+	}
+	l.expires[key] = t
+}
+
+// setTag tags key with tag, or drops its tag if tag is empty.
+func (l *ipList) setTag(key string, tag string) {
+	if tag == "" {
+		delete(l.tags, key)
+		return
+	}
+	l.tags[key] = tag
+}
+
+// liveTags returns the tags of the entries not expired by now.
+func (l *ipList) liveTags(now time.Time) map[string]string {
+	tags := make(map[string]string, len(l.tags))
+	for key, tag := range l.tags {
+		if !l.expired(key, now) {
+			tags[key] = tag
+		}
+	}
+	return tags
+}
+
+// expired reports whether key has a TTL that ran out by now.
+func (l *ipList) expired(key string, now time.Time) bool {
+	t, ok := l.expires[key]
+	return ok && !now.Before(t)
+}
+
+// expiredKeys returns the keys whose TTL ran out by now.
+func (l *ipList) expiredKeys(now time.Time) []string {
+	var keys []string
+	for key := range l.expires {
+		if l.expired(key, now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// matchNode returns the node of the longest (most specific) entry containing ip.
+func (l *ipList) matchNode(ip net.IP) *trieNode {
+	node, addr := l.addr(ip)
+	if addr == nil {
+		return nil
+	}
+	var found *trieNode
+	for i := 0; node != nil; i++ {
+		if node.net != nil {
+			found = node
+		}
+		if i == len(addr)*8 {
+			break
+		}
+		node = node.child[bit(addr, i)]
+	}
+	return found
+}
+
+// match returns the longest (most specific) entry containing ip.
+func (l *ipList) match(ip net.IP) *net.IPNet {
+	if node := l.matchNode(ip); node != nil {
+		return node.net
+	}
+	return nil
+}
+
+func (l *ipList) contains(ip net.IP) bool {
+	return l.match(ip) != nil
+}
+
+// containsAt is contains ignoring entries expired by now. An entry may nest
+// inside a wider one that expires sooner, so every entry on the path counts.
+func (l *ipList) containsAt(ip net.IP, now time.Time) bool {
+	node, addr := l.addr(ip)
+	if addr == nil {
+		return false
+	}
+	for i := 0; node != nil; i++ {
+		if node.net != nil && !l.expired(node.key, now) {
+			return true
+		}
+		if i == len(addr)*8 {
+			break
+		}
+		node = node.child[bit(addr, i)]
+	}
+	return false
+}
+
+// coveringKeys returns the keys of the entries that contain n or are equal
+// to it, widest first.
+func (l *ipList) coveringKeys(n *net.IPNet) []string {
+	node, addr, ones := l.prefix(n)
+	if addr == nil || ones < 0 {
+		return nil
+	}
+	var keys []string
+	for i := 0; node != nil; i++ {
+		if node.net != nil {
+			keys = append(keys, node.key)
+		}
+		if i == ones {
+			break
+		}
+		node = node.child[bit(addr, i)]
+	}
+	return keys
+}
+
+// covering returns the widest entry that contains n or is equal to it.
+func (l *ipList) covering(n *net.IPNet) *net.IPNet {
+	node, addr, ones := l.prefix(n)
+	if addr == nil || ones < 0 {
+		return nil
+	}
+	for i := 0; node != nil; i++ {
+		if node.net != nil {
+			return node.net
+		}
+		if i == ones {
+			break
+		}
+		node = node.child[bit(addr, i)]
+	}
+	return nil
+}
+
+// covered returns the keys of entries strictly inside n.
+func (l *ipList) covered(n *net.IPNet) []string {
+	node, addr, ones := l.prefix(n)
+	if addr == nil || ones < 0 {
+		return nil
+	}
+	for i := 0; i < ones && node != nil; i++ {
+		node = node.child[bit(addr, i)]
+	}
+	if node == nil {
+		return nil
+	}
+	var keys []string
+	var walk func(*trieNode)
+	walk = func(t *trieNode) {
+		if t == nil {
+			return
+		}
+		if t.net != nil {
+			keys = append(keys, t.key)
+		}
+		walk(t.child[0])
+		walk(t.child[1])
+	}
+	walk(node.child[0])
+	walk(node.child[1])
+	return keys
+}
+
+// overlapping returns any entry that contains n or lies inside it.
+func (l *ipList) overlapping(n *net.IPNet) *net.IPNet {
+	if c := l.covering(n); c != nil {
+		return c
+	}
+	if keys := l.covered(n); len(keys) > 0 {
+		return l.nets[keys[0]]
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+)
+
+func TestIPListLongestMatch(t *testing.T) {
+	l := newIPList()
+	for _, cidr := range []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "2001:db8::/32", "2001:db8:1::/48"} {
+		n := mustCIDR(cidr)
+		l.put(n.String(), &n)
+	}
+	tests := []struct {
+		ip     string
+		expect string
+	}{
+		{"10.1.2.3", "10.1.2.0/24"},
+		{"10.1.3.3", "10.1.0.0/16"},
+		{"10.2.0.1", "10.0.0.0/8"},
+		{"11.0.0.1", ""},
+		{"2001:db8:1::1", "2001:db8:1::/48"},
+		{"2001:db8:2::1", "2001:db8::/32"},
+		{"2001:db9::1", ""},
+		{"::ffff:10.1.2.3", "10.1.2.0/24"},
+	}
+	for _, tt := range tests {
+		got := l.match(mustIP(tt.ip))
+		switch {
+		case tt.expect == "" && got != nil:
+			t.Errorf("match(%s) = %s, want none", tt.ip, got)
+		case tt.expect != "" && (got == nil || got.String() != tt.expect):
+			t.Errorf("match(%s) = %v, want %s", tt.ip, got, tt.expect)
+		}
+	}
+}
+
+func TestIPListRemovePrunes(t *testing.T) {
+	l := newIPList()
+	a := mustCIDR("192.168.0.0/16")
+	b := mustCIDR("192.168.10.0/24")
+	l.put(a.String(), &a)
+	l.put(b.String(), &b)
+	if !l.remove(b.String()) {
+		t.Fatal("expected remove to succeed")
+	}
+	if l.remove(b.String()) {
+		t.Fatal("expected second remove to fail")
+	}
+	if got := l.match(mustIP("192.168.10.1")); got == nil || got.String() != a.String() {
+		t.Errorf("expected %s after removal, got %v", a.String(), got)
+	}
+	if !l.remove(a.String()) {
+		t.Fatal("expected remove to succeed")
+	}
+	if l.v4.child[0] != nil || l.v4.child[1] != nil {
+		t.Error("expected empty trie after removing every entry")
+	}
+}
+
+func TestIPListCoveringAndCovered(t *testing.T) {
+	l := newIPList()
+	for _, cidr := range []string{"172.16.1.0/24", "172.16.2.0/24", "172.17.0.0/16"} {
+		n := mustCIDR(cidr)
+		l.put(n.String(), &n)
+	}
+	wide := mustCIDR("172.16.0.0/16")
+	if c := l.covering(&wide); c != nil {
+		t.Errorf("expected nothing to cover %s, got %s", wide.String(), c)
+	}
+	if keys := l.covered(&wide); len(keys) != 2 {
+		t.Errorf("expected two networks inside %s, got %v", wide.String(), keys)
+	}
+	narrow := mustCIDR("172.17.5.0/24")
+	if c := l.covering(&narrow); c == nil || c.String() != "172.17.0.0/16" {
+		t.Errorf("expected 172.17.0.0/16 to cover %s, got %v", narrow.String(), c)
+	}
+	if o := l.overlapping(&narrow); o == nil {
+		t.Errorf("expected overlap for %s", narrow.String())
+	}
+	other := mustCIDR("2001:db8::/32")
+	if o := l.overlapping(&other); o != nil {
+		t.Errorf("IPv6 network must not overlap IPv4 entries, got %s", o)
+	}
+}
+
+func TestIPListMatchesLinearScan(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1)) //nolint: gosec
+	l := newIPList()
+	nets := make([]*net.IPNet, 0, 2000)
+	for i := 0; i < 2000; i++ {
+		ip := net.IPv4(10, byte(rnd.Intn(4)), byte(rnd.Intn(256)), byte(rnd.Intn(256)))
+		n := &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(16+rnd.Intn(17), 32)}
+		n.IP = n.IP.Mask(n.Mask)
+		l.put(n.String(), n)
+		nets = append(nets, n)
+	}
+	for i := 0; i < 10000; i++ {
+		ip := net.IPv4(10, byte(rnd.Intn(8)), byte(rnd.Intn(256)), byte(rnd.Intn(256)))
+		linear := false
+		for _, n := range nets {
+			if n.Contains(ip) {
+				linear = true
+				break
+			}
+		}
+		if got := l.contains(ip); got != linear {
+			t.Fatalf("contains(%s) = %v, linear scan says %v", ip, got, linear)
+		}
+	}
+}
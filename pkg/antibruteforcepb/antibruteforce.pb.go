@@ -0,0 +1,730 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: antibruteforce.proto
+
+package antibruteforcepb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type AuthorizeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Login         string                 `protobuf:"bytes,1,opt,name=login,proto3" json:"login,omitempty"`
+	Password      string                 `protobuf:"bytes,2,opt,name=password,proto3" json:"password,omitempty"`
+	Ip            string                 `protobuf:"bytes,3,opt,name=ip,proto3" json:"ip,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AuthorizeRequest) Reset() {
+	*x = AuthorizeRequest{}
+	mi := &file_antibruteforce_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AuthorizeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AuthorizeRequest) ProtoMessage() {}
+
+func (x *AuthorizeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_antibruteforce_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AuthorizeRequest.ProtoReflect.Descriptor instead.
+func (*AuthorizeRequest) Descriptor() ([]byte, []int) {
+	return file_antibruteforce_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *AuthorizeRequest) GetLogin() string {
+	if x != nil {
+		return x.Login
+	}
+	return ""
+}
+
+func (x *AuthorizeRequest) GetPassword() string {
+	if x != nil {
+		return x.Password
+	}
+	return ""
+}
+
+func (x *AuthorizeRequest) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+type AuthorizeResponse struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Ok     bool                   `protobuf:"varint,1,opt,name=ok,proto3" json:"ok,omitempty"`
+	Reason string                 `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	// mode is one of normal, fail-open, fail-closed or local.
+	Mode string `protobuf:"bytes,3,opt,name=mode,proto3" json:"mode,omitempty"`
+	// subnet names the subnet buckets that denied the attempt, e.g.
+	// "ipv4/24", comma separated when there are several.
+	Subnet        string `protobuf:"bytes,4,opt,name=subnet,proto3" json:"subnet,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AuthorizeResponse) Reset() {
+	*x = AuthorizeResponse{}
+	mi := &file_antibruteforce_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AuthorizeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AuthorizeResponse) ProtoMessage() {}
+
+func (x *AuthorizeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_antibruteforce_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AuthorizeResponse.ProtoReflect.Descriptor instead.
+func (*AuthorizeResponse) Descriptor() ([]byte, []int) {
+	return file_antibruteforce_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *AuthorizeResponse) GetOk() bool {
+	if x != nil {
+		return x.Ok
+	}
+	return false
+}
+
+func (x *AuthorizeResponse) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *AuthorizeResponse) GetMode() string {
+	if x != nil {
+		return x.Mode
+	}
+	return ""
+}
+
+func (x *AuthorizeResponse) GetSubnet() string {
+	if x != nil {
+		return x.Subnet
+	}
+	return ""
+}
+
+// ReportRequest tells the outcome of an authorized login attempt.
+type ReportRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Login         string                 `protobuf:"bytes,1,opt,name=login,proto3" json:"login,omitempty"`
+	Password      string                 `protobuf:"bytes,2,opt,name=password,proto3" json:"password,omitempty"`
+	Ip            string                 `protobuf:"bytes,3,opt,name=ip,proto3" json:"ip,omitempty"`
+	Success       bool                   `protobuf:"varint,4,opt,name=success,proto3" json:"success,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReportRequest) Reset() {
+	*x = ReportRequest{}
+	mi := &file_antibruteforce_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReportRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReportRequest) ProtoMessage() {}
+
+func (x *ReportRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_antibruteforce_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReportRequest.ProtoReflect.Descriptor instead.
+func (*ReportRequest) Descriptor() ([]byte, []int) {
+	return file_antibruteforce_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ReportRequest) GetLogin() string {
+	if x != nil {
+		return x.Login
+	}
+	return ""
+}
+
+func (x *ReportRequest) GetPassword() string {
+	if x != nil {
+		return x.Password
+	}
+	return ""
+}
+
+func (x *ReportRequest) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+func (x *ReportRequest) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+type ReportResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ok            bool                   `protobuf:"varint,1,opt,name=ok,proto3" json:"ok,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReportResponse) Reset() {
+	*x = ReportResponse{}
+	mi := &file_antibruteforce_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReportResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReportResponse) ProtoMessage() {}
+
+func (x *ReportResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_antibruteforce_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReportResponse.ProtoReflect.Descriptor instead.
+func (*ReportResponse) Descriptor() ([]byte, []int) {
+	return file_antibruteforce_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ReportResponse) GetOk() bool {
+	if x != nil {
+		return x.Ok
+	}
+	return false
+}
+
+// ResetBucketRequest resets the buckets of login and of ip. With both empty
+// every bucket is reset.
+type ResetBucketRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Login         string                 `protobuf:"bytes,1,opt,name=login,proto3" json:"login,omitempty"`
+	Ip            string                 `protobuf:"bytes,2,opt,name=ip,proto3" json:"ip,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResetBucketRequest) Reset() {
+	*x = ResetBucketRequest{}
+	mi := &file_antibruteforce_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResetBucketRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResetBucketRequest) ProtoMessage() {}
+
+func (x *ResetBucketRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_antibruteforce_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResetBucketRequest.ProtoReflect.Descriptor instead.
+func (*ResetBucketRequest) Descriptor() ([]byte, []int) {
+	return file_antibruteforce_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ResetBucketRequest) GetLogin() string {
+	if x != nil {
+		return x.Login
+	}
+	return ""
+}
+
+func (x *ResetBucketRequest) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+type ResetBucketResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ok            bool                   `protobuf:"varint,1,opt,name=ok,proto3" json:"ok,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResetBucketResponse) Reset() {
+	*x = ResetBucketResponse{}
+	mi := &file_antibruteforce_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResetBucketResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResetBucketResponse) ProtoMessage() {}
+
+func (x *ResetBucketResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_antibruteforce_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResetBucketResponse.ProtoReflect.Descriptor instead.
+func (*ResetBucketResponse) Descriptor() ([]byte, []int) {
+	return file_antibruteforce_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ResetBucketResponse) GetOk() bool {
+	if x != nil {
+		return x.Ok
+	}
+	return false
+}
+
+type ListRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// ip is a CIDR network or a single address.
+	Ip    string `protobuf:"bytes,1,opt,name=ip,proto3" json:"ip,omitempty"`
+	Force bool   `protobuf:"varint,2,opt,name=force,proto3" json:"force,omitempty"`
+	// ttl makes the entry expire, unset keeps it until removed. A zero or
+	// negative ttl is rejected with INVALID_ARGUMENT.
+	Ttl           *durationpb.Duration `protobuf:"bytes,3,opt,name=ttl,proto3" json:"ttl,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRequest) Reset() {
+	*x = ListRequest{}
+	mi := &file_antibruteforce_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRequest) ProtoMessage() {}
+
+func (x *ListRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_antibruteforce_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRequest.ProtoReflect.Descriptor instead.
+func (*ListRequest) Descriptor() ([]byte, []int) {
+	return file_antibruteforce_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ListRequest) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+func (x *ListRequest) GetForce() bool {
+	if x != nil {
+		return x.Force
+	}
+	return false
+}
+
+func (x *ListRequest) GetTtl() *durationpb.Duration {
+	if x != nil {
+		return x.Ttl
+	}
+	return nil
+}
+
+type ListResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ok            bool                   `protobuf:"varint,1,opt,name=ok,proto3" json:"ok,omitempty"`
+	Reason        string                 `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListResponse) Reset() {
+	*x = ListResponse{}
+	mi := &file_antibruteforce_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListResponse) ProtoMessage() {}
+
+func (x *ListResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_antibruteforce_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListResponse.ProtoReflect.Descriptor instead.
+func (*ListResponse) Descriptor() ([]byte, []int) {
+	return file_antibruteforce_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ListResponse) GetOk() bool {
+	if x != nil {
+		return x.Ok
+	}
+	return false
+}
+
+func (x *ListResponse) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+type ListListsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListListsRequest) Reset() {
+	*x = ListListsRequest{}
+	mi := &file_antibruteforce_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListListsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListListsRequest) ProtoMessage() {}
+
+func (x *ListListsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_antibruteforce_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListListsRequest.ProtoReflect.Descriptor instead.
+func (*ListListsRequest) Descriptor() ([]byte, []int) {
+	return file_antibruteforce_proto_rawDescGZIP(), []int{8}
+}
+
+type ListListsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Whitelist     []string               `protobuf:"bytes,1,rep,name=whitelist,proto3" json:"whitelist,omitempty"`
+	Blacklist     []string               `protobuf:"bytes,2,rep,name=blacklist,proto3" json:"blacklist,omitempty"`
+	AutoBlacklist []string               `protobuf:"bytes,3,rep,name=auto_blacklist,json=autoBlacklist,proto3" json:"auto_blacklist,omitempty"`
+	// whitelist_expires_in and blacklist_expires_in hold the seconds left for
+	// the entries of each list that expire, by network.
+	WhitelistExpiresIn map[string]int64 `protobuf:"bytes,5,rep,name=whitelist_expires_in,json=whitelistExpiresIn,proto3" json:"whitelist_expires_in,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	BlacklistExpiresIn map[string]int64 `protobuf:"bytes,6,rep,name=blacklist_expires_in,json=blacklistExpiresIn,proto3" json:"blacklist_expires_in,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *ListListsResponse) Reset() {
+	*x = ListListsResponse{}
+	mi := &file_antibruteforce_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListListsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListListsResponse) ProtoMessage() {}
+
+func (x *ListListsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_antibruteforce_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListListsResponse.ProtoReflect.Descriptor instead.
+func (*ListListsResponse) Descriptor() ([]byte, []int) {
+	return file_antibruteforce_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ListListsResponse) GetWhitelist() []string {
+	if x != nil {
+		return x.Whitelist
+	}
+	return nil
+}
+
+func (x *ListListsResponse) GetBlacklist() []string {
+	if x != nil {
+		return x.Blacklist
+	}
+	return nil
+}
+
+func (x *ListListsResponse) GetAutoBlacklist() []string {
+	if x != nil {
+		return x.AutoBlacklist
+	}
+	return nil
+}
+
+func (x *ListListsResponse) GetWhitelistExpiresIn() map[string]int64 {
+	if x != nil {
+		return x.WhitelistExpiresIn
+	}
+	return nil
+}
+
+func (x *ListListsResponse) GetBlacklistExpiresIn() map[string]int64 {
+	if x != nil {
+		return x.BlacklistExpiresIn
+	}
+	return nil
+}
+
+var File_antibruteforce_proto protoreflect.FileDescriptor
+
+const file_antibruteforce_proto_rawDesc = "" +
+	"\n" +
+	"\x14antibruteforce.proto\x12\x11antibruteforce.v1\x1a\x1egoogle/protobuf/duration.proto\"T\n" +
+	"\x10AuthorizeRequest\x12\x14\n" +
+	"\x05login\x18\x01 \x01(\tR\x05login\x12\x1a\n" +
+	"\bpassword\x18\x02 \x01(\tR\bpassword\x12\x0e\n" +
+	"\x02ip\x18\x03 \x01(\tR\x02ip\"g\n" +
+	"\x11AuthorizeResponse\x12\x0e\n" +
+	"\x02ok\x18\x01 \x01(\bR\x02ok\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason\x12\x12\n" +
+	"\x04mode\x18\x03 \x01(\tR\x04mode\x12\x16\n" +
+	"\x06subnet\x18\x04 \x01(\tR\x06subnet\"k\n" +
+	"\rReportRequest\x12\x14\n" +
+	"\x05login\x18\x01 \x01(\tR\x05login\x12\x1a\n" +
+	"\bpassword\x18\x02 \x01(\tR\bpassword\x12\x0e\n" +
+	"\x02ip\x18\x03 \x01(\tR\x02ip\x12\x18\n" +
+	"\asuccess\x18\x04 \x01(\bR\asuccess\" \n" +
+	"\x0eReportResponse\x12\x0e\n" +
+	"\x02ok\x18\x01 \x01(\bR\x02ok\":\n" +
+	"\x12ResetBucketRequest\x12\x14\n" +
+	"\x05login\x18\x01 \x01(\tR\x05login\x12\x0e\n" +
+	"\x02ip\x18\x02 \x01(\tR\x02ip\"%\n" +
+	"\x13ResetBucketResponse\x12\x0e\n" +
+	"\x02ok\x18\x01 \x01(\bR\x02ok\"`\n" +
+	"\vListRequest\x12\x0e\n" +
+	"\x02ip\x18\x01 \x01(\tR\x02ip\x12\x14\n" +
+	"\x05force\x18\x02 \x01(\bR\x05force\x12+\n" +
+	"\x03ttl\x18\x03 \x01(\v2\x19.google.protobuf.DurationR\x03ttl\"6\n" +
+	"\fListResponse\x12\x0e\n" +
+	"\x02ok\x18\x01 \x01(\bR\x02ok\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason\"\x12\n" +
+	"\x10ListListsRequest\"\xf6\x03\n" +
+	"\x11ListListsResponse\x12\x1c\n" +
+	"\twhitelist\x18\x01 \x03(\tR\twhitelist\x12\x1c\n" +
+	"\tblacklist\x18\x02 \x03(\tR\tblacklist\x12%\n" +
+	"\x0eauto_blacklist\x18\x03 \x03(\tR\rautoBlacklist\x12n\n" +
+	"\x14whitelist_expires_in\x18\x05 \x03(\v2<.antibruteforce.v1.ListListsResponse.WhitelistExpiresInEntryR\x12whitelistExpiresIn\x12n\n" +
+	"\x14blacklist_expires_in\x18\x06 \x03(\v2<.antibruteforce.v1.ListListsResponse.BlacklistExpiresInEntryR\x12blacklistExpiresIn\x1aE\n" +
+	"\x17WhitelistExpiresInEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x03R\x05value:\x028\x01\x1aE\n" +
+	"\x17BlacklistExpiresInEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x03R\x05value:\x028\x01J\x04\b\x04\x10\x05R\n" +
+	"expires_in2\xc3\x05\n" +
+	"\x0eAntiBruteforce\x12V\n" +
+	"\tAuthorize\x12#.antibruteforce.v1.AuthorizeRequest\x1a$.antibruteforce.v1.AuthorizeResponse\x12M\n" +
+	"\x06Report\x12 .antibruteforce.v1.ReportRequest\x1a!.antibruteforce.v1.ReportResponse\x12\\\n" +
+	"\vResetBucket\x12%.antibruteforce.v1.ResetBucketRequest\x1a&.antibruteforce.v1.ResetBucketResponse\x12Q\n" +
+	"\x0eAddToWhitelist\x12\x1e.antibruteforce.v1.ListRequest\x1a\x1f.antibruteforce.v1.ListResponse\x12Q\n" +
+	"\x0eAddToBlacklist\x12\x1e.antibruteforce.v1.ListRequest\x1a\x1f.antibruteforce.v1.ListResponse\x12V\n" +
+	"\x13RemoveFromWhitelist\x12\x1e.antibruteforce.v1.ListRequest\x1a\x1f.antibruteforce.v1.ListResponse\x12V\n" +
+	"\x13RemoveFromBlacklist\x12\x1e.antibruteforce.v1.ListRequest\x1a\x1f.antibruteforce.v1.ListResponse\x12V\n" +
+	"\tListLists\x12#.antibruteforce.v1.ListListsRequest\x1a$.antibruteforce.v1.ListListsResponseBIZGgithub.com/meladark/special-train/pkg/antibruteforcepb;antibruteforcepbb\x06proto3"
+
+var (
+	file_antibruteforce_proto_rawDescOnce sync.Once
+	file_antibruteforce_proto_rawDescData []byte
+)
+
+func file_antibruteforce_proto_rawDescGZIP() []byte {
+	file_antibruteforce_proto_rawDescOnce.Do(func() {
+		file_antibruteforce_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_antibruteforce_proto_rawDesc), len(file_antibruteforce_proto_rawDesc)))
+	})
+	return file_antibruteforce_proto_rawDescData
+}
+
+var file_antibruteforce_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
+var file_antibruteforce_proto_goTypes = []any{
+	(*AuthorizeRequest)(nil),    // 0: antibruteforce.v1.AuthorizeRequest
+	(*AuthorizeResponse)(nil),   // 1: antibruteforce.v1.AuthorizeResponse
+	(*ReportRequest)(nil),       // 2: antibruteforce.v1.ReportRequest
+	(*ReportResponse)(nil),      // 3: antibruteforce.v1.ReportResponse
+	(*ResetBucketRequest)(nil),  // 4: antibruteforce.v1.ResetBucketRequest
+	(*ResetBucketResponse)(nil), // 5: antibruteforce.v1.ResetBucketResponse
+	(*ListRequest)(nil),         // 6: antibruteforce.v1.ListRequest
+	(*ListResponse)(nil),        // 7: antibruteforce.v1.ListResponse
+	(*ListListsRequest)(nil),    // 8: antibruteforce.v1.ListListsRequest
+	(*ListListsResponse)(nil),   // 9: antibruteforce.v1.ListListsResponse
+	nil,                         // 10: antibruteforce.v1.ListListsResponse.WhitelistExpiresInEntry
+	nil,                         // 11: antibruteforce.v1.ListListsResponse.BlacklistExpiresInEntry
+	(*durationpb.Duration)(nil), // 12: google.protobuf.Duration
+}
+var file_antibruteforce_proto_depIdxs = []int32{
+	12, // 0: antibruteforce.v1.ListRequest.ttl:type_name -> google.protobuf.Duration
+	10, // 1: antibruteforce.v1.ListListsResponse.whitelist_expires_in:type_name -> antibruteforce.v1.ListListsResponse.WhitelistExpiresInEntry
+	11, // 2: antibruteforce.v1.ListListsResponse.blacklist_expires_in:type_name -> antibruteforce.v1.ListListsResponse.BlacklistExpiresInEntry
+	0,  // 3: antibruteforce.v1.AntiBruteforce.Authorize:input_type -> antibruteforce.v1.AuthorizeRequest
+	2,  // 4: antibruteforce.v1.AntiBruteforce.Report:input_type -> antibruteforce.v1.ReportRequest
+	4,  // 5: antibruteforce.v1.AntiBruteforce.ResetBucket:input_type -> antibruteforce.v1.ResetBucketRequest
+	6,  // 6: antibruteforce.v1.AntiBruteforce.AddToWhitelist:input_type -> antibruteforce.v1.ListRequest
+	6,  // 7: antibruteforce.v1.AntiBruteforce.AddToBlacklist:input_type -> antibruteforce.v1.ListRequest
+	6,  // 8: antibruteforce.v1.AntiBruteforce.RemoveFromWhitelist:input_type -> antibruteforce.v1.ListRequest
+	6,  // 9: antibruteforce.v1.AntiBruteforce.RemoveFromBlacklist:input_type -> antibruteforce.v1.ListRequest
+	8,  // 10: antibruteforce.v1.AntiBruteforce.ListLists:input_type -> antibruteforce.v1.ListListsRequest
+	1,  // 11: antibruteforce.v1.AntiBruteforce.Authorize:output_type -> antibruteforce.v1.AuthorizeResponse
+	3,  // 12: antibruteforce.v1.AntiBruteforce.Report:output_type -> antibruteforce.v1.ReportResponse
+	5,  // 13: antibruteforce.v1.AntiBruteforce.ResetBucket:output_type -> antibruteforce.v1.ResetBucketResponse
+	7,  // 14: antibruteforce.v1.AntiBruteforce.AddToWhitelist:output_type -> antibruteforce.v1.ListResponse
+	7,  // 15: antibruteforce.v1.AntiBruteforce.AddToBlacklist:output_type -> antibruteforce.v1.ListResponse
+	7,  // 16: antibruteforce.v1.AntiBruteforce.RemoveFromWhitelist:output_type -> antibruteforce.v1.ListResponse
+	7,  // 17: antibruteforce.v1.AntiBruteforce.RemoveFromBlacklist:output_type -> antibruteforce.v1.ListResponse
+	9,  // 18: antibruteforce.v1.AntiBruteforce.ListLists:output_type -> antibruteforce.v1.ListListsResponse
+	11, // [11:19] is the sub-list for method output_type
+	3,  // [3:11] is the sub-list for method input_type
+	3,  // [3:3] is the sub-list for extension type_name
+	3,  // [3:3] is the sub-list for extension extendee
+	0,  // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_antibruteforce_proto_init() }
+func file_antibruteforce_proto_init() {
+	if File_antibruteforce_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_antibruteforce_proto_rawDesc), len(file_antibruteforce_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   12,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_antibruteforce_proto_goTypes,
+		DependencyIndexes: file_antibruteforce_proto_depIdxs,
+		MessageInfos:      file_antibruteforce_proto_msgTypes,
+	}.Build()
+	File_antibruteforce_proto = out.File
+	file_antibruteforce_proto_goTypes = nil
+	file_antibruteforce_proto_depIdxs = nil
+}
@@ -0,0 +1,391 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: antibruteforce.proto
+
+package antibruteforcepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	AntiBruteforce_Authorize_FullMethodName           = "/antibruteforce.v1.AntiBruteforce/Authorize"
+	AntiBruteforce_Report_FullMethodName              = "/antibruteforce.v1.AntiBruteforce/Report"
+	AntiBruteforce_ResetBucket_FullMethodName         = "/antibruteforce.v1.AntiBruteforce/ResetBucket"
+	AntiBruteforce_AddToWhitelist_FullMethodName      = "/antibruteforce.v1.AntiBruteforce/AddToWhitelist"
+	AntiBruteforce_AddToBlacklist_FullMethodName      = "/antibruteforce.v1.AntiBruteforce/AddToBlacklist"
+	AntiBruteforce_RemoveFromWhitelist_FullMethodName = "/antibruteforce.v1.AntiBruteforce/RemoveFromWhitelist"
+	AntiBruteforce_RemoveFromBlacklist_FullMethodName = "/antibruteforce.v1.AntiBruteforce/RemoveFromBlacklist"
+	AntiBruteforce_ListLists_FullMethodName           = "/antibruteforce.v1.AntiBruteforce/ListLists"
+)
+
+// AntiBruteforceClient is the client API for AntiBruteforce service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// AntiBruteforce mirrors the HTTP API under /api.
+type AntiBruteforceClient interface {
+	Authorize(ctx context.Context, in *AuthorizeRequest, opts ...grpc.CallOption) (*AuthorizeResponse, error)
+	Report(ctx context.Context, in *ReportRequest, opts ...grpc.CallOption) (*ReportResponse, error)
+	ResetBucket(ctx context.Context, in *ResetBucketRequest, opts ...grpc.CallOption) (*ResetBucketResponse, error)
+	AddToWhitelist(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	AddToBlacklist(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	RemoveFromWhitelist(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	RemoveFromBlacklist(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	ListLists(ctx context.Context, in *ListListsRequest, opts ...grpc.CallOption) (*ListListsResponse, error)
+}
+
+type antiBruteforceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAntiBruteforceClient(cc grpc.ClientConnInterface) AntiBruteforceClient {
+	return &antiBruteforceClient{cc}
+}
+
+func (c *antiBruteforceClient) Authorize(ctx context.Context, in *AuthorizeRequest, opts ...grpc.CallOption) (*AuthorizeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AuthorizeResponse)
+	err := c.cc.Invoke(ctx, AntiBruteforce_Authorize_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *antiBruteforceClient) Report(ctx context.Context, in *ReportRequest, opts ...grpc.CallOption) (*ReportResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReportResponse)
+	err := c.cc.Invoke(ctx, AntiBruteforce_Report_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *antiBruteforceClient) ResetBucket(ctx context.Context, in *ResetBucketRequest, opts ...grpc.CallOption) (*ResetBucketResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ResetBucketResponse)
+	err := c.cc.Invoke(ctx, AntiBruteforce_ResetBucket_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *antiBruteforceClient) AddToWhitelist(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListResponse)
+	err := c.cc.Invoke(ctx, AntiBruteforce_AddToWhitelist_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *antiBruteforceClient) AddToBlacklist(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListResponse)
+	err := c.cc.Invoke(ctx, AntiBruteforce_AddToBlacklist_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *antiBruteforceClient) RemoveFromWhitelist(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListResponse)
+	err := c.cc.Invoke(ctx, AntiBruteforce_RemoveFromWhitelist_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *antiBruteforceClient) RemoveFromBlacklist(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListResponse)
+	err := c.cc.Invoke(ctx, AntiBruteforce_RemoveFromBlacklist_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *antiBruteforceClient) ListLists(ctx context.Context, in *ListListsRequest, opts ...grpc.CallOption) (*ListListsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListListsResponse)
+	err := c.cc.Invoke(ctx, AntiBruteforce_ListLists_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AntiBruteforceServer is the server API for AntiBruteforce service.
+// All implementations must embed UnimplementedAntiBruteforceServer
+// for forward compatibility.
+//
+// AntiBruteforce mirrors the HTTP API under /api.
+type AntiBruteforceServer interface {
+	Authorize(context.Context, *AuthorizeRequest) (*AuthorizeResponse, error)
+	Report(context.Context, *ReportRequest) (*ReportResponse, error)
+	ResetBucket(context.Context, *ResetBucketRequest) (*ResetBucketResponse, error)
+	AddToWhitelist(context.Context, *ListRequest) (*ListResponse, error)
+	AddToBlacklist(context.Context, *ListRequest) (*ListResponse, error)
+	RemoveFromWhitelist(context.Context, *ListRequest) (*ListResponse, error)
+	RemoveFromBlacklist(context.Context, *ListRequest) (*ListResponse, error)
+	ListLists(context.Context, *ListListsRequest) (*ListListsResponse, error)
+	mustEmbedUnimplementedAntiBruteforceServer()
+}
+
+// UnimplementedAntiBruteforceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedAntiBruteforceServer struct{}
+
+func (UnimplementedAntiBruteforceServer) Authorize(context.Context, *AuthorizeRequest) (*AuthorizeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Authorize not implemented")
+}
+func (UnimplementedAntiBruteforceServer) Report(context.Context, *ReportRequest) (*ReportResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Report not implemented")
+}
+func (UnimplementedAntiBruteforceServer) ResetBucket(context.Context, *ResetBucketRequest) (*ResetBucketResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResetBucket not implemented")
+}
+func (UnimplementedAntiBruteforceServer) AddToWhitelist(context.Context, *ListRequest) (*ListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddToWhitelist not implemented")
+}
+func (UnimplementedAntiBruteforceServer) AddToBlacklist(context.Context, *ListRequest) (*ListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddToBlacklist not implemented")
+}
+func (UnimplementedAntiBruteforceServer) RemoveFromWhitelist(context.Context, *ListRequest) (*ListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveFromWhitelist not implemented")
+}
+func (UnimplementedAntiBruteforceServer) RemoveFromBlacklist(context.Context, *ListRequest) (*ListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveFromBlacklist not implemented")
+}
+func (UnimplementedAntiBruteforceServer) ListLists(context.Context, *ListListsRequest) (*ListListsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListLists not implemented")
+}
+func (UnimplementedAntiBruteforceServer) mustEmbedUnimplementedAntiBruteforceServer() {}
+func (UnimplementedAntiBruteforceServer) testEmbeddedByValue()                        {}
+
+// UnsafeAntiBruteforceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AntiBruteforceServer will
+// result in compilation errors.
+type UnsafeAntiBruteforceServer interface {
+	mustEmbedUnimplementedAntiBruteforceServer()
+}
+
+func RegisterAntiBruteforceServer(s grpc.ServiceRegistrar, srv AntiBruteforceServer) {
+	// If the following call pancis, it indicates UnimplementedAntiBruteforceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&AntiBruteforce_ServiceDesc, srv)
+}
+
+func _AntiBruteforce_Authorize_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AuthorizeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AntiBruteforceServer).Authorize(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AntiBruteforce_Authorize_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AntiBruteforceServer).Authorize(ctx, req.(*AuthorizeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AntiBruteforce_Report_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReportRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AntiBruteforceServer).Report(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AntiBruteforce_Report_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AntiBruteforceServer).Report(ctx, req.(*ReportRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AntiBruteforce_ResetBucket_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResetBucketRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AntiBruteforceServer).ResetBucket(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AntiBruteforce_ResetBucket_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AntiBruteforceServer).ResetBucket(ctx, req.(*ResetBucketRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AntiBruteforce_AddToWhitelist_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AntiBruteforceServer).AddToWhitelist(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AntiBruteforce_AddToWhitelist_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AntiBruteforceServer).AddToWhitelist(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AntiBruteforce_AddToBlacklist_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AntiBruteforceServer).AddToBlacklist(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AntiBruteforce_AddToBlacklist_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AntiBruteforceServer).AddToBlacklist(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AntiBruteforce_RemoveFromWhitelist_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AntiBruteforceServer).RemoveFromWhitelist(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AntiBruteforce_RemoveFromWhitelist_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AntiBruteforceServer).RemoveFromWhitelist(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AntiBruteforce_RemoveFromBlacklist_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AntiBruteforceServer).RemoveFromBlacklist(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AntiBruteforce_RemoveFromBlacklist_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AntiBruteforceServer).RemoveFromBlacklist(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AntiBruteforce_ListLists_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListListsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AntiBruteforceServer).ListLists(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AntiBruteforce_ListLists_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AntiBruteforceServer).ListLists(ctx, req.(*ListListsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// AntiBruteforce_ServiceDesc is the grpc.ServiceDesc for AntiBruteforce service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var AntiBruteforce_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "antibruteforce.v1.AntiBruteforce",
+	HandlerType: (*AntiBruteforceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Authorize",
+			Handler:    _AntiBruteforce_Authorize_Handler,
+		},
+		{
+			MethodName: "Report",
+			Handler:    _AntiBruteforce_Report_Handler,
+		},
+		{
+			MethodName: "ResetBucket",
+			Handler:    _AntiBruteforce_ResetBucket_Handler,
+		},
+		{
+			MethodName: "AddToWhitelist",
+			Handler:    _AntiBruteforce_AddToWhitelist_Handler,
+		},
+		{
+			MethodName: "AddToBlacklist",
+			Handler:    _AntiBruteforce_AddToBlacklist_Handler,
+		},
+		{
+			MethodName: "RemoveFromWhitelist",
+			Handler:    _AntiBruteforce_RemoveFromWhitelist_Handler,
+		},
+		{
+			MethodName: "RemoveFromBlacklist",
+			Handler:    _AntiBruteforce_RemoveFromBlacklist_Handler,
+		},
+		{
+			MethodName: "ListLists",
+			Handler:    _AntiBruteforce_ListLists_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "antibruteforce.proto",
+}
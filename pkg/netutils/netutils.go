@@ -1,34 +1,46 @@
 package netutils
 
 import (
-	"encoding/binary"
+	"bytes"
 	"net"
 )
 
-func networkRange(n *net.IPNet) (uint32, uint32) {
-	ip4 := n.IP.To4()
-	if ip4 == nil {
-		panic("only IPv4 supported")
+// normalize returns ip in its shortest form: 4 bytes for IPv4, 16 for IPv6.
+func normalize(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
 	}
+	return ip.To16()
+}
+
+func networkRange(n *net.IPNet) (net.IP, net.IP) {
+	ip := normalize(n.IP)
 	mask := n.Mask
-	if len(mask) == 16 {
+	if len(ip) == net.IPv4len && len(mask) == net.IPv6len {
 		mask = mask[12:]
 	}
-	if len(mask) != 4 {
-		panic("mask must be convertible to 4 bytes for IPv4")
+	if ip == nil || len(mask) != len(ip) {
+		return nil, nil
+	}
+	network := make(net.IP, len(ip))
+	broadcast := make(net.IP, len(ip))
+	for i := range ip {
+		network[i] = ip[i] & mask[i]
+		broadcast[i] = network[i] | ^mask[i]
 	}
-	ipInt := binary.BigEndian.Uint32(ip4)
-	maskInt := binary.BigEndian.Uint32(mask)
-	network := ipInt & maskInt
-	broadcast := network | (^maskInt)
 	return network, broadcast
 }
 
+// Overlaps reports whether one network contains the other and returns the
+// wider of the two. Networks of different address families never overlap.
 func Overlaps(a, b *net.IPNet) (bool, *net.IPNet) {
 	aMin, aMax := networkRange(a)
 	bMin, bMax := networkRange(b)
-	aContainsB := bMin <= aMin && bMax >= aMax
-	bContainsA := aMin <= bMin && aMax >= bMax
+	if aMin == nil || bMin == nil || len(aMin) != len(bMin) {
+		return false, nil
+	}
+	aContainsB := bytes.Compare(bMin, aMin) <= 0 && bytes.Compare(bMax, aMax) >= 0
+	bContainsA := bytes.Compare(aMin, bMin) <= 0 && bytes.Compare(aMax, bMax) >= 0
 	if aContainsB {
 		return true, b
 	}
@@ -37,3 +49,22 @@ func Overlaps(a, b *net.IPNet) (bool, *net.IPNet) {
 	}
 	return false, nil
 }
+
+// HostNet returns the single-address network for ip: /32 for IPv4, /128 for IPv6.
+func HostNet(ip net.IP) *net.IPNet {
+	ip = normalize(ip)
+	bits := len(ip) * 8
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+}
+
+// ParseNet accepts either a CIDR or a bare address, which becomes a host network.
+func ParseNet(s string) (*net.IPNet, error) {
+	_, ipnet, err := net.ParseCIDR(s)
+	if err == nil {
+		return ipnet, nil
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		return HostNet(ip), nil
+	}
+	return nil, err
+}
@@ -40,17 +40,50 @@ func TestOverlaps(t *testing.T) {
 }
 
 func TestIsIPv6(t *testing.T) {
-	a := mustCIDR("2001:db8::/64")
-	b := mustCIDR("2001:db8:0:1000::/65")
-	expectedPanic := "only IPv4 supported"
-	defer func() {
-		if r := recover(); r != nil {
-			if r != expectedPanic {
-				t.Errorf("ожидалась паника: %q, получено: %q", expectedPanic, r)
-			}
-		} else {
-			t.Error("ожидалась паника, но её не было")
+	tests := []struct {
+		a, b   string
+		expect bool
+	}{
+		{"2001:db8::/64", "2001:db8:0:0:8000::/65", true}, // a включает b
+		{"2001:db8::/48", "2001:db8::/32", true},          // b включает a
+		{"2001:db8::/64", "2001:db8:0:1::/64", false},     // разные сети
+		{"2001:db8::1/128", "2001:db8::1/128", true},      // совпадают
+		{"::/0", "2001:db8::/32", true},                   // весь диапазон
+		{"2001:db8::/32", "10.0.0.0/8", false},            // разные семейства
+		{"0.0.0.0/0", "::/0", false},                      // разные семейства
+		{"::ffff:10.0.0.0/104", "10.1.0.0/16", true},      // IPv4-mapped
+	}
+
+	for _, tt := range tests {
+		a := mustCIDR(tt.a)
+		b := mustCIDR(tt.b)
+		got, _ := Overlaps(a, b)
+		if got != tt.expect {
+			t.Errorf("Overlaps(%s, %s) = %v, want %v", tt.a, tt.b, got, tt.expect)
 		}
-	}()
-	Overlaps(a, b)
+	}
+}
+
+func TestParseNet(t *testing.T) {
+	tests := []struct {
+		in     string
+		expect string
+	}{
+		{"10.0.0.1", "10.0.0.1/32"},
+		{"10.0.0.1/24", "10.0.0.0/24"},
+		{"2001:db8::1", "2001:db8::1/128"},
+		{"2001:db8::1/64", "2001:db8::/64"},
+	}
+	for _, tt := range tests {
+		n, err := ParseNet(tt.in)
+		if err != nil {
+			t.Fatalf("ParseNet(%s) err: %v", tt.in, err)
+		}
+		if n.String() != tt.expect {
+			t.Errorf("ParseNet(%s) = %s, want %s", tt.in, n.String(), tt.expect)
+		}
+	}
+	if _, err := ParseNet("not-an-ip"); err == nil {
+		t.Error("expected error for invalid input")
+	}
 }
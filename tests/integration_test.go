@@ -13,7 +13,22 @@ import (
 	"testing"
 )
 
-const baseURL = "http://localhost:8888"
+const (
+	baseURL = "http://localhost:8888"
+	// adminKey matches the AUTH_KEYS default of docker-compose.yml.
+	adminKey = "integration-admin-key"
+)
+
+// postAdmin posts body to an admin endpoint with the admin key.
+func postAdmin(path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", adminKey)
+	return http.DefaultClient.Do(req)
+}
 
 type AuthorizeResp struct {
 	Ok bool `json:"ok"`
@@ -39,7 +54,7 @@ func postAuthorize(t *testing.T, body any) bool {
 func postWhitelist(t *testing.T, ip string) {
 	t.Helper()
 	b, _ := json.Marshal(map[string]string{"ip": ip})
-	resp, err := http.Post(baseURL+"/api/whitelist/add", "application/json", bytes.NewReader(b))
+	resp, err := postAdmin("/api/whitelist/add", bytes.NewReader(b))
 	if err != nil {
 		t.Fatalf("POST /api/whitelist failed: %v", err)
 	}
@@ -52,7 +67,7 @@ func postWhitelist(t *testing.T, ip string) {
 
 func postResetBucket(t *testing.T) {
 	t.Helper()
-	resp, err := http.Post(baseURL+"/api/bucket/reset", "application/json", nil)
+	resp, err := postAdmin("/api/bucket/reset", nil)
 	if err != nil {
 		t.Fatal("POST /api/bucket/reset failed:", err)
 	}
@@ -74,7 +89,7 @@ func postResetBucket(t *testing.T) {
 func postResetBucketLogin(t *testing.T, login string) {
 	t.Helper()
 	b, _ := json.Marshal(map[string]string{"login": login})
-	resp, err := http.Post(baseURL+"/api/bucket/reset/login", "application/json", bytes.NewReader(b))
+	resp, err := postAdmin("/api/bucket/reset/login", bytes.NewReader(b))
 	if err != nil {
 		t.Fatal("POST /api/bucket/reset failed:", err)
 	}
@@ -91,7 +106,7 @@ func postResetBucketLogin(t *testing.T, login string) {
 func postResetBucketIP(t *testing.T, IP string) {
 	t.Helper()
 	b, _ := json.Marshal(map[string]string{"ip": IP})
-	resp, err := http.Post(baseURL+"/api/bucket/reset/ip", "application/json", bytes.NewReader(b))
+	resp, err := postAdmin("/api/bucket/reset/ip", bytes.NewReader(b))
 	if err != nil {
 		t.Fatal("POST /api/bucket/reset failed:", err)
 	}